@@ -0,0 +1,58 @@
+package bplustree
+
+import "cmp"
+
+// BoxedBPlusTree is a B+ tree that stores each value behind a pointer, so
+// splits, merges and rebalancing move pointers instead of copying large
+// values. Insert copies the value once into its own allocation; Search copies
+// it back out.
+//
+// Ref and the entries of Tree expose the stored pointers. Writing through
+// them changes the value held by the tree without going through Insert, so
+// neither the running aggregate nor the mutation hook of Tree sees the
+// change, and a pointer kept after its key is deleted or updated no longer
+// refers to the tree's value.
+type BoxedBPlusTree[K cmp.Ordered, V any] struct {
+	tree *BPlusTree[K, *V]
+}
+
+// NewBoxed creates a boxed B+ tree of the given degree
+func NewBoxed[K cmp.Ordered, V any](degree int) *BoxedBPlusTree[K, V] {
+	return &BoxedBPlusTree[K, V]{tree: New[K, *V](degree)}
+}
+
+// Insert adds an entry or replaces the value of an existing key
+func (t *BoxedBPlusTree[K, V]) Insert(key K, value V) {
+	t.tree.Insert(key, &value)
+}
+
+// Search returns a copy of the value stored under key
+func (t *BoxedBPlusTree[K, V]) Search(key K) (V, bool) {
+	p, ok := t.tree.Search(key)
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	return *p, true
+}
+
+// Ref returns the stored pointer for key without copying the value
+func (t *BoxedBPlusTree[K, V]) Ref(key K) (*V, bool) {
+	return t.tree.Search(key)
+}
+
+// Delete removes key and reports whether it was present
+func (t *BoxedBPlusTree[K, V]) Delete(key K) bool {
+	return t.tree.Delete(key)
+}
+
+// Len returns the number of entries
+func (t *BoxedBPlusTree[K, V]) Len() int {
+	return t.tree.Len()
+}
+
+// Tree returns the underlying tree of pointers, for range queries and the
+// rest of the BPlusTree API
+func (t *BoxedBPlusTree[K, V]) Tree() *BPlusTree[K, *V] {
+	return t.tree
+}
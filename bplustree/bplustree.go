@@ -1,31 +1,172 @@
 package bplustree
 
-import "cmp"
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"iter"
+	"math"
+	"math/rand"
+	"slices"
+)
 
 type Entry[K cmp.Ordered, V any] struct {
 	Key   K
 	Value V
 }
 
+// leafEntry is an entry as stored in a leaf, keeping the tombstone flag out
+// of the public Entry
+type leafEntry[K cmp.Ordered, V any] struct {
+	Entry[K, V]
+	deleted bool // tombstoned in soft delete mode
+}
+
 type node[K cmp.Ordered, V any] struct {
 	isLeaf   bool
 	keys     []K
 	children []*node[K, V]
-	entries  []Entry[K, V]
+	entries  []leafEntry[K, V]
 	next     *node[K, V]
+	prev     *node[K, V]
 	parent   *node[K, V]
 }
 
 type BPlusTree[K cmp.Ordered, V any] struct {
-	root   *node[K, V]
-	degree int
+	root        *node[K, V]
+	leafCap     int
+	internalCap int
+	size        int
+	aggregate   *aggregate[V]
+	hook        func(op Op, key K, value V)
+	evict       func(key K, value V)
+	softDelete  bool
+	tombstones  int         // entries marked deleted in soft delete mode, still in leaves
+	hint        *node[K, V] // leaf of the last insert, nil after a split or merge
+	splitRatio  float64     // share of entries kept on the left by a split, 0 for an even split
+	duplicates  DuplicatePolicy
+	maxHeight   int // levels the tree may grow to, 0 for no limit
+}
+
+// DuplicatePolicy selects what Insert does with a key that is already present
+type DuplicatePolicy int
+
+const (
+	// DuplicateOverwrite replaces the existing value. This is the default.
+	DuplicateOverwrite DuplicatePolicy = iota
+	// DuplicateIgnore keeps the existing value and drops the new one
+	DuplicateIgnore
+	// DuplicateError leaves the tree unchanged and fails: Insert panics and
+	// InsertChecked returns an error, both wrapping ErrDuplicateKey
+	DuplicateError
+)
+
+type aggregate[V any] struct {
+	zero  V
+	total V
+	add   func(V, V) V
+	sub   func(V, V) V
 }
 
+// New creates a B+ tree of the given degree. Degrees below 2, including zero
+// and negative values, are raised to 2; Degree reports the value in use.
+// Nodes hold at most 2*degree-1 entries or keys and, below the root, at
+// least degree-1, so the smallest tree has nodes of 1 to 3.
 func New[K cmp.Ordered, V any](degree int) *BPlusTree[K, V] {
 	if degree < 2 {
 		degree = 2
 	}
-	return &BPlusTree[K, V]{degree: degree}
+	return NewWithCapacities[K, V](degree*2-1, degree*2-1)
+}
+
+// NewChecked is like New but returns an error wrapping ErrInvalidDegree
+// instead of clamping a degree below 2
+func NewChecked[K cmp.Ordered, V any](degree int) (*BPlusTree[K, V], error) {
+	if degree < 2 {
+		return nil, fmt.Errorf("%w: got %d", ErrInvalidDegree, degree)
+	}
+	return New[K, V](degree), nil
+}
+
+// NewWithCapacities creates a B+ tree whose leaves hold at most leafCap
+// entries and whose internal nodes hold at most internalCap keys. Small
+// leaves suit large values, while wide internal nodes keep the tree shallow.
+// Capacities below 3 are raised to 3. Nodes other than the root are kept at
+// least half full, rounding down: a leaf capacity of 4 keeps 2 entries per
+// leaf, one of 5 keeps 2 as well.
+func NewWithCapacities[K cmp.Ordered, V any](leafCap, internalCap int) *BPlusTree[K, V] {
+	return &BPlusTree[K, V]{
+		leafCap:     max(leafCap, 3),
+		internalCap: max(internalCap, 3),
+	}
+}
+
+// NewWithSplitRatio creates a B+ tree of the given degree, clamped like New,
+// whose overflowing nodes keep the given share of their entries on the left
+// when they split, instead of half. A ratio above one half suits keys
+// inserted in increasing order, as in the classic append optimization: the
+// left node stays fuller and the right one takes the following inserts, so
+// sequential loads leave fuller leaves than even splits do. Both sides of
+// every split must stay at minimum occupancy, half full, which bounds the
+// effect: of the 2*degree entries of a splitting leaf the left may keep
+// degree+1 at most. Only ratios near one half qualify, from about 0.44 to
+// 0.56 at degree 8, narrowing as the degree grows; any other ratio yields an
+// error wrapping ErrInvalidSplitRatio.
+func NewWithSplitRatio[K cmp.Ordered, V any](degree int, ratio float64) (*BPlusTree[K, V], error) {
+	t := New[K, V](degree)
+	if !(ratio > 0 && ratio < 1) || !t.splitRatioFits(ratio) {
+		return nil, fmt.Errorf("%w: got %v for degree %d", ErrInvalidSplitRatio, ratio, t.Degree())
+	}
+	t.splitRatio = ratio
+	return t, nil
+}
+
+// NewWithDuplicatePolicy creates a B+ tree of the given degree, clamped like
+// New, whose Insert, InsertChecked and InsertTracked handle existing keys as
+// policy says. InsertIfAbsent and InsertWithMerge spell out their own
+// handling and ignore the policy.
+func NewWithDuplicatePolicy[K cmp.Ordered, V any](degree int, policy DuplicatePolicy) *BPlusTree[K, V] {
+	t := New[K, V](degree)
+	t.duplicates = policy
+	return t
+}
+
+// NewWithMaxHeight creates a B+ tree of the given degree, clamped like New,
+// that refuses to grow beyond maxHeight levels, a leaf-only tree having
+// height 1. An insert that would split the root of a tree already that tall
+// fails, leaving the tree unchanged: TryInsert and InsertChecked return an
+// error wrapping ErrHeightLimit, while Insert, InsertIfAbsent and
+// InsertWithMerge panic with it. Updates of existing keys always succeed,
+// and bulk loads are not limited. A maxHeight of 0 or less means no limit.
+func NewWithMaxHeight[K cmp.Ordered, V any](degree, maxHeight int) *BPlusTree[K, V] {
+	t := New[K, V](degree)
+	t.maxHeight = max(maxHeight, 0)
+	return t
+}
+
+// NewWithAggregate creates a tree that keeps a running total of its values,
+// starting from zero and updated with add and sub on every insert, overwrite
+// and delete. Overflow and precision loss are up to the supplied functions.
+func NewWithAggregate[K cmp.Ordered, V any](degree int, zero V, add, sub func(V, V) V) *BPlusTree[K, V] {
+	t := New[K, V](degree)
+	t.aggregate = &aggregate[V]{zero: zero, total: zero, add: add, sub: sub}
+	return t
+}
+
+// Aggregate returns the running total of all values. Trees created without
+// an aggregate always return the zero value of V.
+func (t *BPlusTree[K, V]) Aggregate() V {
+	if t.aggregate == nil {
+		var zero V
+		return zero
+	}
+	return t.aggregate.total
+}
+
+// Degree returns the degree in use after clamping. For trees created with
+// NewWithCapacities it is derived from the leaf capacity.
+func (t *BPlusTree[K, V]) Degree() int {
+	return (t.leafCap + 1) / 2
 }
 
 func (t *BPlusTree[K, V]) Search(key K) (V, bool) {
@@ -35,7 +176,7 @@ func (t *BPlusTree[K, V]) Search(key K) (V, bool) {
 	}
 	leaf := t.findLeaf(key)
 	for _, e := range leaf.entries {
-		if e.Key == key {
+		if e.Key == key && !e.deleted {
 			return e.Value, true
 		}
 	}
@@ -43,26 +184,356 @@ func (t *BPlusTree[K, V]) Search(key K) (V, bool) {
 	return zero, false
 }
 
-func (t *BPlusTree[K, V]) Insert(key K, value V) {
+// HasAll reports whether every key in keys is present. An empty keys is
+// trivially satisfied. The keys are sorted and looked up in a single sweep of
+// the leaf chain that stops at the first missing key.
+func (t *BPlusTree[K, V]) HasAll(keys []K) bool {
+	all := true
+	t.sweep(sortedKeys(keys), func(_ int, _ Entry[K, V], present bool) bool {
+		all = present
+		return present
+	})
+	return all
+}
+
+// HasAny reports whether at least one key in keys is present, sweeping the
+// leaf chain once like HasAll and stopping at the first key found
+func (t *BPlusTree[K, V]) HasAny(keys []K) bool {
+	anyFound := false
+	t.sweep(sortedKeys(keys), func(_ int, _ Entry[K, V], present bool) bool {
+		anyFound = present
+		return !present
+	})
+	return anyFound
+}
+
+// GetMany looks up every key and returns the values and found flags at the
+// same positions as keys, so results can be zipped back against the input.
+// The keys are looked up in sorted order in a single sweep of the leaf chain.
+func (t *BPlusTree[K, V]) GetMany(keys []K) ([]V, []bool) {
+	values := make([]V, len(keys))
+	found := make([]bool, len(keys))
+
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		return cmp.Compare(keys[a], keys[b])
+	})
+	sorted := make([]K, len(keys))
+	for i, pos := range order {
+		sorted[i] = keys[pos]
+	}
+
+	t.sweep(sorted, func(i int, e Entry[K, V], present bool) bool {
+		if present {
+			values[order[i]] = e.Value
+			found[order[i]] = true
+		}
+		return true
+	})
+	return values, found
+}
+
+// sortedKeys returns a sorted copy of keys without duplicates
+func sortedKeys[K cmp.Ordered](keys []K) []K {
+	sorted := slices.Clone(keys)
+	slices.Sort(sorted)
+	return slices.Compact(sorted)
+}
+
+// sweep looks up sorted keys in order, walking forward along the leaf chain,
+// and calls fn with each key's index and entry until it returns false
+func (t *BPlusTree[K, V]) sweep(sorted []K, fn func(i int, e Entry[K, V], present bool) bool) {
+	if len(sorted) == 0 {
+		return
+	}
 	if t.root == nil {
-		t.root = &node[K, V]{isLeaf: true}
-		t.root.entries = []Entry[K, V]{{Key: key, Value: value}}
+		for i := range sorted {
+			if !fn(i, Entry[K, V]{}, false) {
+				return
+			}
+		}
 		return
 	}
 
+	leaf := t.findLeaf(sorted[0])
+	pos := 0
+	for i, key := range sorted {
+		for leaf != nil {
+			for pos < len(leaf.entries) && leaf.entries[pos].Key < key {
+				pos++
+			}
+			if pos < len(leaf.entries) {
+				break
+			}
+			leaf, pos = leaf.next, 0
+		}
+		if leaf != nil && leaf.entries[pos].Key == key && !leaf.entries[pos].deleted {
+			if !fn(i, leaf.entries[pos].Entry, true) {
+				return
+			}
+		} else if !fn(i, Entry[K, V]{}, false) {
+			return
+		}
+	}
+}
+
+// Min returns the entry with the smallest key, or ErrEmpty if there is none
+func (t *BPlusTree[K, V]) Min() (Entry[K, V], error) {
+	e, ok := t.nextLive(t.firstLeaf(), 0)
+	if !ok {
+		return Entry[K, V]{}, ErrEmpty
+	}
+	return e, nil
+}
+
+// Max returns the entry with the largest key, or ErrEmpty if there is none
+func (t *BPlusTree[K, V]) Max() (Entry[K, V], error) {
+	leaf := t.lastLeaf()
+	if leaf == nil {
+		return Entry[K, V]{}, ErrEmpty
+	}
+	e, ok := t.prevLive(leaf, len(leaf.entries))
+	if !ok {
+		return Entry[K, V]{}, ErrEmpty
+	}
+	return e, nil
+}
+
+// Floor returns the entry with the largest key less than or equal to key
+func (t *BPlusTree[K, V]) Floor(key K) (Entry[K, V], bool) {
+	if t.root == nil {
+		return Entry[K, V]{}, false
+	}
+	leaf := t.findLeaf(key)
+	i, found := slices.BinarySearchFunc(leaf.entries, key, func(e leafEntry[K, V], k K) int {
+		return cmp.Compare(e.Key, k)
+	})
+	if found {
+		i++
+	}
+	// Every key before i is at most key; the answer may sit in an earlier leaf
+	return t.prevLive(leaf, i)
+}
+
+// Ceiling returns the entry with the smallest key greater than or equal to key
+func (t *BPlusTree[K, V]) Ceiling(key K) (Entry[K, V], bool) {
+	if t.root == nil {
+		return Entry[K, V]{}, false
+	}
+	leaf := t.findLeaf(key)
+	i, _ := slices.BinarySearchFunc(leaf.entries, key, func(e leafEntry[K, V], k K) int {
+		return cmp.Compare(e.Key, k)
+	})
+	return t.nextLive(leaf, i)
+}
+
+// Insert adds an entry or updates the value of an existing key, unless the
+// tree was created with another DuplicatePolicy. A NaN key cannot be ordered
+// and would corrupt the tree, so Insert panics on one, as it does on an
+// existing key under DuplicateError; use InsertChecked to get an error
+// instead.
+func (t *BPlusTree[K, V]) Insert(key K, value V) {
+	mustBeOrdered(key)
+	if err := t.insertWithPolicy(key, value); err != nil {
+		panic(err)
+	}
+}
+
+// InsertChecked is like Insert but returns ErrNaNKey, or an error wrapping
+// ErrDuplicateKey under DuplicateError, leaving the tree unchanged, instead
+// of panicking
+func (t *BPlusTree[K, V]) InsertChecked(key K, value V) error {
+	if isNaN(key) {
+		return ErrNaNKey
+	}
+	return t.insertWithPolicy(key, value)
+}
+
+// TryInsert is like Insert but returns errors instead of panicking. It is
+// the same as InsertChecked, named for trees with a height limit, where a
+// failed insert is an expected outcome.
+func (t *BPlusTree[K, V]) TryInsert(key K, value V) error {
+	return t.InsertChecked(key, value)
+}
+
+func (t *BPlusTree[K, V]) insertWithPolicy(key K, value V) error {
+	if err := t.checkHeight(key); err != nil {
+		return err
+	}
+	switch t.duplicates {
+	case DuplicateIgnore:
+		t.put(key, value, nil)
+	case DuplicateError:
+		if !t.put(key, value, nil) {
+			return fmt.Errorf("%w: %v", ErrDuplicateKey, key)
+		}
+	default:
+		t.put(key, value, replaceValue[V])
+	}
+	return nil
+}
+
+// InsertTracked is like Insert but reports whether the tree grew a level,
+// either because the root split or because this was the first entry
+func (t *BPlusTree[K, V]) InsertTracked(key K, value V) (grew bool) {
+	root := t.root
+	t.Insert(key, value)
+	return t.root != root
+}
+
+// InsertIfAbsent inserts the entry only if key is not yet present, leaving
+// an existing value untouched. It reports whether the entry was inserted.
+// Like Insert it panics on a NaN key.
+func (t *BPlusTree[K, V]) InsertIfAbsent(key K, value V) bool {
+	mustBeOrdered(key)
+	mustFit(t.checkHeight(key))
+	return t.put(key, value, nil)
+}
+
+// InsertWithMerge inserts the entry if key is absent and otherwise stores
+// merge(old, value) as the key's value, sparing callers a separate Search and
+// Insert. Like Insert it panics on a NaN key.
+func (t *BPlusTree[K, V]) InsertWithMerge(key K, value V, merge func(old, new V) V) {
+	mustBeOrdered(key)
+	mustFit(t.checkHeight(key))
+	t.put(key, value, merge)
+}
+
+func replaceValue[V any](_, value V) V {
+	return value
+}
+
+// isNaN reports whether key is a floating-point NaN, the only value of an
+// ordered type that is not equal to itself
+func isNaN[K cmp.Ordered](key K) bool {
+	return key != key
+}
+
+func mustBeOrdered[K cmp.Ordered](key K) {
+	if isNaN(key) {
+		panic(ErrNaNKey)
+	}
+}
+
+// checkHeight returns an error if inserting key would split the root of a
+// tree at its height limit. That happens only for a new key whose leaf and
+// every ancestor up to the root are full.
+func (t *BPlusTree[K, V]) checkHeight(key K) error {
+	if t.maxHeight == 0 || t.root == nil {
+		return nil
+	}
 	leaf := t.findLeaf(key)
+	if len(leaf.entries) < t.maxLeafEntries() {
+		return nil
+	}
+	if _, found := slices.BinarySearchFunc(leaf.entries, key, func(e leafEntry[K, V], k K) int {
+		return cmp.Compare(e.Key, k)
+	}); found {
+		return nil
+	}
+
+	levels := 1
+	for n := leaf.parent; n != nil; n = n.parent {
+		if len(n.keys) < t.maxInternalKeys() {
+			return nil
+		}
+		levels++
+	}
+	if levels >= t.maxHeight {
+		return fmt.Errorf("%w: inserting %v would grow the tree past %d levels", ErrHeightLimit, key, t.maxHeight)
+	}
+	return nil
+}
+
+func mustFit(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+// put inserts an entry and reports whether the key was newly added. For an
+// existing key the value becomes merge(old, value), or is left alone when
+// merge is nil.
+func (t *BPlusTree[K, V]) put(key K, value V, merge func(old, new V) V) bool {
+	if t.root == nil {
+		t.root = &node[K, V]{isLeaf: true}
+		t.root.entries = []leafEntry[K, V]{{Entry: Entry[K, V]{Key: key, Value: value}}}
+		t.size++
+		t.addToAggregate(value)
+		if t.hook != nil {
+			t.hook(OpInsert, key, value)
+		}
+		return true
+	}
+
+	leaf := t.insertLeaf(key)
 
 	for i, e := range leaf.entries {
 		if e.Key == key {
-			leaf.entries[i].Value = value
-			return
+			if e.deleted {
+				// A tombstoned key comes back as a new entry
+				t.tombstones--
+				leaf.entries[i] = leafEntry[K, V]{Entry: Entry[K, V]{Key: key, Value: value}}
+				t.addToAggregate(value)
+				if t.hook != nil {
+					t.hook(OpInsert, key, value)
+				}
+				return true
+			}
+			if merge != nil {
+				value = merge(e.Value, value)
+				if t.aggregate != nil {
+					t.aggregate.total = t.aggregate.sub(t.aggregate.total, e.Value)
+				}
+				t.addToAggregate(value)
+				leaf.entries[i].Value = value
+				if t.hook != nil {
+					t.hook(OpUpdate, key, value)
+				}
+				if t.evict != nil {
+					t.evict(key, e.Value)
+				}
+			}
+			return false
 		}
 	}
 
 	t.insertIntoLeaf(leaf, key, value)
+	t.size++
+	t.addToAggregate(value)
 
 	if len(leaf.entries) > t.maxLeafEntries() {
 		t.splitLeaf(leaf)
+	} else {
+		t.hint = leaf
+	}
+	if t.hook != nil {
+		t.hook(OpInsert, key, value)
+	}
+	return true
+}
+
+// insertLeaf returns the leaf key belongs in. Mostly ascending inserts keep
+// landing in the leaf of the previous insert, so that leaf is tried before
+// descending from the root. It is only used when findLeaf would surely pick
+// it too: key is not below its first key and, unless it is the last leaf,
+// not above its last key, since the separator to the next leaf may lie
+// anywhere in between. A full hint leaf is skipped so the fast path never
+// splits.
+func (t *BPlusTree[K, V]) insertLeaf(key K) *node[K, V] {
+	if leaf := t.hint; leaf != nil && len(leaf.entries) > 0 && len(leaf.entries) < t.maxLeafEntries() &&
+		key >= leaf.entries[0].Key && (leaf.next == nil || key <= leaf.entries[len(leaf.entries)-1].Key) {
+		return leaf
+	}
+	return t.findLeaf(key)
+}
+
+func (t *BPlusTree[K, V]) addToAggregate(value V) {
+	if t.aggregate != nil {
+		t.aggregate.total = t.aggregate.add(t.aggregate.total, value)
 	}
 }
 
@@ -84,69 +555,839 @@ func (t *BPlusTree[K, V]) Delete(key K) bool {
 		return false
 	}
 
+	removed := leaf.entries[idx]
+	wasDead := removed.deleted
+	if t.softDelete {
+		if wasDead {
+			return false
+		}
+		leaf.entries[idx].deleted = true
+		t.tombstones++
+		if t.aggregate != nil {
+			t.aggregate.total = t.aggregate.sub(t.aggregate.total, removed.Value)
+		}
+		t.reportRemoval(removed.Key, removed.Value)
+		return true
+	}
+	if wasDead {
+		// Physically drop a tombstone left over from soft delete mode; the
+		// entry was already reported as deleted
+		t.tombstones--
+	} else if t.aggregate != nil {
+		t.aggregate.total = t.aggregate.sub(t.aggregate.total, removed.Value)
+	}
+
 	leaf.entries = append(leaf.entries[:idx], leaf.entries[idx+1:]...)
+	t.size--
 
 	if leaf == t.root {
 		if len(leaf.entries) == 0 {
 			t.root = nil
 		}
-		return true
-	}
-
-	minEntries := t.minLeafEntries()
-	if len(leaf.entries) < minEntries {
+	} else if len(leaf.entries) < t.minLeafEntries() {
 		t.rebalanceLeaf(leaf)
 	}
 
+	if wasDead {
+		return false
+	}
+	t.reportRemoval(removed.Key, removed.Value)
 	return true
 }
 
+// DeleteBatch removes every key in keys and returns how many were present.
+// Keys are sorted and removed leaf by leaf, and each affected leaf is
+// rebalanced once after all of its deletions instead of once per key.
+func (t *BPlusTree[K, V]) DeleteBatch(keys []K) int {
+	sorted := slices.Clone(keys)
+	slices.Sort(sorted)
+	sorted = slices.Compact(sorted)
+
+	if t.softDelete {
+		removed := 0
+		for _, key := range sorted {
+			if t.Delete(key) {
+				removed++
+			}
+		}
+		return removed
+	}
+
+	removed, physical := 0, 0
+	var dropped []Entry[K, V]
+	for i := 0; i < len(sorted) && t.root != nil; {
+		leaf := t.findLeaf(sorted[i])
+
+		kept := leaf.entries[:0]
+		for _, e := range leaf.entries {
+			for i < len(sorted) && sorted[i] < e.Key {
+				i++
+			}
+			if i < len(sorted) && sorted[i] == e.Key {
+				physical++
+				i++
+				if e.deleted {
+					t.tombstones--
+					continue
+				}
+				if t.aggregate != nil {
+					t.aggregate.total = t.aggregate.sub(t.aggregate.total, e.Value)
+				}
+				if t.watching() {
+					dropped = append(dropped, e.Entry)
+				}
+				removed++
+				continue
+			}
+			kept = append(kept, e)
+		}
+		leaf.entries = kept
+
+		// Skip absent keys that would still route to this leaf
+		for i < len(sorted) && leaf.next != nil && sorted[i] < leaf.next.entries[0].Key {
+			i++
+		}
+		if leaf.next == nil {
+			i = len(sorted)
+		}
+
+		if leaf == t.root {
+			if len(leaf.entries) == 0 {
+				t.root = nil
+			}
+			continue
+		}
+		for len(leaf.entries) < t.minLeafEntries() {
+			if t.rebalanceLeaf(leaf) {
+				break
+			}
+		}
+	}
+
+	t.size -= physical
+	for _, e := range dropped {
+		t.reportRemoval(e.Key, e.Value)
+	}
+	return removed
+}
+
 func (t *BPlusTree[K, V]) Range(start, end K) []Entry[K, V] {
 	if t.root == nil {
-		return nil
+		return []Entry[K, V]{}
 	}
 
-	var result []Entry[K, V]
+	result := []Entry[K, V]{}
 	leaf := t.findLeaf(start)
 
 	for leaf != nil {
 		for _, e := range leaf.entries {
-			if e.Key >= start && e.Key <= end {
-				result = append(result, e)
-			} else if e.Key > end {
+			if e.Key > end {
 				return result
 			}
+			if e.Key >= start && !e.deleted {
+				result = append(result, e.Entry)
+			}
 		}
 		leaf = leaf.next
 	}
 	return result
 }
 
-func (t *BPlusTree[K, V]) All() []Entry[K, V] {
+// RangeExclusive is like Range but lets either bound be excluded, so that
+// for example includeStart=false returns everything strictly after start, as
+// needed to resume paging after the last key seen
+func (t *BPlusTree[K, V]) RangeExclusive(start, end K, includeStart, includeEnd bool) []Entry[K, V] {
+	result := []Entry[K, V]{}
 	if t.root == nil {
-		return nil
+		return result
 	}
 
-	var result []Entry[K, V]
-	leaf := t.firstLeaf()
-	for leaf != nil {
-		result = append(result, leaf.entries...)
-		leaf = leaf.next
+	for leaf := t.findLeaf(start); leaf != nil; leaf = leaf.next {
+		for _, e := range leaf.entries {
+			if e.Key > end || (e.Key == end && !includeEnd) {
+				return result
+			}
+			if (e.Key > start || (e.Key == start && includeStart)) && !e.deleted {
+				result = append(result, e.Entry)
+			}
+		}
 	}
 	return result
 }
 
-func (t *BPlusTree[K, V]) Len() int {
+// RangeFrom returns up to limit entries with keys strictly above afterKey, in
+// key order, for keyset pagination. It also returns the cursor for the next
+// page, the last key returned or afterKey when none was, and whether more
+// entries follow it. Because pages are anchored to keys rather than offsets,
+// inserts and deletes elsewhere in the tree never shift them. To start from
+// the beginning pass a key below every stored one. A limit below 1 yields an
+// empty page with hasMore false, so a loop on hasMore still ends.
+func (t *BPlusTree[K, V]) RangeFrom(afterKey K, limit int) (page []Entry[K, V], next K, hasMore bool) {
+	page = make([]Entry[K, V], 0, max(min(limit, t.Len()), 0))
+	next = afterKey
+	if t.root == nil || limit < 1 {
+		return page, next, false
+	}
+
+	for leaf := t.findLeaf(afterKey); leaf != nil; leaf = leaf.next {
+		for _, e := range leaf.entries {
+			if e.Key <= afterKey || e.deleted {
+				continue
+			}
+			if len(page) >= limit {
+				return page, next, true
+			}
+			page = append(page, e.Entry)
+			next = e.Key
+		}
+	}
+	return page, next, false
+}
+
+// RangeKeys returns the keys in [start, end] in order, without copying the
+// values. Like Range it returns an empty, non-nil slice when nothing matches.
+func (t *BPlusTree[K, V]) RangeKeys(start, end K) []K {
+	result := []K{}
 	if t.root == nil {
+		return result
+	}
+
+	for leaf := t.findLeaf(start); leaf != nil; leaf = leaf.next {
+		for i := range leaf.entries {
+			key := leaf.entries[i].Key
+			if key > end {
+				return result
+			}
+			if key >= start && !leaf.entries[i].deleted {
+				result = append(result, key)
+			}
+		}
+	}
+	return result
+}
+
+// CountRange returns the exact number of entries with keys in [start, end].
+// It walks the matching leaves without collecting them.
+func (t *BPlusTree[K, V]) CountRange(start, end K) int {
+	if t.root == nil || start > end {
 		return 0
 	}
+
 	count := 0
+	for leaf := t.findLeaf(start); leaf != nil; leaf = leaf.next {
+		for _, e := range leaf.entries {
+			if e.Key > end {
+				return count
+			}
+			if e.Key >= start && !e.deleted {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// Integer is the constraint for key types whose keys can be enumerated one
+// by one
+type Integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+// ContainsContiguous reports whether every key start, start+1, ..., end is
+// present in t. It walks the leaves from start and stops at the first gap,
+// so a missing key near start is found without scanning the whole interval.
+// An empty interval, with start above end, is trivially covered.
+func ContainsContiguous[K Integer, V any](t *BPlusTree[K, V], start, end K) bool {
+	if start > end {
+		return true
+	}
+	if t.root == nil {
+		return false
+	}
+
+	want := start
+	for leaf := t.findLeaf(start); leaf != nil; leaf = leaf.next {
+		for _, e := range leaf.entries {
+			if e.Key < want || e.deleted {
+				continue
+			}
+			if e.Key != want {
+				return false
+			}
+			if want == end {
+				return true
+			}
+			want++
+		}
+	}
+	return false
+}
+
+// EstimateRangeCount approximates the number of entries with keys in
+// [start, end] from the positions of start and end along their root-to-leaf
+// paths, assuming entries are spread evenly across subtrees. It touches only
+// O(log n) nodes, so it suits query cost estimation; use CountRange when the
+// exact count is needed.
+func (t *BPlusTree[K, V]) EstimateRangeCount(start, end K) int {
+	if t.root == nil || start > end {
+		return 0
+	}
+	n := t.Len()
+	estimate := int(math.Round((t.position(end, true) - t.position(start, false)) * float64(n)))
+	return max(0, min(estimate, n))
+}
+
+// position estimates the fraction of entries ordered before key, or at or
+// before it when inclusive is set. Each level narrows the interval covered by
+// the current subtree to the share of the chosen child.
+func (t *BPlusTree[K, V]) position(key K, inclusive bool) float64 {
+	lo, width := 0.0, 1.0
+	n := t.root
+	for !n.isLeaf {
+		i, _ := slices.BinarySearch(n.keys, key)
+		if i < len(n.keys) && n.keys[i] == key {
+			i++
+		}
+		width /= float64(len(n.children))
+		lo += float64(i) * width
+		n = n.children[i]
+	}
+	if len(n.entries) == 0 {
+		return lo
+	}
+
+	j, found := slices.BinarySearchFunc(n.entries, key, func(e leafEntry[K, V], k K) int {
+		return cmp.Compare(e.Key, k)
+	})
+	if found && inclusive {
+		j++
+	}
+	return lo + float64(j)/float64(len(n.entries))*width
+}
+
+// PrefixRange returns the entries whose keys start with prefix, in key order.
+// It reuses Range with the smallest string greater than every key carrying
+// the prefix; when no such string exists (the prefix is empty or made only of
+// 0xFF bytes) it scans to the last leaf instead.
+func PrefixRange[K ~string, V any](t *BPlusTree[K, V], prefix K) []Entry[K, V] {
+	end := []byte(prefix)
+	for len(end) > 0 && end[len(end)-1] == 0xFF {
+		end = end[:len(end)-1]
+	}
+
+	if len(end) == 0 {
+		if t.root == nil {
+			return []Entry[K, V]{}
+		}
+		result := []Entry[K, V]{}
+		for leaf := t.findLeaf(prefix); leaf != nil; leaf = leaf.next {
+			for _, e := range leaf.entries {
+				if e.Key >= prefix && !e.deleted {
+					result = append(result, e.Entry)
+				}
+			}
+		}
+		return result
+	}
+
+	end[len(end)-1]++
+	upper := K(end)
+	result := t.Range(prefix, upper)
+	if n := len(result); n > 0 && result[n-1].Key == upper {
+		result = result[:n-1]
+	}
+	return result
+}
+
+// MultiRange returns the entries whose keys fall in any of the inclusive
+// intervals, sorted by key and without duplicates. Overlapping intervals are
+// merged first and the leaf chain is walked only once.
+func (t *BPlusTree[K, V]) MultiRange(intervals [][2]K) []Entry[K, V] {
+	if t.root == nil {
+		return []Entry[K, V]{}
+	}
+
+	merged := make([][2]K, 0, len(intervals))
+	for _, iv := range intervals {
+		if iv[0] <= iv[1] {
+			merged = append(merged, iv)
+		}
+	}
+	if len(merged) == 0 {
+		return []Entry[K, V]{}
+	}
+	slices.SortFunc(merged, func(a, b [2]K) int {
+		return cmp.Compare(a[0], b[0])
+	})
+	n := 0
+	for _, iv := range merged[1:] {
+		if iv[0] <= merged[n][1] {
+			merged[n][1] = max(merged[n][1], iv[1])
+		} else {
+			n++
+			merged[n] = iv
+		}
+	}
+	merged = merged[:n+1]
+
+	result := []Entry[K, V]{}
+	j := 0
+	for leaf := t.findLeaf(merged[0][0]); leaf != nil; leaf = leaf.next {
+		for _, e := range leaf.entries {
+			for e.Key > merged[j][1] {
+				j++
+				if j == len(merged) {
+					return result
+				}
+			}
+			if e.Key >= merged[j][0] && !e.deleted {
+				result = append(result, e.Entry)
+			}
+		}
+	}
+	return result
+}
+
+// TopK returns the k entries with the largest keys in descending key order,
+// walking the leaf chain backwards from the last leaf
+func (t *BPlusTree[K, V]) TopK(k int) []Entry[K, V] {
+	if t.root == nil || k <= 0 {
+		return []Entry[K, V]{}
+	}
+
+	result := []Entry[K, V]{}
+	for leaf := t.lastLeaf(); leaf != nil; leaf = leaf.prev {
+		for i := len(leaf.entries) - 1; i >= 0; i-- {
+			if leaf.entries[i].deleted {
+				continue
+			}
+			result = append(result, leaf.entries[i].Entry)
+			if len(result) == k {
+				return result
+			}
+		}
+	}
+	return result
+}
+
+// All returns every entry in key order. Like Range, MultiRange, PrefixRange,
+// TopK, Keys and Values it never returns nil: an empty result is a non-nil,
+// zero-length slice, so it marshals to an empty JSON array rather than null.
+func (t *BPlusTree[K, V]) All() []Entry[K, V] {
+	return t.AppendAll(make([]Entry[K, V], 0, t.Len()))
+}
+
+// AppendAll appends every entry in key order to dst and returns the extended
+// slice, so a caller reading the whole tree repeatedly can reuse one buffer
+// by passing dst[:0]
+func (t *BPlusTree[K, V]) AppendAll(dst []Entry[K, V]) []Entry[K, V] {
+	dst = slices.Grow(dst, t.Len())
+	for leaf := t.firstLeaf(); leaf != nil; leaf = leaf.next {
+		for _, e := range leaf.entries {
+			if !e.deleted {
+				dst = append(dst, e.Entry)
+			}
+		}
+	}
+	return dst
+}
+
+// Keys returns every key in sorted order
+func (t *BPlusTree[K, V]) Keys() []K {
+	result := make([]K, 0, t.Len())
+	for leaf := t.firstLeaf(); leaf != nil; leaf = leaf.next {
+		for _, e := range leaf.entries {
+			if e.deleted {
+				continue
+			}
+			result = append(result, e.Key)
+		}
+	}
+	return result
+}
+
+// Values returns every value in key order
+func (t *BPlusTree[K, V]) Values() []V {
+	result := make([]V, 0, t.Len())
+	for leaf := t.firstLeaf(); leaf != nil; leaf = leaf.next {
+		for _, e := range leaf.entries {
+			if e.deleted {
+				continue
+			}
+			result = append(result, e.Value)
+		}
+	}
+	return result
+}
+
+// UpdateEach calls fn for every entry in key order in one sweep of the leaf
+// chain. When fn returns true the entry's value is replaced with the value it
+// returned, as if by Insert: the aggregate is adjusted and the mutation hook
+// sees an OpUpdate. Keys never change, so no node is split or merged. fn must
+// not modify the tree.
+func (t *BPlusTree[K, V]) UpdateEach(fn func(k K, v V) (V, bool)) {
+	for leaf := t.firstLeaf(); leaf != nil; leaf = leaf.next {
+		for i, e := range leaf.entries {
+			if e.deleted {
+				continue
+			}
+			value, ok := fn(e.Key, e.Value)
+			if !ok {
+				continue
+			}
+			if t.aggregate != nil {
+				t.aggregate.total = t.aggregate.sub(t.aggregate.total, e.Value)
+			}
+			t.addToAggregate(value)
+			leaf.entries[i].Value = value
+			if t.hook != nil {
+				t.hook(OpUpdate, e.Key, value)
+			}
+			if t.evict != nil {
+				t.evict(e.Key, e.Value)
+			}
+		}
+	}
+}
+
+// Sample returns n entries chosen uniformly at random, using reservoir
+// sampling over the leaf chain so only n entries are held at a time. Every
+// entry is equally likely to be picked, however the entries are spread over
+// leaves. The sample is in no particular order; when n is at least Len every
+// entry is returned in key order. A nil rng draws from the top-level source
+// of math/rand.
+func (t *BPlusTree[K, V]) Sample(n int, rng *rand.Rand) []Entry[K, V] {
+	if n <= 0 {
+		return []Entry[K, V]{}
+	}
+	intn := rand.Intn
+	if rng != nil {
+		intn = rng.Intn
+	}
+	reservoir := make([]Entry[K, V], 0, min(n, t.Len()))
+	seen := 0
+	for leaf := t.firstLeaf(); leaf != nil; leaf = leaf.next {
+		for _, e := range leaf.entries {
+			if e.deleted {
+				continue
+			}
+			if seen < n {
+				reservoir = append(reservoir, e.Entry)
+			} else if j := intn(seen + 1); j < n {
+				reservoir[j] = e.Entry
+			}
+			seen++
+		}
+	}
+	return reservoir
+}
+
+// GroupBy sorts the entries of tree into groups by keyFn(key) in a single
+// sweep of the leaf chain. Entries within each group stay in key order.
+func GroupBy[K cmp.Ordered, V any, G comparable](tree *BPlusTree[K, V], keyFn func(K) G) map[G][]Entry[K, V] {
+	groups := make(map[G][]Entry[K, V])
+	for leaf := tree.firstLeaf(); leaf != nil; leaf = leaf.next {
+		for _, e := range leaf.entries {
+			if e.deleted {
+				continue
+			}
+			g := keyFn(e.Key)
+			groups[g] = append(groups[g], e.Entry)
+		}
+	}
+	return groups
+}
+
+// Stream sends every entry in key order on the returned channel from a
+// separate goroutine. The channel is closed once all entries have been sent
+// or ctx is cancelled. The tree must not be modified while streaming.
+func (t *BPlusTree[K, V]) Stream(ctx context.Context) <-chan Entry[K, V] {
+	ch := make(chan Entry[K, V])
+	go func() {
+		defer close(ch)
+		for leaf := t.firstLeaf(); leaf != nil; leaf = leaf.next {
+			for _, e := range leaf.entries {
+				if e.deleted {
+					continue
+				}
+				select {
+				case ch <- e.Entry:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// Chunks returns an iterator over the entries in key order, delivered in
+// slices of size entries; only the last may be shorter. The backing array is
+// reused between yields, so a chunk is only valid until the loop body
+// returns and must be copied to be kept. A size below 1 is raised to 1. The
+// tree must not be modified during iteration.
+func (t *BPlusTree[K, V]) Chunks(size int) iter.Seq[[]Entry[K, V]] {
+	size = max(size, 1)
+	return func(yield func([]Entry[K, V]) bool) {
+		chunk := make([]Entry[K, V], 0, min(size, t.Len()))
+		for leaf := t.firstLeaf(); leaf != nil; leaf = leaf.next {
+			for _, e := range leaf.entries {
+				if e.deleted {
+					continue
+				}
+				chunk = append(chunk, e.Entry)
+				if len(chunk) == size {
+					if !yield(chunk) {
+						return
+					}
+					chunk = chunk[:0]
+				}
+			}
+		}
+		if len(chunk) > 0 {
+			yield(chunk)
+		}
+	}
+}
+
+// Len returns the number of live entries, not counting tombstones
+func (t *BPlusTree[K, V]) Len() int {
+	return t.size - t.tombstones
+}
+
+// FillRatio returns the entries stored in the leaves divided by their total
+// capacity, in [0, 1]. A bulk-loaded tree is close to 1; deletes drive it
+// toward 0.5, the minimum occupancy rebalancing keeps. Tombstoned entries
+// still occupy their slots and count as stored. An empty tree reports 0.
+func (t *BPlusTree[K, V]) FillRatio() float64 {
+	entries, leaves := 0, 0
+	for leaf := t.firstLeaf(); leaf != nil; leaf = leaf.next {
+		entries += len(leaf.entries)
+		leaves++
+	}
+	if entries == 0 {
+		return 0
+	}
+	return float64(entries) / float64(leaves*t.maxLeafEntries())
+}
+
+// Clear removes all entries from the tree
+func (t *BPlusTree[K, V]) Clear() {
+	var old []Entry[K, V]
+	if t.watching() {
+		old = t.All()
+	}
+	t.build(nil, 1)
+	for _, e := range old {
+		t.reportRemoval(e.Key, e.Value)
+	}
+}
+
+// CheckLeafChain verifies that following the next pointers from the first
+// leaf visits exactly the leaves reachable from the root, left to right, that
+// every prev pointer links back to the preceding leaf, and that keys increase
+// strictly along the chain.
+func (t *BPlusTree[K, V]) CheckLeafChain() error {
+	if t.root == nil {
+		return nil
+	}
+
+	var leaves []*node[K, V]
+	t.collectLeaves(t.root, &leaves)
+
 	leaf := t.firstLeaf()
-	for leaf != nil {
-		count += len(leaf.entries)
+	var prev *K
+	for i, expected := range leaves {
+		if leaf == nil {
+			return fmt.Errorf("leaf chain ends after %d of %d leaves", i, len(leaves))
+		}
+		if leaf != expected {
+			return fmt.Errorf("leaf chain diverges from tree order at leaf %d", i)
+		}
+		if i > 0 && leaf.prev != leaves[i-1] || i == 0 && leaf.prev != nil {
+			return fmt.Errorf("leaf %d has wrong prev pointer", i)
+		}
+		for j := range leaf.entries {
+			if prev != nil && leaf.entries[j].Key <= *prev {
+				return fmt.Errorf("leaf chain key %v not greater than %v", leaf.entries[j].Key, *prev)
+			}
+			prev = &leaf.entries[j].Key
+		}
 		leaf = leaf.next
 	}
-	return count
+	if leaf != nil {
+		return fmt.Errorf("leaf chain has more than %d leaves", len(leaves))
+	}
+	return nil
+}
+
+// WalkNodes visits every node in pre-order, parents before their children
+// and children left to right, passing the node's depth (0 for the root).
+// Internal nodes report their separator keys and nil entries; leaves report
+// nil keys and their entries. The slices are copies, so fn may keep or
+// modify them without affecting the tree.
+func (t *BPlusTree[K, V]) WalkNodes(fn func(isLeaf bool, keys []K, entries []Entry[K, V], depth int)) {
+	if t.root != nil {
+		t.walkNode(t.root, 0, fn)
+	}
+}
+
+func (t *BPlusTree[K, V]) walkNode(n *node[K, V], depth int, fn func(bool, []K, []Entry[K, V], int)) {
+	if n.isLeaf {
+		entries := make([]Entry[K, V], 0, len(n.entries))
+		for _, e := range n.entries {
+			if !e.deleted {
+				entries = append(entries, e.Entry)
+			}
+		}
+		fn(true, nil, entries, depth)
+		return
+	}
+	fn(false, slices.Clone(n.keys), nil, depth)
+	for _, child := range n.children {
+		t.walkNode(child, depth+1, fn)
+	}
+}
+
+// SearchPath returns the keys of each node visited while descending to key,
+// from the root down to the leaf. Internal levels hold the separator keys
+// that steered the search; the last level holds the live keys of the leaf
+// where key is or would be stored. The slices are copies. It returns nil for
+// an empty tree.
+func (t *BPlusTree[K, V]) SearchPath(key K) [][]K {
+	if t.root == nil {
+		return nil
+	}
+
+	var path [][]K
+	n := t.root
+	for !n.isLeaf {
+		path = append(path, slices.Clone(n.keys))
+		i := 0
+		for i < len(n.keys) && key >= n.keys[i] {
+			i++
+		}
+		n = n.children[i]
+	}
+
+	keys := make([]K, 0, len(n.entries))
+	for _, e := range n.entries {
+		if !e.deleted {
+			keys = append(keys, e.Key)
+		}
+	}
+	return append(path, keys)
+}
+
+// RepairLeafChain rebuilds the next and prev pointers of every leaf from the
+// tree structure, visiting leaves left to right through their parents. Keys
+// and entries are not touched. It returns the number of pointers that had to
+// be changed, so 0 means the chain was already intact.
+func (t *BPlusTree[K, V]) RepairLeafChain() int {
+	if t.root == nil {
+		return 0
+	}
+
+	var leaves []*node[K, V]
+	t.collectLeaves(t.root, &leaves)
+
+	fixed := 0
+	for i, leaf := range leaves {
+		var prev, next *node[K, V]
+		if i > 0 {
+			prev = leaves[i-1]
+		}
+		if i+1 < len(leaves) {
+			next = leaves[i+1]
+		}
+		if leaf.prev != prev {
+			leaf.prev = prev
+			fixed++
+		}
+		if leaf.next != next {
+			leaf.next = next
+			fixed++
+		}
+	}
+	return fixed
+}
+
+func (t *BPlusTree[K, V]) collectLeaves(n *node[K, V], leaves *[]*node[K, V]) {
+	if n.isLeaf {
+		*leaves = append(*leaves, n)
+		return
+	}
+	for _, child := range n.children {
+		t.collectLeaves(child, leaves)
+	}
+}
+
+// Equal reports whether a and b hold the same entries, regardless of degree
+// or node layout. Values are compared with ==, so V must be comparable.
+func Equal[K cmp.Ordered, V comparable](a, b *BPlusTree[K, V]) bool {
+	la, lb := a.firstLeaf(), b.firstLeaf()
+	i, j := 0, 0
+	for {
+		for la != nil && (i == len(la.entries) || la.entries[i].deleted) {
+			if i == len(la.entries) {
+				la, i = la.next, 0
+			} else {
+				i++
+			}
+		}
+		for lb != nil && (j == len(lb.entries) || lb.entries[j].deleted) {
+			if j == len(lb.entries) {
+				lb, j = lb.next, 0
+			} else {
+				j++
+			}
+		}
+		if la == nil || lb == nil {
+			return la == nil && lb == nil
+		}
+		if la.entries[i] != lb.entries[j] {
+			return false
+		}
+		i++
+		j++
+	}
+}
+
+// Diff compares t against a reference map and returns, each in key order,
+// the keys of the reference absent from t, the keys of t absent from the
+// reference, and the keys present in both with values that differ under ==.
+// All three are empty when t holds exactly the reference's entries.
+func Diff[K cmp.Ordered, V comparable](t *BPlusTree[K, V], reference map[K]V) (missing, extra, mismatched []K) {
+	shared := 0
+	for leaf := t.firstLeaf(); leaf != nil; leaf = leaf.next {
+		for _, e := range leaf.entries {
+			if e.deleted {
+				continue
+			}
+			want, ok := reference[e.Key]
+			switch {
+			case !ok:
+				extra = append(extra, e.Key)
+			case want != e.Value:
+				shared++
+				mismatched = append(mismatched, e.Key)
+			default:
+				shared++
+			}
+		}
+	}
+
+	// Every reference key not seen in the walk is missing
+	if shared < len(reference) {
+		for key := range reference {
+			if _, ok := t.Search(key); !ok {
+				missing = append(missing, key)
+			}
+		}
+		slices.Sort(missing)
+	}
+	return missing, extra, mismatched
 }
 
 func (t *BPlusTree[K, V]) findLeaf(key K) *node[K, V] {
@@ -172,26 +1413,45 @@ func (t *BPlusTree[K, V]) firstLeaf() *node[K, V] {
 	return n
 }
 
+func (t *BPlusTree[K, V]) lastLeaf() *node[K, V] {
+	if t.root == nil {
+		return nil
+	}
+	n := t.root
+	for !n.isLeaf {
+		n = n.children[len(n.children)-1]
+	}
+	return n
+}
+
 func (t *BPlusTree[K, V]) insertIntoLeaf(leaf *node[K, V], key K, value V) {
-	entry := Entry[K, V]{Key: key, Value: value}
+	entry := leafEntry[K, V]{Entry: Entry[K, V]{Key: key, Value: value}}
 	i := 0
 	for i < len(leaf.entries) && leaf.entries[i].Key < key {
 		i++
 	}
-	leaf.entries = append(leaf.entries[:i], append([]Entry[K, V]{entry}, leaf.entries[i:]...)...)
+	leaf.entries = append(leaf.entries[:i], append([]leafEntry[K, V]{entry}, leaf.entries[i:]...)...)
 }
 
 func (t *BPlusTree[K, V]) splitLeaf(leaf *node[K, V]) {
+	t.hint = nil
 	mid := len(leaf.entries) / 2
+	if t.splitRatio != 0 {
+		mid = t.splitPoint(len(leaf.entries), t.minLeafEntries())
+	}
 
 	newLeaf := &node[K, V]{
 		isLeaf:  true,
-		entries: make([]Entry[K, V], len(leaf.entries[mid:])),
+		entries: make([]leafEntry[K, V], len(leaf.entries[mid:])),
 		next:    leaf.next,
+		prev:    leaf,
 		parent:  leaf.parent,
 	}
 	copy(newLeaf.entries, leaf.entries[mid:])
 	leaf.entries = leaf.entries[:mid]
+	if leaf.next != nil {
+		leaf.next.prev = newLeaf
+	}
 	leaf.next = newLeaf
 
 	t.insertIntoParent(leaf, newLeaf.entries[0].Key, newLeaf)
@@ -199,6 +1459,10 @@ func (t *BPlusTree[K, V]) splitLeaf(leaf *node[K, V]) {
 
 func (t *BPlusTree[K, V]) splitInternal(n *node[K, V]) {
 	mid := len(n.keys) / 2
+	if t.splitRatio != 0 {
+		// The promoted key goes to neither side
+		mid = t.splitPoint(len(n.keys)-1, t.minInternalKeys())
+	}
 	promoteKey := n.keys[mid]
 
 	newNode := &node[K, V]{
@@ -249,10 +1513,15 @@ func (t *BPlusTree[K, V]) insertIntoParent(left *node[K, V], key K, right *node[
 	}
 }
 
-func (t *BPlusTree[K, V]) rebalanceLeaf(leaf *node[K, V]) {
+// rebalanceLeaf fixes an underfull leaf by borrowing one entry from a
+// sibling or, failing that, merging with one. It reports whether a merge
+// happened.
+func (t *BPlusTree[K, V]) rebalanceLeaf(leaf *node[K, V]) bool {
+	// A merge may detach the hinted leaf
+	t.hint = nil
 	parent := leaf.parent
 	if parent == nil {
-		return
+		return false
 	}
 
 	idx := 0
@@ -265,9 +1534,9 @@ func (t *BPlusTree[K, V]) rebalanceLeaf(leaf *node[K, V]) {
 		if len(leftSibling.entries) > t.minLeafEntries() {
 			borrowed := leftSibling.entries[len(leftSibling.entries)-1]
 			leftSibling.entries = leftSibling.entries[:len(leftSibling.entries)-1]
-			leaf.entries = append([]Entry[K, V]{borrowed}, leaf.entries...)
+			leaf.entries = append([]leafEntry[K, V]{borrowed}, leaf.entries...)
 			parent.keys[idx-1] = leaf.entries[0].Key
-			return
+			return false
 		}
 	}
 
@@ -278,7 +1547,7 @@ func (t *BPlusTree[K, V]) rebalanceLeaf(leaf *node[K, V]) {
 			rightSibling.entries = rightSibling.entries[1:]
 			leaf.entries = append(leaf.entries, borrowed)
 			parent.keys[idx] = rightSibling.entries[0].Key
-			return
+			return false
 		}
 	}
 
@@ -286,13 +1555,20 @@ func (t *BPlusTree[K, V]) rebalanceLeaf(leaf *node[K, V]) {
 		leftSibling := parent.children[idx-1]
 		leftSibling.entries = append(leftSibling.entries, leaf.entries...)
 		leftSibling.next = leaf.next
+		if leaf.next != nil {
+			leaf.next.prev = leftSibling
+		}
 		t.deleteFromParent(parent, idx-1, leaf)
 	} else if idx < len(parent.children)-1 {
 		rightSibling := parent.children[idx+1]
 		leaf.entries = append(leaf.entries, rightSibling.entries...)
 		leaf.next = rightSibling.next
+		if rightSibling.next != nil {
+			rightSibling.next.prev = leaf
+		}
 		t.deleteFromParent(parent, idx, rightSibling)
 	}
+	return true
 }
 
 func (t *BPlusTree[K, V]) deleteFromParent(parent *node[K, V], keyIdx int, child *node[K, V]) {
@@ -381,17 +1657,35 @@ func (t *BPlusTree[K, V]) rebalanceInternal(n *node[K, V]) {
 }
 
 func (t *BPlusTree[K, V]) maxLeafEntries() int {
-	return t.degree*2 - 1
+	return t.leafCap
 }
 
 func (t *BPlusTree[K, V]) minLeafEntries() int {
-	return t.degree - 1
+	return t.leafCap / 2
 }
 
 func (t *BPlusTree[K, V]) maxInternalKeys() int {
-	return t.degree*2 - 1
+	return t.internalCap
 }
 
 func (t *BPlusTree[K, V]) minInternalKeys() int {
-	return t.degree - 1
+	return t.internalCap / 2
+}
+
+// splitPoint returns how many of n entries a split with a custom ratio keeps
+// on the left, leaving at least least on each side
+func (t *BPlusTree[K, V]) splitPoint(n, least int) int {
+	return min(max(int(math.Round(t.splitRatio*float64(n))), least), n-least)
+}
+
+// splitRatioFits reports whether splits at ratio leave both halves of an
+// overflowing leaf and of an overflowing internal node at minimum occupancy,
+// so that splitPoint never has to move the split
+func (t *BPlusTree[K, V]) splitRatioFits(ratio float64) bool {
+	fits := func(n, least int) bool {
+		left := int(math.Round(ratio * float64(n)))
+		return left >= least && n-left >= least
+	}
+	// An internal split promotes one of its keys to neither side
+	return fits(t.maxLeafEntries()+1, t.minLeafEntries()) && fits(t.maxInternalKeys(), t.minInternalKeys())
 }
@@ -1,9 +1,20 @@
 package bplustree
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"math"
 	"math/rand"
 	"slices"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -160,12 +171,12 @@ func TestEmptyTree(t *testing.T) {
 		t.Errorf("Len() on empty tree: expected 0, got %d", tree.Len())
 	}
 
-	if tree.All() != nil {
-		t.Error("All() on empty tree should return nil")
+	if all := tree.All(); all == nil || len(all) != 0 {
+		t.Error("All() on empty tree should return an empty non-nil slice")
 	}
 
-	if tree.Range(1, 10) != nil {
-		t.Error("Range() on empty tree should return nil")
+	if result := tree.Range(1, 10); result == nil || len(result) != 0 {
+		t.Error("Range() on empty tree should return an empty non-nil slice")
 	}
 }
 
@@ -557,13 +568,55 @@ func TestDegreeOne(t *testing.T) {
 	}
 }
 
+func TestDegreeClamping(t *testing.T) {
+	tests := []struct {
+		degree   int
+		expected int
+	}{
+		{-5, 2},
+		{0, 2},
+		{1, 2},
+		{2, 2},
+		{3, 3},
+		{100, 100},
+	}
+
+	for _, tc := range tests {
+		tree := New[int, int](tc.degree)
+		if got := tree.Degree(); got != tc.expected {
+			t.Errorf("New(%d).Degree(): expected %d, got %d", tc.degree, tc.expected, got)
+		}
+
+		for i := 1; i <= 50; i++ {
+			tree.Insert(i, i)
+		}
+		if err := tree.validate(); err != nil {
+			t.Errorf("New(%d): invalid tree: %v", tc.degree, err)
+		}
+	}
+}
+
 // === Tree Structure Validation ===
 
 func (t *BPlusTree[K, V]) validate() error {
 	if t.root == nil {
 		return nil
 	}
-	return t.validateNode(t.root, nil, nil, 0)
+	if err := t.validateNode(t.root, nil, nil, 0); err != nil {
+		return err
+	}
+	flagged := 0
+	for leaf := t.firstLeaf(); leaf != nil; leaf = leaf.next {
+		for _, e := range leaf.entries {
+			if e.deleted {
+				flagged++
+			}
+		}
+	}
+	if flagged != t.tombstones {
+		return fmt.Errorf("%d entries flagged deleted, tombstone count is %d", flagged, t.tombstones)
+	}
+	return nil
 }
 
 func (t *BPlusTree[K, V]) validateNode(n *node[K, V], minKey, maxKey *K, depth int) error {
@@ -810,116 +863,3181 @@ func TestStressMixedOps(t *testing.T) {
 	}
 }
 
-// === Benchmarks ===
+// === Streaming ===
 
-func BenchmarkInsertSequential(b *testing.B) {
-	for _, degree := range []int{3, 10, 50} {
-		b.Run(fmt.Sprintf("degree=%d", degree), func(b *testing.B) {
-			tree := New[int, int](degree)
-			b.ResetTimer()
-			for i := 0; i < b.N; i++ {
-				tree.Insert(i, i)
-			}
-		})
+func TestStream(t *testing.T) {
+	tree := New[int, int](3)
+	for i := 1; i <= 100; i++ {
+		tree.Insert(i, i*10)
+	}
+
+	expected := 1
+	for e := range tree.Stream(context.Background()) {
+		if e.Key != expected || e.Value != expected*10 {
+			t.Errorf("Stream: expected (%d, %d), got (%d, %d)", expected, expected*10, e.Key, e.Value)
+		}
+		expected++
+	}
+
+	if expected != 101 {
+		t.Errorf("Stream: expected 100 entries, got %d", expected-1)
 	}
 }
 
-func BenchmarkInsertRandom(b *testing.B) {
-	keys := make([]int, b.N)
-	for i := range keys {
-		keys[i] = rand.Int()
+func TestStreamEmpty(t *testing.T) {
+	tree := New[int, int](3)
+
+	for e := range tree.Stream(context.Background()) {
+		t.Errorf("Stream on empty tree yielded %v", e)
 	}
+}
 
-	b.ResetTimer()
-	tree := New[int, int](10)
-	for i := 0; i < b.N; i++ {
-		tree.Insert(keys[i], i)
+func TestStreamCancel(t *testing.T) {
+	tree := New[int, int](3)
+	for i := 1; i <= 1000; i++ {
+		tree.Insert(i, i)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := tree.Stream(ctx)
+
+	for i := 0; i < 10; i++ {
+		<-ch
+	}
+	cancel()
+
+	received := 10
+	for range ch {
+		received++
+	}
+
+	if received >= 1000 {
+		t.Errorf("Stream should stop after cancellation, received %d entries", received)
 	}
 }
 
-func BenchmarkSearch(b *testing.B) {
-	tree := New[int, int](10)
-	n := 100000
+func TestLeafChainCascadingSplits(t *testing.T) {
+	sequences := map[string][]int{
+		"ascending":  {},
+		"descending": {},
+		"zigzag":     {},
+	}
+	for i := 1; i <= 200; i++ {
+		sequences["ascending"] = append(sequences["ascending"], i)
+		sequences["descending"] = append(sequences["descending"], 201-i)
+		if i%2 == 0 {
+			sequences["zigzag"] = append(sequences["zigzag"], i)
+		} else {
+			sequences["zigzag"] = append(sequences["zigzag"], 1000-i)
+		}
+	}
 
-	for i := 0; i < n; i++ {
+	for name, keys := range sequences {
+		tree := New[int, int](2)
+		startHeight := 0
+		for i, k := range keys {
+			tree.Insert(k, k)
+			if err := tree.CheckLeafChain(); err != nil {
+				t.Fatalf("%s: broken leaf chain after inserting %d: %v", name, k, err)
+			}
+			if tree.Len() != i+1 {
+				t.Fatalf("%s: expected len=%d, got=%d", name, i+1, tree.Len())
+			}
+			if i == 0 {
+				startHeight = tree.height()
+			}
+		}
+		if tree.height()-startHeight < 3 {
+			t.Errorf("%s: expected multi-level splits, height only %d", name, tree.height())
+		}
+		if err := tree.validate(); err != nil {
+			t.Errorf("%s: invalid tree: %v", name, err)
+		}
+	}
+}
+
+func TestCheckLeafChainDetectsBreak(t *testing.T) {
+	tree := New[int, int](2)
+	for i := 1; i <= 20; i++ {
 		tree.Insert(i, i)
 	}
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		tree.Search(i % n)
+	first := tree.firstLeaf()
+	first.next = first.next.next
+
+	if err := tree.CheckLeafChain(); err == nil {
+		t.Error("CheckLeafChain should report a skipped leaf")
 	}
 }
 
-func BenchmarkSearchRandom(b *testing.B) {
-	tree := New[int, int](10)
-	n := 100000
+func TestMultiRange(t *testing.T) {
+	tree := New[int, int](3)
+	for i := 1; i <= 100; i++ {
+		tree.Insert(i, i*10)
+	}
 
-	for i := 0; i < n; i++ {
+	result := tree.MultiRange([][2]int{{50, 55}, {10, 12}, {11, 14}, {90, 200}, {30, 20}})
+
+	expected := []int{10, 11, 12, 13, 14, 50, 51, 52, 53, 54, 55}
+	for i := 90; i <= 100; i++ {
+		expected = append(expected, i)
+	}
+
+	if len(result) != len(expected) {
+		t.Fatalf("MultiRange: expected %d entries, got %d", len(expected), len(result))
+	}
+	for i, e := range result {
+		if e.Key != expected[i] || e.Value != expected[i]*10 {
+			t.Errorf("MultiRange[%d]: expected key %d, got %d", i, expected[i], e.Key)
+		}
+	}
+}
+
+func TestMultiRangeEmpty(t *testing.T) {
+	tree := New[int, int](3)
+
+	if result := tree.MultiRange([][2]int{{1, 10}}); len(result) != 0 {
+		t.Errorf("MultiRange on empty tree: expected no entries, got %d", len(result))
+	}
+
+	for i := 1; i <= 10; i++ {
 		tree.Insert(i, i)
 	}
 
-	keys := make([]int, b.N)
-	for i := range keys {
-		keys[i] = rand.Intn(n)
+	if result := tree.MultiRange(nil); len(result) != 0 {
+		t.Errorf("MultiRange with no intervals: expected no entries, got %d", len(result))
+	}
+	if result := tree.MultiRange([][2]int{{20, 30}, {-5, 0}}); len(result) != 0 {
+		t.Errorf("MultiRange outside keys: expected no entries, got %d", len(result))
 	}
+}
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		tree.Search(keys[i])
+func TestEqual(t *testing.T) {
+	a := New[int, string](2)
+	b := New[int, string](5)
+
+	if !Equal(a, b) {
+		t.Error("Empty trees should be equal")
+	}
+
+	for i := 1; i <= 100; i++ {
+		a.Insert(i, fmt.Sprintf("v%d", i))
+	}
+	for i := 100; i >= 1; i-- {
+		b.Insert(i, fmt.Sprintf("v%d", i))
+	}
+
+	if !Equal(a, b) {
+		t.Error("Trees with the same entries should be equal")
+	}
+
+	b.Insert(50, "changed")
+	if Equal(a, b) {
+		t.Error("Trees with different values should not be equal")
+	}
+
+	b.Insert(50, "v50")
+	b.Delete(100)
+	if Equal(a, b) || Equal(b, a) {
+		t.Error("Trees with different lengths should not be equal")
+	}
+
+	b.Insert(101, "v100")
+	if Equal(a, b) {
+		t.Error("Trees with different keys should not be equal")
 	}
 }
 
-func BenchmarkDelete(b *testing.B) {
-	for _, degree := range []int{3, 10, 50} {
-		b.Run(fmt.Sprintf("degree=%d", degree), func(b *testing.B) {
+// === Bulk Loading ===
+
+func TestBulkLoad(t *testing.T) {
+	for _, degree := range []int{2, 3, 10} {
+		for n := 0; n <= 300; n++ {
+			entries := make([]Entry[int, int], n)
+			for i := range entries {
+				entries[i] = Entry[int, int]{Key: n - i, Value: (n - i) * 10}
+			}
+
 			tree := New[int, int](degree)
-			for i := 0; i < b.N; i++ {
-				tree.Insert(i, i)
+			tree.Insert(-1, -1)
+			tree.BulkLoad(entries)
+
+			if tree.Len() != n {
+				t.Fatalf("degree=%d n=%d: expected len=%d, got=%d", degree, n, n, tree.Len())
 			}
+			if err := tree.validate(); err != nil {
+				t.Fatalf("degree=%d n=%d: invalid tree: %v", degree, n, err)
+			}
+			if err := tree.CheckLeafChain(); err != nil {
+				t.Fatalf("degree=%d n=%d: broken leaf chain: %v", degree, n, err)
+			}
+			for i := 1; i <= n; i++ {
+				if v, found := tree.Search(i); !found || v != i*10 {
+					t.Fatalf("degree=%d n=%d: Search(%d) = %d, %v", degree, n, i, v, found)
+				}
+			}
+		}
+	}
+}
 
-			b.ResetTimer()
-			for i := 0; i < b.N; i++ {
-				tree.Delete(i)
+func TestBulkLoadDuplicates(t *testing.T) {
+	tree := New[int, string](3)
+	tree.BulkLoad([]Entry[int, string]{{Key: 2, Value: "a"}, {Key: 1, Value: "b"}, {Key: 2, Value: "c"}})
+
+	if tree.Len() != 2 {
+		t.Errorf("Expected len=2, got=%d", tree.Len())
+	}
+	if v, _ := tree.Search(2); v != "c" {
+		t.Errorf("Expected last duplicate to win, got %q", v)
+	}
+}
+
+func TestBulkLoadFill(t *testing.T) {
+	entries := make([]Entry[int, int], 1000)
+	for i := range entries {
+		entries[i] = Entry[int, int]{Key: i, Value: i}
+	}
+
+	for _, fill := range []float64{0.01, 0.3, 0.5, 0.7, 1} {
+		for _, degree := range []int{2, 4, 16} {
+			tree := New[int, int](degree)
+			if err := tree.BulkLoadFill(entries, fill); err != nil {
+				t.Fatalf("fill=%v degree=%d: unexpected error: %v", fill, degree, err)
 			}
-		})
+			if err := tree.validate(); err != nil {
+				t.Fatalf("fill=%v degree=%d: invalid tree: %v", fill, degree, err)
+			}
+			if tree.Len() != len(entries) {
+				t.Fatalf("fill=%v degree=%d: expected len=%d, got=%d", fill, degree, len(entries), tree.Len())
+			}
+
+			for i := len(entries); i < 2*len(entries); i++ {
+				tree.Insert(i, i)
+			}
+			if err := tree.validate(); err != nil {
+				t.Fatalf("fill=%v degree=%d: invalid tree after inserts: %v", fill, degree, err)
+			}
+		}
+	}
+
+	full := New[int, int](10)
+	full.BulkLoadFill(entries, 1)
+	sparse := New[int, int](10)
+	sparse.BulkLoadFill(entries, 0.7)
+	if sparse.countLeaves() <= full.countLeaves() {
+		t.Errorf("Expected lower fill factor to use more leaves: 0.7 -> %d, 1.0 -> %d", sparse.countLeaves(), full.countLeaves())
 	}
 }
 
-func BenchmarkRange(b *testing.B) {
-	tree := New[int, int](10)
-	n := 100000
+func TestBulkLoadFillInvalid(t *testing.T) {
+	tree := New[int, int](3)
+	tree.Insert(1, 1)
 
-	for i := 0; i < n; i++ {
-		tree.Insert(i, i)
+	for _, fill := range []float64{0, -0.5, 1.5, math.NaN()} {
+		if err := tree.BulkLoadFill(nil, fill); !errors.Is(err, ErrInvalidFillFactor) {
+			t.Errorf("fill=%v: expected ErrInvalidFillFactor, got %v", fill, err)
+		}
 	}
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		start := i % (n - 100)
-		tree.Range(start, start+100)
+	if tree.Len() != 1 {
+		t.Error("Rejected bulk load should leave the tree unchanged")
 	}
 }
 
-func BenchmarkMixedOps(b *testing.B) {
-	tree := New[int, int](10)
+// === Aggregate ===
 
-	for i := 0; i < 10000; i++ {
-		tree.Insert(i, i)
+func TestAggregate(t *testing.T) {
+	add := func(a, b int) int { return a + b }
+	sub := func(a, b int) int { return a - b }
+	tree := NewWithAggregate[int, int](3, 0, add, sub)
+
+	expected := make(map[int]int)
+	sum := func() int {
+		total := 0
+		for _, v := range expected {
+			total += v
+		}
+		return total
 	}
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		op := i % 10
-		key := i % 10000
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		key := rng.Intn(100)
+		if rng.Intn(3) == 0 {
+			tree.Delete(key)
+			delete(expected, key)
+		} else {
+			value := rng.Intn(1000)
+			tree.Insert(key, value)
+			expected[key] = value
+		}
 
-		switch {
-		case op < 5:
-			tree.Search(key)
-		case op < 8:
-			tree.Insert(key+10000, i)
-		default:
-			tree.Delete(key + 10000)
+		if tree.Aggregate() != sum() {
+			t.Fatalf("Iteration %d: expected aggregate %d, got %d", i, sum(), tree.Aggregate())
+		}
+	}
+
+	tree.BulkLoad([]Entry[int, int]{{Key: 1, Value: 10}, {Key: 2, Value: 20}, {Key: 3, Value: 30}})
+	if tree.Aggregate() != 60 {
+		t.Errorf("Expected aggregate 60 after bulk load, got %d", tree.Aggregate())
+	}
+}
+
+func TestAggregateDisabled(t *testing.T) {
+	tree := New[int, int](3)
+	tree.Insert(1, 100)
+
+	if tree.Aggregate() != 0 {
+		t.Errorf("Expected zero aggregate without NewWithAggregate, got %d", tree.Aggregate())
+	}
+}
+
+func TestTopK(t *testing.T) {
+	tree := New[int, int](3)
+
+	if result := tree.TopK(5); len(result) != 0 {
+		t.Errorf("TopK on empty tree: expected no entries, got %d", len(result))
+	}
+
+	for i := 1; i <= 100; i++ {
+		tree.Insert(i, i*10)
+	}
+
+	result := tree.TopK(15)
+	if len(result) != 15 {
+		t.Fatalf("TopK(15): expected 15 entries, got %d", len(result))
+	}
+	for i, e := range result {
+		if e.Key != 100-i || e.Value != (100-i)*10 {
+			t.Errorf("TopK[%d]: expected key %d, got %d", i, 100-i, e.Key)
+		}
+	}
+
+	if result := tree.TopK(500); len(result) != 100 {
+		t.Errorf("TopK(500): expected all 100 entries, got %d", len(result))
+	}
+	if result := tree.TopK(0); len(result) != 0 {
+		t.Errorf("TopK(0): expected no entries, got %d", len(result))
+	}
+}
+
+func TestPrevLinksRandomOps(t *testing.T) {
+	tree := New[int, int](2)
+	rng := rand.New(rand.NewSource(7))
+
+	for i := 0; i < 3000; i++ {
+		key := rng.Intn(300)
+		if rng.Intn(2) == 0 {
+			tree.Delete(key)
+		} else {
+			tree.Insert(key, key)
+		}
+
+		if err := tree.CheckLeafChain(); err != nil {
+			t.Fatalf("Iteration %d: %v", i, err)
+		}
+	}
+
+	all := tree.All()
+	top := tree.TopK(len(all))
+	for i := range top {
+		if top[i] != all[len(all)-1-i] {
+			t.Fatalf("TopK should mirror All at %d: %v vs %v", i, top[i], all[len(all)-1-i])
+		}
+	}
+}
+
+func TestInsertIfAbsent(t *testing.T) {
+	tree := NewWithAggregate[int, int](2, 0,
+		func(a, b int) int { return a + b },
+		func(a, b int) int { return a - b })
+
+	for i := 1; i <= 50; i++ {
+		if !tree.InsertIfAbsent(i, i) {
+			t.Errorf("InsertIfAbsent(%d) should insert a new key", i)
+		}
+	}
+	for i := 1; i <= 50; i++ {
+		if tree.InsertIfAbsent(i, -i) {
+			t.Errorf("InsertIfAbsent(%d) should not insert an existing key", i)
+		}
+		if v, _ := tree.Search(i); v != i {
+			t.Errorf("InsertIfAbsent(%d) should keep value %d, got %d", i, i, v)
 		}
 	}
+
+	if tree.Len() != 50 {
+		t.Errorf("Expected len=50, got=%d", tree.Len())
+	}
+	if tree.Aggregate() != 50*51/2 {
+		t.Errorf("Expected aggregate %d, got %d", 50*51/2, tree.Aggregate())
+	}
+	if err := tree.validate(); err != nil {
+		t.Errorf("Invalid tree: %v", err)
+	}
+}
+
+func TestRetain(t *testing.T) {
+	tree := NewWithAggregate[int, int](3, 0,
+		func(a, b int) int { return a + b },
+		func(a, b int) int { return a - b })
+	for i := 1; i <= 200; i++ {
+		tree.Insert(i, i)
+	}
+
+	tree.Retain(func(k, v int) bool { return k%3 == 0 })
+
+	if tree.Len() != 66 {
+		t.Errorf("Expected len=66, got=%d", tree.Len())
+	}
+	for i := 1; i <= 200; i++ {
+		_, found := tree.Search(i)
+		if found != (i%3 == 0) {
+			t.Errorf("Search(%d): expected found=%v", i, i%3 == 0)
+		}
+	}
+	if err := tree.validate(); err != nil {
+		t.Errorf("Invalid tree: %v", err)
+	}
+	if err := tree.CheckLeafChain(); err != nil {
+		t.Errorf("Broken leaf chain: %v", err)
+	}
+	if tree.Aggregate() != 3*66*67/2 {
+		t.Errorf("Expected aggregate %d, got %d", 3*66*67/2, tree.Aggregate())
+	}
+
+	tree.Retain(func(k, v int) bool { return false })
+	if tree.Len() != 0 || tree.root != nil {
+		t.Errorf("Expected empty tree, got len=%d", tree.Len())
+	}
+}
+
+func TestPrefixRange(t *testing.T) {
+	tree := New[string, int](3)
+	words := []string{"a", "ab", "abc", "abd", "abz", "ac", "b", "ba", "\xff", "\xff\xff", "\xff\xffa", "\xfe\xff"}
+	for i, w := range words {
+		tree.Insert(w, i)
+	}
+
+	tests := []struct {
+		prefix   string
+		expected []string
+	}{
+		{"ab", []string{"ab", "abc", "abd", "abz"}},
+		{"abc", []string{"abc"}},
+		{"b", []string{"b", "ba"}},
+		{"ac", []string{"ac"}},
+		{"zz", nil},
+		{"\xff", []string{"\xff", "\xff\xff", "\xff\xffa"}},
+		{"\xff\xff", []string{"\xff\xff", "\xff\xffa"}},
+		{"\xfe", []string{"\xfe\xff"}},
+		{"", words},
+	}
+
+	for _, tc := range tests {
+		var got []string
+		for _, e := range PrefixRange(tree, tc.prefix) {
+			got = append(got, e.Key)
+			if !strings.HasPrefix(e.Key, tc.prefix) {
+				t.Errorf("PrefixRange(%q) returned key %q without the prefix", tc.prefix, e.Key)
+			}
+		}
+		expected := slices.Clone(tc.expected)
+		slices.Sort(expected)
+		if !slices.Equal(got, expected) {
+			t.Errorf("PrefixRange(%q): expected %q, got %q", tc.prefix, expected, got)
+		}
+	}
+
+	if result := PrefixRange(New[string, int](3), "a"); len(result) != 0 {
+		t.Errorf("PrefixRange on empty tree: expected no entries, got %d", len(result))
+	}
+}
+
+func TestDeleteBatch(t *testing.T) {
+	for _, degree := range []int{2, 3, 8} {
+		rng := rand.New(rand.NewSource(int64(degree)))
+		for round := 0; round < 50; round++ {
+			tree := New[int, int](degree)
+			expected := make(map[int]bool)
+			for i := 0; i < 500; i++ {
+				key := rng.Intn(1000)
+				tree.Insert(key, key)
+				expected[key] = true
+			}
+
+			var keys []int
+			want := 0
+			for i := 0; i < rng.Intn(800); i++ {
+				key := rng.Intn(1100)
+				keys = append(keys, key)
+				if expected[key] {
+					delete(expected, key)
+					want++
+				}
+			}
+
+			if got := tree.DeleteBatch(keys); got != want {
+				t.Fatalf("degree=%d round=%d: expected %d removed, got %d", degree, round, want, got)
+			}
+			if err := tree.validate(); err != nil {
+				t.Fatalf("degree=%d round=%d: invalid tree: %v", degree, round, err)
+			}
+			if err := tree.CheckLeafChain(); err != nil {
+				t.Fatalf("degree=%d round=%d: broken leaf chain: %v", degree, round, err)
+			}
+			if tree.Len() != len(expected) {
+				t.Fatalf("degree=%d round=%d: expected len=%d, got=%d", degree, round, len(expected), tree.Len())
+			}
+			for key := range expected {
+				if _, found := tree.Search(key); !found {
+					t.Fatalf("degree=%d round=%d: key %d missing", degree, round, key)
+				}
+			}
+		}
+	}
+}
+
+func TestDeleteBatchAll(t *testing.T) {
+	tree := New[int, int](3)
+	keys := make([]int, 300)
+	for i := range keys {
+		keys[i] = i
+		tree.Insert(i, i)
+	}
+
+	if removed := tree.DeleteBatch(append(keys, keys...)); removed != 300 {
+		t.Errorf("Expected 300 removed, got %d", removed)
+	}
+	if tree.Len() != 0 || tree.root != nil {
+		t.Errorf("Expected empty tree, got len=%d", tree.Len())
+	}
+	if removed := tree.DeleteBatch(keys); removed != 0 {
+		t.Errorf("Expected 0 removed from empty tree, got %d", removed)
+	}
+}
+
+// === Mutation Hook ===
+
+func TestMutationHook(t *testing.T) {
+	tree := New[int, string](2)
+
+	type event struct {
+		op    Op
+		key   int
+		value string
+	}
+	var events []event
+	tree.SetMutationHook(func(op Op, key int, value string) {
+		events = append(events, event{op, key, value})
+	})
+
+	for i := 1; i <= 20; i++ {
+		tree.Insert(i, "a")
+	}
+	tree.Insert(5, "b")
+	tree.InsertIfAbsent(6, "c")
+	tree.InsertIfAbsent(21, "d")
+	tree.Delete(7)
+	tree.Delete(100)
+	tree.DeleteBatch([]int{1, 2, 100})
+	tree.Retain(func(k int, v string) bool { return k != 3 })
+
+	expected := []event{{OpUpdate, 5, "b"}, {OpInsert, 21, "d"}, {OpDelete, 7, "a"},
+		{OpDelete, 1, "a"}, {OpDelete, 2, "a"}, {OpDelete, 3, "a"}}
+	if len(events) != 20+len(expected) {
+		t.Fatalf("Expected %d events, got %d", 20+len(expected), len(events))
+	}
+	for i := 0; i < 20; i++ {
+		if events[i] != (event{OpInsert, i + 1, "a"}) {
+			t.Errorf("Event %d: expected insert of %d, got %v", i, i+1, events[i])
+		}
+	}
+	for i, e := range expected {
+		if events[20+i] != e {
+			t.Errorf("Event %d: expected %v, got %v", 20+i, e, events[20+i])
+		}
+	}
+
+	events = nil
+	tree.BulkLoad([]Entry[int, string]{{Key: 1, Value: "x"}})
+	deletes, inserts := 0, 0
+	for _, e := range events {
+		switch e.op {
+		case OpDelete:
+			deletes++
+		case OpInsert:
+			inserts++
+		}
+	}
+	if deletes != 17 || inserts != 1 {
+		t.Errorf("BulkLoad: expected 17 deletes and 1 insert, got %d and %d", deletes, inserts)
+	}
+
+	events = nil
+	tree.SetMutationHook(nil)
+	tree.Insert(2, "y")
+	if len(events) != 0 {
+		t.Errorf("Expected no events after removing the hook, got %d", len(events))
+	}
+}
+
+func TestCompact(t *testing.T) {
+	tree := New[int, int](4)
+	for i := 0; i < 2000; i++ {
+		tree.Insert(i, i)
+	}
+	for i := 0; i < 2000; i++ {
+		if i%4 != 0 {
+			tree.Delete(i)
+		}
+	}
+
+	before := tree.All()
+	leavesBefore := tree.countLeaves()
+
+	tree.Compact()
+
+	if tree.Len() != len(before) {
+		t.Errorf("Expected len=%d after compaction, got=%d", len(before), tree.Len())
+	}
+	if !slices.Equal(tree.All(), before) {
+		t.Error("Compaction changed the entries")
+	}
+	if err := tree.validate(); err != nil {
+		t.Errorf("Invalid tree after compaction: %v", err)
+	}
+	if err := tree.CheckLeafChain(); err != nil {
+		t.Errorf("Broken leaf chain after compaction: %v", err)
+	}
+	if tree.countLeaves() >= leavesBefore {
+		t.Errorf("Expected fewer leaves after compaction: before=%d, after=%d", leavesBefore, tree.countLeaves())
+	}
+
+	if err := tree.CompactFill(0.6); err != nil {
+		t.Errorf("CompactFill(0.6): unexpected error: %v", err)
+	}
+	if err := tree.validate(); err != nil {
+		t.Errorf("Invalid tree after CompactFill: %v", err)
+	}
+	if !slices.Equal(tree.All(), before) {
+		t.Error("CompactFill changed the entries")
+	}
+	if err := tree.CompactFill(0); !errors.Is(err, ErrInvalidFillFactor) {
+		t.Errorf("CompactFill(0): expected ErrInvalidFillFactor, got %v", err)
+	}
+}
+
+func (t *BPlusTree[K, V]) chainCount() int {
+	count := 0
+	for leaf := t.firstLeaf(); leaf != nil; leaf = leaf.next {
+		count += len(leaf.entries)
+	}
+	return count
+}
+
+func TestLenMatchesLeafChain(t *testing.T) {
+	tree := New[int, int](2)
+	rng := rand.New(rand.NewSource(11))
+
+	for i := 0; i < 5000; i++ {
+		key := rng.Intn(500)
+		switch op := rng.Intn(100); {
+		case op < 45:
+			tree.Insert(key, key)
+		case op < 55:
+			tree.InsertIfAbsent(key, key)
+		case op < 90:
+			tree.Delete(key)
+		case op < 97:
+			tree.DeleteBatch([]int{key, key + 1, key + 7, key})
+		case op < 98:
+			tree.Retain(func(k, v int) bool { return k%2 == 0 })
+		case op < 99:
+			tree.Compact()
+		default:
+			tree.Clear()
+		}
+
+		if tree.Len() != tree.chainCount() {
+			t.Fatalf("Iteration %d: Len()=%d, leaf chain holds %d", i, tree.Len(), tree.chainCount())
+		}
+	}
+}
+
+func TestClear(t *testing.T) {
+	tree := NewWithAggregate[int, int](3, 0,
+		func(a, b int) int { return a + b },
+		func(a, b int) int { return a - b })
+	for i := 1; i <= 100; i++ {
+		tree.Insert(i, i)
+	}
+
+	deletes := 0
+	tree.SetMutationHook(func(op Op, key, value int) {
+		if op == OpDelete {
+			deletes++
+		}
+	})
+	tree.Clear()
+
+	if tree.Len() != 0 || tree.root != nil {
+		t.Errorf("Expected empty tree after Clear, got len=%d", tree.Len())
+	}
+	if tree.Aggregate() != 0 {
+		t.Errorf("Expected zero aggregate after Clear, got %d", tree.Aggregate())
+	}
+	if deletes != 100 {
+		t.Errorf("Expected 100 delete events, got %d", deletes)
+	}
+
+	tree.Insert(1, 1)
+	if tree.Len() != 1 {
+		t.Errorf("Expected len=1 after reuse, got=%d", tree.Len())
+	}
+}
+
+// === Sharded Tree ===
+
+func TestShardedBPlusTree(t *testing.T) {
+	sharded := NewSharded[int, int](8, 3)
+	single := New[int, int](3)
+	rng := rand.New(rand.NewSource(13))
+
+	for i := 0; i < 3000; i++ {
+		key := rng.Intn(1000)
+		if rng.Intn(3) == 0 {
+			if sharded.Delete(key) != single.Delete(key) {
+				t.Fatalf("Delete(%d) result differs from single tree", key)
+			}
+		} else {
+			sharded.Insert(key, i)
+			single.Insert(key, i)
+		}
+	}
+
+	if sharded.Len() != single.Len() {
+		t.Errorf("Expected len=%d, got=%d", single.Len(), sharded.Len())
+	}
+	for key := 0; key < 1000; key++ {
+		v1, f1 := sharded.Search(key)
+		v2, f2 := single.Search(key)
+		if v1 != v2 || f1 != f2 {
+			t.Fatalf("Search(%d): expected (%d, %v), got (%d, %v)", key, v2, f2, v1, f1)
+		}
+	}
+
+	if !slices.Equal(sharded.All(), single.All()) {
+		t.Error("All() should match a single tree")
+	}
+	if !slices.Equal(sharded.Range(100, 400), single.Range(100, 400)) {
+		t.Error("Range() should match a single tree")
+	}
+	if result := sharded.Range(2000, 3000); len(result) != 0 {
+		t.Errorf("Expected empty range, got %d entries", len(result))
+	}
+}
+
+func TestShardedBPlusTreeConcurrent(t *testing.T) {
+	tree := NewSharded[int, int](4, 3)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := w*1000 + i
+				tree.Insert(key, key)
+				if v, found := tree.Search(key); !found || v != key {
+					t.Errorf("Search(%d) after insert: got (%d, %v)", key, v, found)
+				}
+				if i%2 == 0 {
+					tree.Delete(key)
+				}
+				if i%100 == 0 {
+					tree.Range(w*1000, w*1000+100)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if tree.Len() != 8*250 {
+		t.Errorf("Expected len=%d, got=%d", 8*250, tree.Len())
+	}
+	all := tree.All()
+	for i := 1; i < len(all); i++ {
+		if all[i-1].Key >= all[i].Key {
+			t.Fatalf("All() not sorted at %d", i)
+		}
+	}
+}
+
+func TestShardedShardCount(t *testing.T) {
+	for _, n := range []int{-1, 0, 1} {
+		if got := len(NewSharded[int, int](n, 3).shards); got != 1 {
+			t.Errorf("NewSharded(%d): expected 1 shard, got %d", n, got)
+		}
+	}
+}
+
+func TestEmptyResultsNotNil(t *testing.T) {
+	tree := New[string, int](3)
+	tree.Insert("m", 1)
+	tree.Delete("m")
+
+	checks := map[string]bool{
+		"Range":       tree.Range("a", "z") != nil,
+		"MultiRange":  tree.MultiRange(nil) != nil,
+		"PrefixRange": PrefixRange(tree, "x") != nil,
+		"PrefixAll":   PrefixRange(tree, "") != nil,
+		"TopK":        tree.TopK(0) != nil,
+		"All":         tree.All() != nil,
+		"Keys":        tree.Keys() != nil,
+		"Values":      tree.Values() != nil,
+	}
+	for name, ok := range checks {
+		if !ok {
+			t.Errorf("%s on empty tree should return a non-nil slice", name)
+		}
+	}
+
+	tree.Insert("b", 2)
+	tree.Insert("a", 1)
+	if got := tree.Range("x", "z"); got == nil || len(got) != 0 {
+		t.Errorf("Range without matches should return an empty non-nil slice, got %v", got)
+	}
+	if got := tree.MultiRange([][2]string{{"z", "a"}}); got == nil || len(got) != 0 {
+		t.Errorf("MultiRange with empty intervals should return an empty non-nil slice, got %v", got)
+	}
+	if !slices.Equal(tree.Keys(), []string{"a", "b"}) || !slices.Equal(tree.Values(), []int{1, 2}) {
+		t.Errorf("Unexpected Keys/Values: %v %v", tree.Keys(), tree.Values())
+	}
+
+	sharded := NewSharded[int, int](4, 3)
+	if sharded.All() == nil || sharded.Range(1, 2) == nil {
+		t.Error("Sharded All/Range on empty tree should return non-nil slices")
+	}
+}
+
+func TestSpillAndMergeSortedStreams(t *testing.T) {
+	tree := New[int, string](3)
+	rng := rand.New(rand.NewSource(7))
+	var runs []io.Reader
+	var want []int
+	for run := 0; run < 4; run++ {
+		for i := 0; i < 50; i++ {
+			k := rng.Intn(1000)
+			tree.Insert(k, fmt.Sprint(k))
+		}
+		want = append(want, tree.Keys()...)
+		var buf bytes.Buffer
+		if err := tree.Spill(&buf); err != nil {
+			t.Fatalf("Spill: %v", err)
+		}
+		if tree.Len() != 0 {
+			t.Fatalf("expected empty tree after Spill, got %d", tree.Len())
+		}
+		if err := tree.validate(); err != nil {
+			t.Fatalf("invalid tree after Spill: %v", err)
+		}
+		runs = append(runs, &buf)
+	}
+	// An empty run contributes nothing
+	var empty bytes.Buffer
+	if err := tree.Spill(&empty); err != nil {
+		t.Fatalf("Spill empty: %v", err)
+	}
+	runs = append(runs, &empty)
+	slices.Sort(want)
+
+	var out bytes.Buffer
+	if err := MergeSortedStreams[int, string](runs, &out); err != nil {
+		t.Fatalf("MergeSortedStreams: %v", err)
+	}
+
+	dec := gob.NewDecoder(&out)
+	var got []int
+	for {
+		var e Entry[int, string]
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		if e.Value != fmt.Sprint(e.Key) {
+			t.Errorf("key %d has value %q", e.Key, e.Value)
+		}
+		got = append(got, e.Key)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("merged keys = %v, want %v", got, want)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("write failed") }
+
+func TestSpillErrorKeepsTree(t *testing.T) {
+	tree := New[int, int](3)
+	for i := 0; i < 10; i++ {
+		tree.Insert(i, i)
+	}
+	if err := tree.Spill(failingWriter{}); err == nil {
+		t.Fatal("expected error from failing writer")
+	}
+	if tree.Len() != 10 {
+		t.Errorf("expected tree unchanged after failed Spill, got %d entries", tree.Len())
+	}
+}
+
+func TestSpillSkipsCallbacks(t *testing.T) {
+	tree := New[int, int](3)
+	for i := 0; i < 10; i++ {
+		tree.Insert(i, i)
+	}
+	calls := 0
+	tree.SetMutationHook(func(Op, int, int) { calls++ })
+	tree.SetEvictionCallback(func(int, int) { calls++ })
+	if err := tree.Spill(io.Discard); err != nil {
+		t.Fatalf("Spill: %v", err)
+	}
+	if tree.Len() != 0 || calls != 0 {
+		t.Errorf("Spill left %d entries and made %d callback calls, want none", tree.Len(), calls)
+	}
+}
+
+func TestNewWithCapacities(t *testing.T) {
+	caps := [][2]int{{3, 3}, {3, 32}, {4, 9}, {16, 3}, {5, 6}, {4, 4}, {6, 8}}
+	for _, c := range caps {
+		tree := NewWithCapacities[int, int](c[0], c[1])
+		rng := rand.New(rand.NewSource(int64(c[0]*100 + c[1])))
+		ref := map[int]int{}
+		for i := 0; i < 3000; i++ {
+			k := rng.Intn(500)
+			if rng.Intn(3) == 0 {
+				tree.Delete(k)
+				delete(ref, k)
+			} else {
+				tree.Insert(k, i)
+				ref[k] = i
+			}
+			if i%100 == 0 {
+				if err := tree.validate(); err != nil {
+					t.Fatalf("caps %v: invalid tree after op %d: %v", c, i, err)
+				}
+			}
+		}
+		if err := tree.validate(); err != nil {
+			t.Fatalf("caps %v: invalid tree: %v", c, err)
+		}
+		if err := tree.CheckLeafChain(); err != nil {
+			t.Fatalf("caps %v: %v", c, err)
+		}
+		if tree.Len() != len(ref) {
+			t.Errorf("caps %v: expected %d entries, got %d", c, len(ref), tree.Len())
+		}
+		for k, v := range ref {
+			if got, ok := tree.Search(k); !ok || got != v {
+				t.Errorf("caps %v: Search(%d) = %d, %v; want %d", c, k, got, ok, v)
+			}
+		}
+	}
+}
+
+func TestNewWithCapacitiesShape(t *testing.T) {
+	narrow := NewWithCapacities[int, int](3, 3)
+	wide := NewWithCapacities[int, int](3, 64)
+	for i := 0; i < 1000; i++ {
+		narrow.Insert(i, i)
+		wide.Insert(i, i)
+	}
+	if wide.height() >= narrow.height() {
+		t.Errorf("expected wide internal nodes to be shallower: %d >= %d", wide.height(), narrow.height())
+	}
+	for leaf := wide.firstLeaf(); leaf != nil; leaf = leaf.next {
+		if len(leaf.entries) > 3 {
+			t.Fatalf("leaf holds %d entries, capacity is 3", len(leaf.entries))
+		}
+	}
+
+	// Capacities are clamped like degrees
+	tree := NewWithCapacities[int, int](0, -1)
+	if tree.maxLeafEntries() != 3 || tree.maxInternalKeys() != 3 {
+		t.Errorf("expected capacities clamped to 3, got %d and %d", tree.maxLeafEntries(), tree.maxInternalKeys())
+	}
+	if New[int, int](5).maxLeafEntries() != 9 {
+		t.Errorf("expected New(5) to hold 9 entries per leaf")
+	}
+
+	// Half full, rounding down, for even and odd capacities alike
+	for _, c := range [][3]int{{3, 1, 1}, {4, 2, 2}, {5, 2, 2}, {8, 4, 4}, {9, 4, 4}} {
+		tree := NewWithCapacities[int, int](c[0], c[0])
+		if tree.minLeafEntries() != c[1] || tree.minInternalKeys() != c[2] {
+			t.Errorf("capacity %d: minimums %d and %d, want %d and %d",
+				c[0], tree.minLeafEntries(), tree.minInternalKeys(), c[1], c[2])
+		}
+	}
+}
+
+func TestRepairLeafChain(t *testing.T) {
+	tree := New[int, int](3)
+	if n := tree.RepairLeafChain(); n != 0 {
+		t.Errorf("expected 0 fixes on empty tree, got %d", n)
+	}
+	for i := 0; i < 200; i++ {
+		tree.Insert(i, i)
+	}
+	if n := tree.RepairLeafChain(); n != 0 {
+		t.Errorf("expected 0 fixes on intact chain, got %d", n)
+	}
+
+	var leaves []*node[int, int]
+	tree.collectLeaves(tree.root, &leaves)
+	if len(leaves) < 4 {
+		t.Fatalf("expected several leaves, got %d", len(leaves))
+	}
+
+	// Corrupt the chain: skip a leaf, point the last leaf back to the start
+	// and clear a prev pointer
+	leaves[0].next = leaves[2]
+	leaves[len(leaves)-1].next = leaves[0]
+	leaves[3].prev = nil
+	if tree.CheckLeafChain() == nil {
+		t.Fatal("expected CheckLeafChain to detect corruption")
+	}
+
+	if n := tree.RepairLeafChain(); n != 3 {
+		t.Errorf("expected 3 fixes, got %d", n)
+	}
+	if err := tree.CheckLeafChain(); err != nil {
+		t.Fatalf("chain still broken after repair: %v", err)
+	}
+	if got := len(tree.All()); got != 200 {
+		t.Errorf("expected 200 entries after repair, got %d", got)
+	}
+}
+
+func TestWalkNodes(t *testing.T) {
+	tree := New[int, int](3)
+	calls := 0
+	tree.WalkNodes(func(bool, []int, []Entry[int, int], int) { calls++ })
+	if calls != 0 {
+		t.Errorf("expected no callbacks on empty tree, got %d", calls)
+	}
+
+	for i := 0; i < 100; i++ {
+		tree.Insert(i, i*10)
+	}
+
+	var keys []int
+	leaves, internals := 0, 0
+	first := true
+	tree.WalkNodes(func(isLeaf bool, nodeKeys []int, entries []Entry[int, int], depth int) {
+		if first {
+			if depth != 0 || isLeaf {
+				t.Errorf("expected internal root at depth 0, got leaf=%v depth=%d", isLeaf, depth)
+			}
+			first = false
+		}
+		if isLeaf {
+			leaves++
+			if nodeKeys != nil {
+				t.Errorf("expected nil keys for leaf")
+			}
+			if depth != tree.height()-1 {
+				t.Errorf("leaf at depth %d, expected %d", depth, tree.height()-1)
+			}
+			for i := range entries {
+				keys = append(keys, entries[i].Key)
+				entries[i].Value = -1
+			}
+		} else {
+			internals++
+			if entries != nil || len(nodeKeys) == 0 {
+				t.Errorf("expected keys and nil entries for internal node")
+			}
+			nodeKeys[0] = -1
+		}
+	})
+
+	// Pre-order visits leaves left to right
+	if !slices.Equal(keys, tree.Keys()) {
+		t.Errorf("leaf keys out of order: %v", keys)
+	}
+	if leaves != tree.countLeaves() || internals == 0 {
+		t.Errorf("visited %d leaves and %d internal nodes", leaves, internals)
+	}
+
+	// Mutating the callback slices must not affect the tree
+	if err := tree.validate(); err != nil {
+		t.Fatalf("tree changed by callback: %v", err)
+	}
+	if v, _ := tree.Search(5); v != 50 {
+		t.Errorf("expected value 50, got %d", v)
+	}
+}
+
+func TestFloorCeiling(t *testing.T) {
+	tree := New[int, string](3)
+	if _, ok := tree.Floor(5); ok {
+		t.Error("expected no floor in empty tree")
+	}
+	if _, ok := tree.Ceiling(5); ok {
+		t.Error("expected no ceiling in empty tree")
+	}
+
+	// Even keys 0..198 spread over many leaves
+	for i := 0; i < 200; i += 2 {
+		tree.Insert(i, fmt.Sprint(i))
+	}
+
+	for q := -3; q <= 202; q++ {
+		// -1 means no such entry
+		floor, ceil := q, q
+		switch {
+		case q < 0:
+			floor, ceil = -1, 0
+		case q > 198:
+			floor, ceil = 198, -1
+		case q%2 != 0:
+			floor, ceil = q-1, q+1
+		}
+
+		e, ok := tree.Floor(q)
+		if floor < 0 {
+			if ok {
+				t.Errorf("Floor(%d): expected none, got %d", q, e.Key)
+			}
+		} else if !ok || e.Key != floor || e.Value != fmt.Sprint(floor) {
+			t.Errorf("Floor(%d) = %v, %v; want %d", q, e, ok, floor)
+		}
+
+		e, ok = tree.Ceiling(q)
+		if ceil < 0 {
+			if ok {
+				t.Errorf("Ceiling(%d): expected none, got %d", q, e.Key)
+			}
+		} else if !ok || e.Key != ceil || e.Value != fmt.Sprint(ceil) {
+			t.Errorf("Ceiling(%d) = %v, %v; want %d", q, e, ok, ceil)
+		}
+	}
+}
+
+func TestSample(t *testing.T) {
+	tree := New[int, int](3)
+	rng := rand.New(rand.NewSource(1))
+	if got := tree.Sample(5, rng); got == nil || len(got) != 0 {
+		t.Errorf("expected empty non-nil sample from empty tree, got %v", got)
+	}
+
+	for i := 0; i < 20; i++ {
+		tree.Insert(i, i*i)
+	}
+	if got := tree.Sample(0, rng); len(got) != 0 {
+		t.Errorf("expected empty sample for n=0, got %v", got)
+	}
+	if got := tree.Sample(50, rng); !slices.Equal(got, tree.All()) {
+		t.Errorf("expected every entry when n exceeds Len, got %v", got)
+	}
+
+	if got := tree.Sample(5, nil); len(got) != 5 {
+		t.Errorf("expected 5 entries with the default source, got %v", got)
+	}
+
+	// Same seed, same sample
+	a := tree.Sample(5, rand.New(rand.NewSource(42)))
+	b := tree.Sample(5, rand.New(rand.NewSource(42)))
+	if !slices.Equal(a, b) {
+		t.Errorf("expected deterministic sample, got %v and %v", a, b)
+	}
+
+	// Each key should be picked about n/Len of the time
+	counts := make(map[int]int)
+	const trials = 20000
+	for i := 0; i < trials; i++ {
+		sample := tree.Sample(5, rng)
+		seen := make(map[int]bool)
+		for _, e := range sample {
+			if e.Value != e.Key*e.Key {
+				t.Fatalf("sample entry %v has wrong value", e)
+			}
+			if seen[e.Key] {
+				t.Fatalf("key %d sampled twice", e.Key)
+			}
+			seen[e.Key] = true
+			counts[e.Key]++
+		}
+	}
+	want := float64(trials) * 5 / 20
+	for k := 0; k < 20; k++ {
+		if math.Abs(float64(counts[k])-want) > want*0.1 {
+			t.Errorf("key %d sampled %d times, expected about %.0f", k, counts[k], want)
+		}
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	if _, err := NewChecked[int, int](1); !errors.Is(err, ErrInvalidDegree) {
+		t.Errorf("NewChecked(1): expected ErrInvalidDegree, got %v", err)
+	}
+	tree, err := NewChecked[int, int](3)
+	if err != nil || tree.Degree() != 3 {
+		t.Fatalf("NewChecked(3): got %v, %v", tree, err)
+	}
+
+	if _, err := tree.Min(); !errors.Is(err, ErrEmpty) {
+		t.Errorf("Min on empty tree: expected ErrEmpty, got %v", err)
+	}
+	if _, err := tree.Max(); !errors.Is(err, ErrEmpty) {
+		t.Errorf("Max on empty tree: expected ErrEmpty, got %v", err)
+	}
+
+	if err := tree.BulkLoadFill(nil, 1.5); !errors.Is(err, ErrInvalidFillFactor) {
+		t.Errorf("BulkLoadFill(1.5): expected ErrInvalidFillFactor, got %v", err)
+	}
+
+	tree.Insert(100, 100)
+	unsorted := []Entry[int, int]{{Key: 1, Value: 1}, {Key: 3, Value: 3}, {Key: 2, Value: 2}}
+	if err := tree.BulkLoadSorted(unsorted); !errors.Is(err, ErrUnsorted) {
+		t.Errorf("BulkLoadSorted(unsorted): expected ErrUnsorted, got %v", err)
+	}
+	duplicate := []Entry[int, int]{{Key: 1, Value: 1}, {Key: 1, Value: 2}}
+	if err := tree.BulkLoadSorted(duplicate); !errors.Is(err, ErrUnsorted) {
+		t.Errorf("BulkLoadSorted(duplicate): expected ErrUnsorted, got %v", err)
+	}
+	if tree.Len() != 1 {
+		t.Errorf("expected failed BulkLoadSorted to leave the tree unchanged, got %d entries", tree.Len())
+	}
+}
+
+func TestBulkLoadSorted(t *testing.T) {
+	tree := New[int, int](3)
+	var entries []Entry[int, int]
+	for i := 0; i < 500; i++ {
+		entries = append(entries, Entry[int, int]{Key: i * 2, Value: i})
+	}
+	if err := tree.BulkLoadSorted(entries); err != nil {
+		t.Fatalf("BulkLoadSorted: %v", err)
+	}
+	if err := tree.validate(); err != nil {
+		t.Fatalf("invalid tree: %v", err)
+	}
+	if !slices.Equal(tree.All(), entries) {
+		t.Error("tree contents differ from loaded entries")
+	}
+
+	minEntry, err := tree.Min()
+	if err != nil || minEntry.Key != 0 {
+		t.Errorf("Min: got %v, %v", minEntry, err)
+	}
+	maxEntry, err := tree.Max()
+	if err != nil || maxEntry.Key != 998 {
+		t.Errorf("Max: got %v, %v", maxEntry, err)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	tree := NewWithAggregate[int, int](3, 0,
+		func(a, b int) int { return a + b },
+		func(a, b int) int { return a - b })
+	for i := 0; i < 100; i++ {
+		tree.Insert(i, i)
+	}
+	tree.SetSoftDelete(true)
+	leaves := tree.countLeaves()
+
+	// Delete every even key
+	for i := 0; i < 100; i += 2 {
+		if !tree.Delete(i) {
+			t.Fatalf("Delete(%d) returned false", i)
+		}
+	}
+	if tree.Delete(0) {
+		t.Error("deleting a tombstone again should return false")
+	}
+	if tree.TombstoneCount() != 50 || tree.Len() != 50 {
+		t.Errorf("expected 50 tombstones and 50 live entries, got %d and %d", tree.TombstoneCount(), tree.Len())
+	}
+	if tree.countLeaves() != leaves {
+		t.Errorf("soft delete changed the tree shape: %d leaves, expected %d", tree.countLeaves(), leaves)
+	}
+	if err := tree.validate(); err != nil {
+		t.Fatalf("invalid tree: %v", err)
+	}
+
+	// Reads skip tombstones
+	if _, ok := tree.Search(10); ok {
+		t.Error("Search found a tombstoned key")
+	}
+	for _, e := range tree.All() {
+		if e.Key%2 == 0 {
+			t.Fatalf("All returned tombstoned key %d", e.Key)
+		}
+	}
+	if got := tree.Range(10, 15); len(got) != 3 || got[0].Key != 11 {
+		t.Errorf("Range(10, 15) = %v", got)
+	}
+	if got := tree.TopK(2); len(got) != 2 || got[0].Key != 99 || got[1].Key != 97 {
+		t.Errorf("TopK(2) = %v", got)
+	}
+	if e, ok := tree.Floor(10); !ok || e.Key != 9 {
+		t.Errorf("Floor(10) = %v, %v", e, ok)
+	}
+	if e, ok := tree.Ceiling(10); !ok || e.Key != 11 {
+		t.Errorf("Ceiling(10) = %v, %v", e, ok)
+	}
+	if e, err := tree.Min(); err != nil || e.Key != 1 {
+		t.Errorf("Min() = %v, %v", e, err)
+	}
+	if len(tree.Keys()) != 50 || len(tree.Values()) != 50 {
+		t.Errorf("Keys and Values should hold 50 live entries")
+	}
+	if tree.Aggregate() != 2500 {
+		t.Errorf("expected aggregate of odd keys 2500, got %d", tree.Aggregate())
+	}
+
+	// Reinserting a tombstoned key revives it
+	if !tree.InsertIfAbsent(10, 1000) {
+		t.Error("InsertIfAbsent on a tombstoned key should insert")
+	}
+	if v, ok := tree.Search(10); !ok || v != 1000 {
+		t.Errorf("Search(10) = %d, %v after revive", v, ok)
+	}
+	if tree.TombstoneCount() != 49 || tree.Len() != 51 {
+		t.Errorf("expected 49 tombstones and 51 live entries, got %d and %d", tree.TombstoneCount(), tree.Len())
+	}
+	// Entries handed out carry only the key and value
+	if e, ok := tree.Floor(10); !ok || e != (Entry[int, int]{10, 1000}) {
+		t.Errorf("Floor(10) = %v, %v, want an entry equal to {10 1000}", e, ok)
+	}
+
+	want := tree.All()
+	tree.Purge()
+	if tree.TombstoneCount() != 0 || tree.Len() != 51 {
+		t.Errorf("expected no tombstones and 51 entries after Purge, got %d and %d", tree.TombstoneCount(), tree.Len())
+	}
+	if !slices.Equal(tree.All(), want) {
+		t.Error("Purge changed the live entries")
+	}
+	if tree.countLeaves() >= leaves {
+		t.Errorf("expected Purge to shrink the tree, got %d leaves", tree.countLeaves())
+	}
+	if err := tree.validate(); err != nil {
+		t.Fatalf("invalid tree after Purge: %v", err)
+	}
+	if tree.Aggregate() != 3500 {
+		t.Errorf("expected aggregate 3500 after Purge, got %d", tree.Aggregate())
+	}
+}
+
+func TestSoftDeleteBatchAndModeSwitch(t *testing.T) {
+	tree := New[int, int](3)
+	for i := 0; i < 50; i++ {
+		tree.Insert(i, i)
+	}
+	var events []Op
+	tree.SetMutationHook(func(op Op, key, value int) { events = append(events, op) })
+	tree.SetSoftDelete(true)
+
+	if n := tree.DeleteBatch([]int{1, 2, 3, 3, 100}); n != 3 {
+		t.Errorf("DeleteBatch removed %d, expected 3", n)
+	}
+	if tree.TombstoneCount() != 3 || len(events) != 3 {
+		t.Errorf("expected 3 tombstones and 3 events, got %d and %d", tree.TombstoneCount(), len(events))
+	}
+
+	// With soft delete off, removing a tombstone does not count or notify
+	tree.SetSoftDelete(false)
+	if tree.Delete(1) {
+		t.Error("hard Delete of a tombstone should return false")
+	}
+	if n := tree.DeleteBatch([]int{2, 4}); n != 1 {
+		t.Errorf("DeleteBatch removed %d, expected 1", n)
+	}
+	if tree.TombstoneCount() != 1 || tree.Len() != 46 || len(events) != 4 {
+		t.Errorf("got %d tombstones, %d entries and %d events", tree.TombstoneCount(), tree.Len(), len(events))
+	}
+	if err := tree.validate(); err != nil {
+		t.Fatalf("invalid tree: %v", err)
+	}
+
+	// Purge and rebuilds report nothing further
+	tree.Purge()
+	if len(events) != 4 || tree.Len() != 46 {
+		t.Errorf("Purge reported %d events, %d entries", len(events)-4, tree.Len())
+	}
+
+	other := New[int, int](4)
+	for _, e := range tree.All() {
+		other.Insert(e.Key, e.Value)
+	}
+	tree.SetSoftDelete(true)
+	tree.Delete(10)
+	other.Delete(10)
+	if !Equal(tree, other) {
+		t.Error("Equal should ignore tombstones")
+	}
+
+	// Tombstones travel with their entries through splits and merges
+	for i := 100; i < 300; i++ {
+		tree.Insert(i, i)
+	}
+	tree.SetSoftDelete(false)
+	for i := 100; i < 300; i += 3 {
+		tree.Delete(i)
+	}
+	if err := tree.validate(); err != nil {
+		t.Fatalf("invalid tree after splits and merges: %v", err)
+	}
+	if _, ok := tree.Search(10); ok || tree.TombstoneCount() != 1 {
+		t.Errorf("expected key 10 to stay tombstoned, got %d tombstones", tree.TombstoneCount())
+	}
+}
+
+func TestHasAllHasAny(t *testing.T) {
+	tree := New[int, int](3)
+	if tree.HasAny([]int{1}) || tree.HasAll([]int{1}) {
+		t.Error("empty tree should hold no keys")
+	}
+	if !tree.HasAll(nil) || tree.HasAny(nil) {
+		t.Error("expected HasAll(nil) true and HasAny(nil) false")
+	}
+
+	for i := 0; i < 200; i += 2 {
+		tree.Insert(i, i)
+	}
+
+	tests := []struct {
+		keys     []int
+		all, any bool
+	}{
+		{[]int{198, 0, 100, 50, 50}, true, true},
+		{[]int{0, 2, 4, 5}, false, true},
+		{[]int{1, 3, 199, 500, -1}, false, false},
+		{[]int{-10, 198}, false, true},
+		{[]int{500, 0}, false, true},
+	}
+	for _, tc := range tests {
+		if got := tree.HasAll(tc.keys); got != tc.all {
+			t.Errorf("HasAll(%v) = %v, expected %v", tc.keys, got, tc.all)
+		}
+		if got := tree.HasAny(tc.keys); got != tc.any {
+			t.Errorf("HasAny(%v) = %v, expected %v", tc.keys, got, tc.any)
+		}
+	}
+
+	// Agrees with Search on random key sets
+	rng := rand.New(rand.NewSource(3))
+	for n := 0; n < 200; n++ {
+		keys := make([]int, rng.Intn(20)+1)
+		all, anyFound := true, false
+		for i := range keys {
+			keys[i] = rng.Intn(220) - 10
+			_, ok := tree.Search(keys[i])
+			all = all && ok
+			anyFound = anyFound || ok
+		}
+		if tree.HasAll(keys) != all || tree.HasAny(keys) != anyFound {
+			t.Fatalf("HasAll/HasAny disagree with Search for %v", keys)
+		}
+	}
+
+	// Tombstoned keys are absent
+	tree.SetSoftDelete(true)
+	tree.Delete(100)
+	if tree.HasAll([]int{98, 100}) || tree.HasAny([]int{100}) {
+		t.Error("tombstoned key reported present")
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	tree := New[int, string](3)
+	values, found := tree.GetMany([]int{3, 1})
+	if len(values) != 2 || found[0] || found[1] {
+		t.Errorf("empty tree: got %v, %v", values, found)
+	}
+
+	for i := 0; i < 100; i += 3 {
+		tree.Insert(i, fmt.Sprint(i))
+	}
+
+	keys := []int{99, 4, 0, 99, 50, 51, -1, 200, 3}
+	values, found = tree.GetMany(keys)
+	if len(values) != len(keys) || len(found) != len(keys) {
+		t.Fatalf("expected %d results, got %d and %d", len(keys), len(values), len(found))
+	}
+	for i, k := range keys {
+		want, ok := tree.Search(k)
+		if found[i] != ok || values[i] != want {
+			t.Errorf("position %d (key %d): got (%q, %v), expected (%q, %v)", i, k, values[i], found[i], want, ok)
+		}
+	}
+
+	if values, found := tree.GetMany(nil); len(values) != 0 || len(found) != 0 {
+		t.Error("expected empty results for no keys")
+	}
+}
+
+func TestInsertLeafHint(t *testing.T) {
+	tree := New[int, int](3)
+	rng := rand.New(rand.NewSource(11))
+	ref := map[int]int{}
+
+	// Ascending runs starting at random points, mixed with deletes that
+	// move separators away from the first keys of their leaves
+	for run := 0; run < 200; run++ {
+		start := rng.Intn(10000)
+		for k := start; k < start+rng.Intn(30); k++ {
+			tree.Insert(k, run)
+			ref[k] = run
+		}
+		for i := 0; i < 10; i++ {
+			k := rng.Intn(10000)
+			tree.Delete(k)
+			delete(ref, k)
+		}
+		if err := tree.validate(); err != nil {
+			t.Fatalf("invalid tree after run %d: %v", run, err)
+		}
+	}
+
+	if err := tree.CheckLeafChain(); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Len() != len(ref) {
+		t.Fatalf("expected %d entries, got %d", len(ref), tree.Len())
+	}
+	for k, v := range ref {
+		if got, ok := tree.Search(k); !ok || got != v {
+			t.Fatalf("Search(%d) = %d, %v; expected %d", k, got, ok, v)
+		}
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	tree := New[int, string](3)
+	if groups := GroupBy(tree, func(k int) int { return k / 10 }); len(groups) != 0 {
+		t.Errorf("expected no groups for empty tree, got %v", groups)
+	}
+
+	for i := 0; i < 95; i++ {
+		tree.Insert(i, fmt.Sprint(i))
+	}
+	tree.SetSoftDelete(true)
+	tree.Delete(15)
+
+	groups := GroupBy(tree, func(k int) int { return k / 10 })
+	if len(groups) != 10 {
+		t.Fatalf("expected 10 buckets, got %d", len(groups))
+	}
+	if len(groups[1]) != 9 || len(groups[9]) != 5 || len(groups[0]) != 10 {
+		t.Errorf("unexpected bucket sizes: %d, %d, %d", len(groups[0]), len(groups[1]), len(groups[9]))
+	}
+	for g, entries := range groups {
+		for i, e := range entries {
+			if e.Key/10 != g || e.Value != fmt.Sprint(e.Key) {
+				t.Errorf("entry %v in bucket %d", e, g)
+			}
+			if i > 0 && entries[i-1].Key >= e.Key {
+				t.Errorf("bucket %d not in key order", g)
+			}
+		}
+	}
+
+	parity := GroupBy(tree, func(k int) bool { return k%2 == 0 })
+	if len(parity[true]) != 48 || len(parity[false]) != 46 {
+		t.Errorf("expected 48 even and 46 odd keys, got %d and %d", len(parity[true]), len(parity[false]))
+	}
+}
+
+func TestInsertRejectsNaN(t *testing.T) {
+	tree := New[float64, string](3)
+	for i := range 20 {
+		tree.Insert(float64(i), fmt.Sprint(i))
+	}
+
+	if err := tree.InsertChecked(math.NaN(), "nan"); !errors.Is(err, ErrNaNKey) {
+		t.Fatalf("InsertChecked(NaN) = %v, want ErrNaNKey", err)
+	}
+	func() {
+		defer func() {
+			if r := recover(); r != ErrNaNKey {
+				t.Errorf("Insert(NaN) panicked with %v, want ErrNaNKey", r)
+			}
+		}()
+		tree.Insert(math.NaN(), "nan")
+	}()
+
+	if err := tree.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Len() != 20 {
+		t.Errorf("Len = %d, want 20", tree.Len())
+	}
+	if err := tree.InsertChecked(20, "20"); err != nil {
+		t.Errorf("InsertChecked(20) = %v", err)
+	}
+
+	// Every bulk load rejects NaN, leaving the tree as it was
+	entries := []Entry[float64, string]{{Key: 1, Value: "a"}, {Key: math.NaN(), Value: "nan"}, {Key: 3, Value: "c"}}
+	if err := tree.BulkLoadSorted(entries); !errors.Is(err, ErrNaNKey) {
+		t.Errorf("BulkLoadSorted with NaN = %v, want ErrNaNKey", err)
+	}
+	if err := tree.BulkLoadSorted(entries[1:2]); !errors.Is(err, ErrNaNKey) {
+		t.Errorf("BulkLoadSorted with a lone NaN = %v, want ErrNaNKey", err)
+	}
+	if err := tree.BulkLoadFill(entries, 0.5); !errors.Is(err, ErrNaNKey) {
+		t.Errorf("BulkLoadFill with NaN = %v, want ErrNaNKey", err)
+	}
+	func() {
+		defer func() {
+			if r, _ := recover().(error); !errors.Is(r, ErrNaNKey) {
+				t.Errorf("BulkLoad with NaN panicked with %v, want ErrNaNKey", r)
+			}
+		}()
+		tree.BulkLoad(entries)
+	}()
+	if tree.Len() != 21 {
+		t.Errorf("Len = %d after rejected bulk loads, want 21", tree.Len())
+	}
+}
+
+func TestEstimateRangeCount(t *testing.T) {
+	tree := New[int, int](4)
+	if got := tree.EstimateRangeCount(0, 10); got != 0 {
+		t.Errorf("empty tree estimate = %d, want 0", got)
+	}
+
+	ranges := [][2]int{{0, 9999}, {-50, 20000}, {2500, 7499}, {100, 1099}, {9000, 9999}}
+	check := func(name string, tolerance float64) {
+		for _, r := range ranges {
+			exact := tree.CountRange(r[0], r[1])
+			if want := len(tree.Range(r[0], r[1])); exact != want {
+				t.Errorf("%s: CountRange(%d, %d) = %d, want %d", name, r[0], r[1], exact, want)
+			}
+			got := tree.EstimateRangeCount(r[0], r[1])
+			if diff := math.Abs(float64(got - exact)); diff > tolerance*float64(exact) {
+				t.Errorf("%s: EstimateRangeCount(%d, %d) = %d, exact %d", name, r[0], r[1], got, exact)
+			}
+		}
+	}
+
+	// Packed nodes are close to the even spread the estimator assumes; only
+	// the partly filled right edge skews it
+	entries := make([]Entry[int, int], 10000)
+	for i := range entries {
+		entries[i] = Entry[int, int]{Key: i, Value: i}
+	}
+	tree.BulkLoad(entries)
+	check("bulk loaded", 0.3)
+
+	// Random inserts leave uneven fanout, so only a rough answer is expected
+	tree.Clear()
+	rng := rand.New(rand.NewSource(7))
+	for _, k := range rng.Perm(10000) {
+		tree.Insert(k, k)
+	}
+	check("random inserts", 0.5)
+
+	if got := tree.EstimateRangeCount(500, 100); got != 0 {
+		t.Errorf("inverted range estimate = %d, want 0", got)
+	}
+	if got := tree.CountRange(500, 100); got != 0 {
+		t.Errorf("inverted range count = %d, want 0", got)
+	}
+	if got := tree.EstimateRangeCount(20000, 30000); got != 0 {
+		t.Errorf("range past the last key estimate = %d, want 0", got)
+	}
+}
+
+func TestSearchPath(t *testing.T) {
+	tree := New[int, int](2)
+	if path := tree.SearchPath(1); path != nil {
+		t.Errorf("empty tree path = %v, want nil", path)
+	}
+
+	for i := range 100 {
+		tree.Insert(i, i)
+	}
+	for _, key := range []int{-5, 0, 37, 99, 500} {
+		path := tree.SearchPath(key)
+		if len(path) != tree.height() {
+			t.Fatalf("SearchPath(%d) has %d levels, want %d", key, len(path), tree.height())
+		}
+
+		// Every separator level must bracket key the way the descent did
+		n := tree.root
+		for depth, keys := range path[:len(path)-1] {
+			if !slices.Equal(keys, n.keys) {
+				t.Fatalf("SearchPath(%d) level %d = %v, want %v", key, depth, keys, n.keys)
+			}
+			i := 0
+			for i < len(n.keys) && key >= n.keys[i] {
+				i++
+			}
+			n = n.children[i]
+		}
+		leafKeys := path[len(path)-1]
+		if key >= 0 && key < 100 && !slices.Contains(leafKeys, key) {
+			t.Errorf("SearchPath(%d) leaf %v does not hold key", key, leafKeys)
+		}
+	}
+
+	path := tree.SearchPath(50)
+	path[0][0] = -1
+	if tree.root.keys[0] == -1 {
+		t.Error("SearchPath returned a slice aliasing the tree")
+	}
+}
+
+func TestSet(t *testing.T) {
+	s := NewSet[int](3)
+	for _, k := range []int{5, 1, 9, 3, 7} {
+		if !s.Add(k) {
+			t.Errorf("Add(%d) = false on first insert", k)
+		}
+	}
+	if s.Add(5) {
+		t.Error("Add(5) = true for a present key")
+	}
+	if !s.Contains(3) || s.Contains(4) {
+		t.Error("Contains reports wrong membership")
+	}
+	if !s.Remove(3) || s.Remove(3) {
+		t.Error("Remove(3) should succeed once")
+	}
+	if got := s.Sorted(); !slices.Equal(got, []int{1, 5, 7, 9}) {
+		t.Errorf("Sorted = %v", got)
+	}
+	if got := s.Range(2, 8); !slices.Equal(got, []int{5, 7}) {
+		t.Errorf("Range(2, 8) = %v", got)
+	}
+	if s.Len() != 4 {
+		t.Errorf("Len = %d, want 4", s.Len())
+	}
+
+	a, b := NewSet[int](3), NewSet[int](4)
+	for i := range 200 {
+		if i%2 == 0 {
+			a.Add(i)
+		}
+		if i%3 == 0 {
+			b.Add(i)
+		}
+	}
+
+	var union, inter, diff []int
+	for i := range 200 {
+		inA, inB := i%2 == 0, i%3 == 0
+		if inA || inB {
+			union = append(union, i)
+		}
+		if inA && inB {
+			inter = append(inter, i)
+		}
+		if inA && !inB {
+			diff = append(diff, i)
+		}
+	}
+	for name, c := range map[string]struct {
+		got  *Set[int]
+		want []int
+	}{
+		"Union":     {a.Union(b), union},
+		"Intersect": {a.Intersect(b), inter},
+		"Diff":      {a.Diff(b), diff},
+	} {
+		if got := c.got.Sorted(); !slices.Equal(got, c.want) {
+			t.Errorf("%s = %v, want %v", name, got, c.want)
+		}
+		if err := c.got.tree.validate(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	if got := a.Intersect(NewSet[int](3)); got.Len() != 0 {
+		t.Errorf("Intersect with empty set has %d keys", got.Len())
+	}
+	if a.Len() != 100 || b.Len() != 67 {
+		t.Errorf("set operations modified their inputs: %d, %d", a.Len(), b.Len())
+	}
+}
+
+func TestInsertWithMerge(t *testing.T) {
+	add := func(a, b int) int { return a + b }
+	sub := func(a, b int) int { return a - b }
+	tree := NewWithAggregate[string, int](3, 0, add, sub)
+
+	words := strings.Fields("the quick fox and the lazy dog and the cat")
+	for _, w := range words {
+		tree.InsertWithMerge(w, 1, add)
+	}
+
+	want := map[string]int{"the": 3, "and": 2, "quick": 1, "fox": 1, "lazy": 1, "dog": 1, "cat": 1}
+	if tree.Len() != len(want) {
+		t.Errorf("Len = %d, want %d", tree.Len(), len(want))
+	}
+	for w, n := range want {
+		if got, _ := tree.Search(w); got != n {
+			t.Errorf("count of %q = %d, want %d", w, got, n)
+		}
+	}
+	if tree.Aggregate() != len(words) {
+		t.Errorf("Aggregate = %d, want %d", tree.Aggregate(), len(words))
+	}
+
+	var merged []string
+	tree.SetMutationHook(func(op Op, key string, value int) {
+		merged = append(merged, fmt.Sprintf("%v %s=%d", op, key, value))
+	})
+	tree.InsertWithMerge("the", 10, func(old, new int) int { return old * new })
+	tree.InsertWithMerge("owl", 10, func(old, new int) int { return old * new })
+	wantHook := []string{fmt.Sprintf("%v the=30", OpUpdate), fmt.Sprintf("%v owl=10", OpInsert)}
+	if !slices.Equal(merged, wantHook) {
+		t.Errorf("hook saw %v, want %v", merged, wantHook)
+	}
+	if err := tree.validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChunks(t *testing.T) {
+	tree := New[int, int](3)
+	for range tree.Chunks(4) {
+		t.Fatal("Chunks yielded from an empty tree")
+	}
+
+	for i := range 23 {
+		tree.Insert(i, i*i)
+	}
+	tree.SetSoftDelete(true)
+	tree.Delete(5)
+
+	var lens []int
+	var got []Entry[int, int]
+	for chunk := range tree.Chunks(5) {
+		lens = append(lens, len(chunk))
+		got = append(got, chunk...)
+	}
+	if !slices.Equal(lens, []int{5, 5, 5, 5, 2}) {
+		t.Errorf("chunk lengths = %v, want [5 5 5 5 2]", lens)
+	}
+	if !slices.Equal(got, tree.All()) {
+		t.Errorf("chunks concatenate to %v, want %v", got, tree.All())
+	}
+
+	count := 0
+	for range tree.Chunks(0) {
+		count++
+		if count == 3 {
+			break
+		}
+	}
+	if count != 3 {
+		t.Errorf("stopping early after %d chunks", count)
+	}
+}
+
+func TestShrinkToFit(t *testing.T) {
+	tree := New[int, int](8)
+	tree.ShrinkToFit()
+	for i := range 5000 {
+		tree.Insert(i, i)
+	}
+	for i := range 5000 {
+		if i%50 != 0 {
+			tree.Delete(i)
+		}
+	}
+	want := tree.All()
+	height := tree.height()
+
+	tree.ShrinkToFit()
+	spare := 0
+	var visit func(n *node[int, int])
+	visit = func(n *node[int, int]) {
+		spare += cap(n.keys) - len(n.keys) + cap(n.entries) - len(n.entries) + cap(n.children) - len(n.children)
+		for _, child := range n.children {
+			visit(child)
+		}
+	}
+	visit(tree.root)
+	if spare != 0 {
+		t.Errorf("spare capacity after ShrinkToFit = %d, want 0", spare)
+	}
+	if err := tree.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(tree.All(), want) || tree.height() != height {
+		t.Error("ShrinkToFit changed the contents or shape of the tree")
+	}
+
+	for i := 5000; i < 5100; i++ {
+		tree.Insert(i, i)
+	}
+	if err := tree.validate(); err != nil {
+		t.Fatalf("after inserts: %v", err)
+	}
+}
+
+func TestBoxed(t *testing.T) {
+	type blob struct {
+		id   int
+		data [256]byte
+	}
+	tree := NewBoxed[int, blob](3)
+	for i := range 500 {
+		b := blob{id: i}
+		b.data[0] = byte(i)
+		tree.Insert(i, b)
+	}
+	for i := 0; i < 500; i += 3 {
+		tree.Delete(i)
+	}
+
+	if err := tree.Tree().validate(); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 500 {
+		got, ok := tree.Search(i)
+		if ok != (i%3 != 0) {
+			t.Fatalf("Search(%d) found = %v", i, ok)
+		}
+		if ok && (got.id != i || got.data[0] != byte(i)) {
+			t.Errorf("Search(%d) = blob %d", i, got.id)
+		}
+	}
+	if tree.Len() != 333 {
+		t.Errorf("Len = %d, want 333", tree.Len())
+	}
+
+	// Search copies; Ref aliases the stored value
+	got, _ := tree.Search(1)
+	got.id = -1
+	ref, _ := tree.Ref(1)
+	if ref.id != 1 {
+		t.Error("modifying a Search result changed the tree")
+	}
+	ref.id = 100
+	if got, _ := tree.Search(1); got.id != 100 {
+		t.Error("write through Ref not visible to Search")
+	}
+	if _, ok := tree.Ref(3); ok {
+		t.Error("Ref found a deleted key")
+	}
+}
+
+func TestAppendAll(t *testing.T) {
+	tree := New[int, int](3)
+	if got := tree.AppendAll(nil); len(got) != 0 {
+		t.Errorf("AppendAll on empty tree = %v", got)
+	}
+	for i := range 100 {
+		tree.Insert(i, i)
+	}
+	tree.SetSoftDelete(true)
+	tree.Delete(50)
+
+	prefix := []Entry[int, int]{{Key: -1, Value: -1}}
+	got := tree.AppendAll(prefix)
+	if len(got) != 100 || got[0] != prefix[0] {
+		t.Fatalf("AppendAll returned %d entries, want prefix plus 99", len(got))
+	}
+	if !slices.Equal(got[1:], tree.All()) {
+		t.Error("AppendAll differs from All")
+	}
+
+	buf := make([]Entry[int, int], 0, 100)
+	allocs := testing.AllocsPerRun(10, func() {
+		buf = tree.AppendAll(buf[:0])
+	})
+	if allocs != 0 {
+		t.Errorf("AppendAll into a large enough buffer allocated %v times", allocs)
+	}
+}
+
+// latchedEntries walks a quiescent latched tree, checking that every leaf is
+// at the same depth and that keys respect the separators above them
+func latchedEntries[K cmp.Ordered, V any](t *testing.T, tree *LatchedBPlusTree[K, V]) []Entry[K, V] {
+	var result []Entry[K, V]
+	leafDepth := -1
+	var walk func(n *latchedNode[K, V], depth int, lo, hi *K)
+	walk = func(n *latchedNode[K, V], depth int, lo, hi *K) {
+		if n.isLeaf {
+			if leafDepth == -1 {
+				leafDepth = depth
+			} else if depth != leafDepth {
+				t.Fatalf("leaf at depth %d, others at %d", depth, leafDepth)
+			}
+			for _, e := range n.entries {
+				if (lo != nil && e.Key < *lo) || (hi != nil && e.Key >= *hi) {
+					t.Fatalf("key %v outside its separators", e.Key)
+				}
+			}
+			result = append(result, n.entries...)
+			return
+		}
+		if len(n.children) != len(n.keys)+1 {
+			t.Fatalf("internal node with %d keys has %d children", len(n.keys), len(n.children))
+		}
+		for i, child := range n.children {
+			childLo, childHi := lo, hi
+			if i > 0 {
+				childLo = &n.keys[i-1]
+			}
+			if i < len(n.keys) {
+				childHi = &n.keys[i]
+			}
+			walk(child, depth+1, childLo, childHi)
+		}
+	}
+	walk(tree.root, 0, nil, nil)
+	return result
+}
+
+func TestLatchedConcurrentInsertSearch(t *testing.T) {
+	const (
+		writers   = 8
+		perWriter = 2000
+	)
+	tree := NewLatched[int, int](3)
+
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(w)))
+			for _, i := range rng.Perm(perWriter) {
+				key := i*writers + w
+				tree.Insert(key, key)
+				if v, ok := tree.Search(key); !ok || v != key {
+					t.Errorf("Search(%d) right after insert = %d, %v", key, v, ok)
+					return
+				}
+			}
+		}()
+	}
+	for r := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(100 + r)))
+			for range 5000 {
+				key := rng.Intn(writers * perWriter)
+				if v, ok := tree.Search(key); ok && v != key {
+					t.Errorf("Search(%d) = %d", key, v)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Updates of existing keys never change the count
+	for key := range 100 {
+		tree.Insert(key, -key)
+	}
+
+	if tree.Len() != writers*perWriter {
+		t.Fatalf("Len = %d, want %d", tree.Len(), writers*perWriter)
+	}
+	entries := latchedEntries(t, tree)
+	if len(entries) != writers*perWriter {
+		t.Fatalf("tree holds %d entries, want %d", len(entries), writers*perWriter)
+	}
+	for i, e := range entries {
+		want := i
+		if i < 100 {
+			want = -i
+		}
+		if e.Key != i || e.Value != want {
+			t.Fatalf("entry %d = %v", i, e)
+		}
+	}
+}
+
+func TestUpdateEach(t *testing.T) {
+	add := func(a, b int) int { return a + b }
+	sub := func(a, b int) int { return a - b }
+	tree := NewWithAggregate[int, int](3, 0, add, sub)
+	for i := range 50 {
+		tree.Insert(i, i)
+	}
+	tree.SetSoftDelete(true)
+	tree.Delete(10)
+
+	var visited []int
+	updates := 0
+	tree.SetMutationHook(func(op Op, key, value int) {
+		if op != OpUpdate || value != key*100 {
+			t.Errorf("hook saw %v %d=%d", op, key, value)
+		}
+		updates++
+	})
+	tree.UpdateEach(func(k, v int) (int, bool) {
+		visited = append(visited, k)
+		if k%2 == 0 {
+			return v * 100, true
+		}
+		return -1, false
+	})
+
+	if !slices.Equal(visited, tree.Keys()) {
+		t.Errorf("visited %v, want live keys in order", visited)
+	}
+	if updates != 24 {
+		t.Errorf("hook saw %d updates, want 24", updates)
+	}
+	want := 0
+	for _, e := range tree.All() {
+		expected := e.Key
+		if e.Key%2 == 0 {
+			expected *= 100
+		}
+		if e.Value != expected {
+			t.Errorf("value of %d = %d, want %d", e.Key, e.Value, expected)
+		}
+		want += expected
+	}
+	if tree.Aggregate() != want {
+		t.Errorf("Aggregate = %d, want %d", tree.Aggregate(), want)
+	}
+	if err := tree.validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	tree := New[int, string](3)
+	tree.Insert(3, "three")
+	tree.Insert(1, "one, first")
+	tree.Insert(2, `say "two"`)
+	tree.SetSoftDelete(true)
+	tree.Insert(4, "four")
+	tree.Delete(4)
+
+	var buf bytes.Buffer
+	if err := tree.WriteCSV(&buf, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := "1,\"one, first\"\n2,\"say \"\"two\"\"\"\n3,three\n"
+	if buf.String() != want {
+		t.Errorf("WriteCSV wrote %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	hex := func(k int) string { return fmt.Sprintf("%#x", k) }
+	upper := strings.ToUpper
+	if err := tree.WriteTSV(&buf, hex, upper); err != nil {
+		t.Fatal(err)
+	}
+	want = "0x1\tONE, FIRST\n0x2\t\"SAY \"\"TWO\"\"\"\n0x3\tTHREE\n"
+	if buf.String() != want {
+		t.Errorf("WriteTSV wrote %q, want %q", buf.String(), want)
+	}
+
+	if err := tree.WriteCSV(failingWriter{}, nil, nil); err == nil {
+		t.Error("WriteCSV ignored a write error")
+	}
+}
+
+func TestRangeKeys(t *testing.T) {
+	tree := New[int, [64]byte](3)
+	if got := tree.RangeKeys(0, 10); got == nil || len(got) != 0 {
+		t.Errorf("RangeKeys on empty tree = %v, want empty non-nil", got)
+	}
+	for i := 0; i < 100; i += 2 {
+		tree.Insert(i, [64]byte{byte(i)})
+	}
+	tree.SetSoftDelete(true)
+	tree.Delete(10)
+
+	for _, r := range [][2]int{{5, 25}, {-10, 3}, {90, 200}, {0, 98}, {7, 7}, {30, 20}} {
+		var want []int
+		for _, e := range tree.Range(r[0], r[1]) {
+			want = append(want, e.Key)
+		}
+		got := tree.RangeKeys(r[0], r[1])
+		if got == nil || !slices.Equal(got, want) {
+			t.Errorf("RangeKeys(%d, %d) = %v, want %v", r[0], r[1], got, want)
+		}
+	}
+}
+
+func TestInsertTracked(t *testing.T) {
+	tree := New[int, int](2)
+	grew := 0
+	for i := range 500 {
+		before := tree.height()
+		g := tree.InsertTracked(i, i)
+		if g != (tree.height() > before) {
+			t.Fatalf("InsertTracked(%d) = %v, height went %d -> %d", i, g, before, tree.height())
+		}
+		if g {
+			grew++
+		}
+	}
+	if grew != tree.height() {
+		t.Errorf("tree grew %d times but has height %d", grew, tree.height())
+	}
+	if tree.InsertTracked(5, -5) {
+		t.Error("updating an existing key reported growth")
+	}
+}
+
+func TestRangeExclusive(t *testing.T) {
+	tree := New[string, int](3)
+	if got := tree.RangeExclusive("a", "z", false, false); got == nil || len(got) != 0 {
+		t.Errorf("RangeExclusive on empty tree = %v", got)
+	}
+	for i, k := range []string{"a", "ab", "b", "ba", "c", "d"} {
+		tree.Insert(k, i)
+	}
+
+	keys := func(entries []Entry[string, int]) []string {
+		var result []string
+		for _, e := range entries {
+			result = append(result, e.Key)
+		}
+		return result
+	}
+	tests := []struct {
+		start, end     string
+		inStart, inEnd bool
+		want           []string
+	}{
+		{"ab", "c", true, true, []string{"ab", "b", "ba", "c"}},
+		{"ab", "c", false, true, []string{"b", "ba", "c"}},
+		{"ab", "c", true, false, []string{"ab", "b", "ba"}},
+		{"ab", "c", false, false, []string{"b", "ba"}},
+		{"b", "b", true, true, []string{"b"}},
+		{"b", "b", false, true, nil},
+		{"a", "d", false, false, []string{"ab", "b", "ba", "c"}},
+	}
+	for _, tt := range tests {
+		got := tree.RangeExclusive(tt.start, tt.end, tt.inStart, tt.inEnd)
+		if !slices.Equal(keys(got), tt.want) {
+			t.Errorf("RangeExclusive(%q, %q, %v, %v) = %v, want %v",
+				tt.start, tt.end, tt.inStart, tt.inEnd, keys(got), tt.want)
+		}
+	}
+
+	// Paging by resuming strictly after the last key
+	var paged []string
+	last, first := "", true
+	for {
+		page := tree.RangeExclusive(last, "~", first, true)
+		if len(page) > 2 {
+			page = page[:2]
+		}
+		if len(page) == 0 {
+			break
+		}
+		paged = append(paged, keys(page)...)
+		last, first = page[len(page)-1].Key, false
+	}
+	if !slices.Equal(paged, tree.Keys()) {
+		t.Errorf("paging gave %v, want %v", paged, tree.Keys())
+	}
+}
+
+func TestDefragment(t *testing.T) {
+	tree := New[int, int](4)
+	if tree.Defragment() != 0 {
+		t.Error("Defragment of an empty tree merged leaves")
+	}
+
+	// Sequential inserts leave half-full leaves; deletes thin them further
+	for i := range 2000 {
+		tree.Insert(i, i)
+	}
+	rng := rand.New(rand.NewSource(11))
+	for _, k := range rng.Perm(2000)[:600] {
+		tree.Delete(k)
+	}
+	want := tree.All()
+	before := tree.countLeaves()
+	crossParent := 0
+	for leaf := tree.firstLeaf(); leaf.next != nil; leaf = leaf.next {
+		if leaf.parent != leaf.next.parent && 2*len(leaf.entries) < tree.leafCap && 2*len(leaf.next.entries) < tree.leafCap {
+			crossParent++
+		}
+	}
+	if crossParent == 0 {
+		t.Fatal("expected small neighbors under different parents to exercise")
+	}
+
+	merges := tree.Defragment()
+	if merges == 0 {
+		t.Fatal("expected some leaves to merge")
+	}
+	if got := tree.countLeaves(); got != before-merges {
+		t.Errorf("leaf count went %d -> %d after %d merges", before, got, merges)
+	}
+	if err := tree.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.CheckLeafChain(); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(tree.All(), want) {
+		t.Error("Defragment changed the contents")
+	}
+
+	// No neighboring pair is left both under half full, whatever their parents
+	for leaf := tree.firstLeaf(); leaf.next != nil; leaf = leaf.next {
+		if 2*len(leaf.entries) < tree.leafCap && 2*len(leaf.next.entries) < tree.leafCap {
+			t.Fatalf("unmerged pair of small neighbors with %d and %d entries", len(leaf.entries), len(leaf.next.entries))
+		}
+	}
+	if tree.Defragment() != 0 {
+		t.Error("second Defragment found more merges")
+	}
+
+	for i := 2000; i < 2100; i++ {
+		tree.Insert(i, i)
+	}
+	if err := tree.validate(); err != nil {
+		t.Fatalf("after inserts: %v", err)
+	}
+
+	// Merges across parents keep every degree valid, down to the root
+	for degree := 2; degree <= 6; degree++ {
+		for seed := range int64(20) {
+			tree := New[int, int](degree)
+			rng := rand.New(rand.NewSource(seed))
+			n := 50 + rng.Intn(500)
+			for i := range n {
+				tree.Insert(i, i)
+			}
+			for _, k := range rng.Perm(n)[:rng.Intn(n)] {
+				tree.Delete(k)
+			}
+			want := tree.All()
+			tree.Defragment()
+			if err := tree.validate(); err != nil {
+				t.Fatalf("degree %d, seed %d: %v", degree, seed, err)
+			}
+			if err := tree.CheckLeafChain(); err != nil {
+				t.Fatalf("degree %d, seed %d: %v", degree, seed, err)
+			}
+			if !slices.Equal(tree.All(), want) {
+				t.Fatalf("degree %d, seed %d: Defragment changed the contents", degree, seed)
+			}
+			for _, e := range want {
+				if v, ok := tree.Search(e.Key); !ok || v != e.Value {
+					t.Fatalf("degree %d, seed %d: Search(%d) = %d, %v", degree, seed, e.Key, v, ok)
+				}
+			}
+		}
+	}
+}
+
+func TestComposite(t *testing.T) {
+	type key struct {
+		tenant uint32
+		ts     int64
+	}
+	encode := func(k key) []byte {
+		b := binary.BigEndian.AppendUint32(nil, k.tenant)
+		// Flipping the sign bit makes negative timestamps sort first
+		return binary.BigEndian.AppendUint64(b, uint64(k.ts)^(1<<63))
+	}
+	tree := NewComposite[key, string](3, encode)
+
+	var want []key
+	for tenant := uint32(1); tenant <= 3; tenant++ {
+		for ts := int64(-5); ts <= 5; ts++ {
+			want = append(want, key{tenant, ts})
+		}
+	}
+	rng := rand.New(rand.NewSource(12))
+	for _, i := range rng.Perm(len(want)) {
+		tree.Insert(want[i], fmt.Sprint(want[i]))
+	}
+
+	var got []key
+	for _, e := range tree.All() {
+		got = append(got, e.Key)
+		if e.Value != fmt.Sprint(e.Key) {
+			t.Errorf("value of %v = %q", e.Key, e.Value)
+		}
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("All ordered keys as %v, want %v", got, want)
+	}
+
+	page := tree.Range(key{2, -1}, key{2, 1})
+	if len(page) != 3 || page[0].Key != (key{2, -1}) || page[2].Key != (key{2, 1}) {
+		t.Errorf("Range within tenant 2 = %v", page)
+	}
+	if v, ok := tree.Search(key{3, -5}); !ok || v != fmt.Sprint(key{3, -5}) {
+		t.Errorf("Search = %q, %v", v, ok)
+	}
+	if !tree.Delete(key{3, -5}) || tree.Delete(key{3, -5}) {
+		t.Error("Delete should succeed exactly once")
+	}
+	if tree.Len() != len(want)-1 {
+		t.Errorf("Len = %d, want %d", tree.Len(), len(want)-1)
+	}
+}
+
+func TestFillRatio(t *testing.T) {
+	tree := New[int, int](4)
+	if r := tree.FillRatio(); r != 0 {
+		t.Errorf("empty tree FillRatio = %v, want 0", r)
+	}
+
+	entries := make([]Entry[int, int], 1000)
+	for i := range entries {
+		entries[i] = Entry[int, int]{Key: i, Value: i}
+	}
+	if err := tree.BulkLoadSorted(entries); err != nil {
+		t.Fatal(err)
+	}
+	if r := tree.FillRatio(); r < 0.95 || r > 1 {
+		t.Errorf("bulk-loaded FillRatio = %v, want near 1", r)
+	}
+
+	for i := range 1000 {
+		if i%4 != 0 {
+			tree.Delete(i)
+		}
+	}
+	if r := tree.FillRatio(); r < 0.4 || r > 0.9 {
+		t.Errorf("FillRatio after heavy deletes = %v, want near 0.5", r)
+	}
+}
+
+func TestScansAllocateOnce(t *testing.T) {
+	tree := New[int, int](4)
+	for i := range 1000 {
+		tree.Insert(i, i)
+	}
+	tree.SetSoftDelete(true)
+	tree.Delete(7)
+
+	scans := map[string]func(){
+		"All":    func() { tree.All() },
+		"Keys":   func() { tree.Keys() },
+		"Values": func() { tree.Values() },
+	}
+	for name, scan := range scans {
+		if allocs := testing.AllocsPerRun(10, scan); allocs != 1 {
+			t.Errorf("%s allocated %v times, want 1", name, allocs)
+		}
+	}
+}
+
+func TestLRU(t *testing.T) {
+	cache := NewLRU[int, string](3, 3)
+	for i := 1; i <= 3; i++ {
+		if _, ok := cache.Insert(i, fmt.Sprint(i)); ok {
+			t.Fatalf("Insert(%d) evicted below capacity", i)
+		}
+	}
+
+	// 1 becomes the most recently used, leaving 2 as the oldest
+	if v, ok := cache.Search(1); !ok || v != "1" {
+		t.Fatalf("Search(1) = %q, %v", v, ok)
+	}
+	// Range and Contains must not refresh 2
+	cache.Range(0, 10)
+	cache.Contains(2)
+
+	evicted, ok := cache.Insert(4, "4")
+	if !ok || evicted.Key != 2 || evicted.Value != "2" {
+		t.Fatalf("Insert(4) evicted %v, %v; want key 2", evicted, ok)
+	}
+	if cache.Contains(2) {
+		t.Error("evicted key still in the cache")
+	}
+
+	// Updating an existing key refreshes it without evicting
+	if _, ok := cache.Insert(3, "three"); ok {
+		t.Error("updating a cached key evicted an entry")
+	}
+	evicted, _ = cache.Insert(5, "5")
+	if evicted.Key != 1 {
+		t.Errorf("Insert(5) evicted key %d, want 1", evicted.Key)
+	}
+
+	want := []Entry[int, string]{{Key: 3, Value: "three"}, {Key: 4, Value: "4"}, {Key: 5, Value: "5"}}
+	if got := cache.Range(0, 10); !slices.Equal(got, want) {
+		t.Errorf("Range = %v, want %v", got, want)
+	}
+
+	if !cache.Delete(4) || cache.Delete(4) {
+		t.Error("Delete should succeed exactly once")
+	}
+	if cache.Len() != 2 || cache.tree.Len() != 2 {
+		t.Errorf("Len = %d, tree holds %d; want 2", cache.Len(), cache.tree.Len())
+	}
+	if _, ok := cache.Insert(6, "6"); ok {
+		t.Error("Insert after Delete evicted below capacity")
+	}
+}
+
+func TestSplitRatio(t *testing.T) {
+	for _, ratio := range []float64{0, 1, -0.5, 1.5, math.NaN(), 0.1, 0.4, 0.6, 0.9} {
+		if _, err := NewWithSplitRatio[int, int](8, ratio); !errors.Is(err, ErrInvalidSplitRatio) {
+			t.Errorf("ratio %v: expected ErrInvalidSplitRatio, got %v", ratio, err)
+		}
+	}
+	// Degree 8 leaves split 16 entries and internal nodes 15 keys besides the
+	// promoted one, with at least 7 on each side
+	for _, ratio := range []float64{0.44, 0.5, 0.56} {
+		tree, err := NewWithSplitRatio[int, int](8, ratio)
+		if err != nil {
+			t.Fatalf("ratio %v: %v", ratio, err)
+		}
+		if mid := tree.splitPoint(16, tree.minLeafEntries()); mid != int(math.Round(ratio*16)) {
+			t.Errorf("ratio %v: leaf split moved to %d of 16", ratio, mid)
+		}
+		rng := rand.New(rand.NewSource(int64(ratio * 100)))
+		for _, k := range rng.Perm(3000) {
+			tree.Insert(k, k)
+		}
+		for _, k := range rng.Perm(3000)[:2000] {
+			tree.Delete(k)
+		}
+		if err := tree.validate(); err != nil {
+			t.Fatalf("ratio %v: %v", ratio, err)
+		}
+	}
+
+	// Sequential inserts leave leaves of 9 rather than 8 entries
+	even := New[int, int](8)
+	skewed, err := NewWithSplitRatio[int, int](8, 0.56)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5000 {
+		even.Insert(i, i)
+		skewed.Insert(i, i)
+	}
+	if err := skewed.validate(); err != nil {
+		t.Fatalf("invalid tree after sequential inserts: %v", err)
+	}
+	if skewed.countLeaves() >= even.countLeaves() {
+		t.Errorf("sequential inserts made %d leaves with a skewed split, %d with an even one",
+			skewed.countLeaves(), even.countLeaves())
+	}
+	if skewed.FillRatio() <= even.FillRatio() {
+		t.Errorf("FillRatio even = %.2f, skewed = %.2f; want skewed fuller",
+			even.FillRatio(), skewed.FillRatio())
+	}
+	if skewed.minLeafEntries() != even.minLeafEntries() || skewed.minInternalKeys() != even.minInternalKeys() {
+		t.Error("split ratio changed the minimum occupancy")
+	}
+
+	rng := rand.New(rand.NewSource(3))
+	for _, k := range rng.Perm(5000)[:4000] {
+		skewed.Delete(k)
+		skewed.Insert(k+5000, k)
+	}
+	if err := skewed.validate(); err != nil {
+		t.Fatalf("invalid tree after random updates: %v", err)
+	}
+	if skewed.Len() != 5000 {
+		t.Errorf("Len = %d, want 5000", skewed.Len())
+	}
+}
+
+func TestContainsContiguous(t *testing.T) {
+	tree := New[int8, struct{}](2)
+	if !ContainsContiguous(tree, 5, 4) {
+		t.Error("empty interval should be covered")
+	}
+	if ContainsContiguous(tree, 0, 0) {
+		t.Error("empty tree covers nothing")
+	}
+
+	for k := int8(100); k < 127; k++ {
+		tree.Insert(k, struct{}{})
+	}
+	tree.Insert(127, struct{}{})
+	tree.Insert(-128, struct{}{})
+
+	tests := []struct {
+		start, end int8
+		want       bool
+	}{
+		{100, 127, true},
+		{110, 120, true},
+		{127, 127, true},
+		{99, 110, false},
+		{-128, -128, true},
+		{-128, -127, false},
+	}
+	for _, tt := range tests {
+		if got := ContainsContiguous(tree, tt.start, tt.end); got != tt.want {
+			t.Errorf("ContainsContiguous(%d, %d) = %v, want %v", tt.start, tt.end, got, tt.want)
+		}
+	}
+
+	tree.SetSoftDelete(true)
+	tree.Delete(115)
+	if ContainsContiguous(tree, 110, 120) {
+		t.Error("tombstoned key should leave a gap")
+	}
+	if !ContainsContiguous(tree, 116, 127) {
+		t.Error("interval past the tombstone should still be covered")
+	}
+}
+
+func TestSmallestDegreeDeleteToEmpty(t *testing.T) {
+	// Degrees below 2 are clamped, so all of these run the smallest real
+	// configuration: leaves of 1 to 3 entries, internal nodes of 1 to 3 keys
+	for _, degree := range []int{0, 1, 2} {
+		tree := New[int, int](degree)
+		if tree.maxLeafEntries() != 3 || tree.minLeafEntries() != 1 ||
+			tree.maxInternalKeys() != 3 || tree.minInternalKeys() != 1 {
+			t.Fatalf("degree %d: leaf bounds [%d, %d], internal bounds [%d, %d]; want [1, 3] for both",
+				degree, tree.minLeafEntries(), tree.maxLeafEntries(), tree.minInternalKeys(), tree.maxInternalKeys())
+		}
+
+		for seed := int64(0); seed < 5; seed++ {
+			rng := rand.New(rand.NewSource(seed))
+			keys := rng.Perm(200)
+			for _, k := range keys {
+				tree.Insert(k, k)
+				if err := tree.validate(); err != nil {
+					t.Fatalf("degree %d, seed %d: after Insert(%d): %v", degree, seed, k, err)
+				}
+			}
+
+			rng.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+			for i, k := range keys {
+				if !tree.Delete(k) {
+					t.Fatalf("degree %d, seed %d: Delete(%d) found nothing", degree, seed, k)
+				}
+				if err := tree.validate(); err != nil {
+					t.Fatalf("degree %d, seed %d: after Delete(%d): %v", degree, seed, k, err)
+				}
+				if err := tree.CheckLeafChain(); err != nil {
+					t.Fatalf("degree %d, seed %d: after Delete(%d): %v", degree, seed, k, err)
+				}
+				if tree.Len() != len(keys)-i-1 {
+					t.Fatalf("degree %d, seed %d: Len() = %d, want %d", degree, seed, tree.Len(), len(keys)-i-1)
+				}
+			}
+			if tree.Len() != 0 || len(tree.All()) != 0 {
+				t.Fatalf("degree %d, seed %d: tree not empty after deleting every key", degree, seed)
+			}
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tree := New[int, string](3)
+	reference := map[int]string{}
+	for i := range 100 {
+		tree.Insert(i, fmt.Sprint(i))
+		reference[i] = fmt.Sprint(i)
+	}
+	missing, extra, mismatched := Diff(tree, reference)
+	if len(missing)+len(extra)+len(mismatched) != 0 {
+		t.Fatalf("identical contents: Diff = %v, %v, %v", missing, extra, mismatched)
+	}
+
+	reference[150] = "150"
+	reference[120] = "120"
+	tree.Insert(200, "200")
+	reference[7] = "seven"
+	reference[3] = "three"
+	tree.SetSoftDelete(true)
+	tree.Delete(50)
+
+	missing, extra, mismatched = Diff(tree, reference)
+	if !slices.Equal(missing, []int{50, 120, 150}) {
+		t.Errorf("missing = %v, want [50 120 150]", missing)
+	}
+	if !slices.Equal(extra, []int{200}) {
+		t.Errorf("extra = %v, want [200]", extra)
+	}
+	if !slices.Equal(mismatched, []int{3, 7}) {
+		t.Errorf("mismatched = %v, want [3 7]", mismatched)
+	}
+}
+
+func TestDuplicatePolicy(t *testing.T) {
+	overwrite := NewWithDuplicatePolicy[int, string](3, DuplicateOverwrite)
+	ignore := NewWithDuplicatePolicy[int, string](3, DuplicateIgnore)
+	reject := NewWithDuplicatePolicy[int, string](3, DuplicateError)
+	for i := range 50 {
+		overwrite.Insert(i, "old")
+		ignore.Insert(i, "old")
+		reject.Insert(i, "old")
+	}
+
+	overwrite.Insert(7, "new")
+	if v, _ := overwrite.Search(7); v != "new" {
+		t.Errorf("DuplicateOverwrite: value = %q, want new", v)
+	}
+
+	ignore.Insert(7, "new")
+	if err := ignore.InsertChecked(8, "new"); err != nil {
+		t.Errorf("DuplicateIgnore: InsertChecked returned %v", err)
+	}
+	for _, k := range []int{7, 8} {
+		if v, _ := ignore.Search(k); v != "old" {
+			t.Errorf("DuplicateIgnore: value of %d = %q, want old", k, v)
+		}
+	}
+
+	if err := reject.InsertChecked(7, "new"); !errors.Is(err, ErrDuplicateKey) {
+		t.Errorf("DuplicateError: InsertChecked returned %v, want ErrDuplicateKey", err)
+	}
+	func() {
+		defer func() {
+			if err, _ := recover().(error); !errors.Is(err, ErrDuplicateKey) {
+				t.Errorf("DuplicateError: Insert panicked with %v, want ErrDuplicateKey", err)
+			}
+		}()
+		reject.Insert(7, "new")
+	}()
+	if v, _ := reject.Search(7); v != "old" {
+		t.Errorf("DuplicateError: value = %q, want old", v)
+	}
+	if err := reject.InsertChecked(100, "new"); err != nil {
+		t.Errorf("DuplicateError: InsertChecked of a new key returned %v", err)
+	}
+	if reject.Len() != 51 {
+		t.Errorf("DuplicateError: Len = %d, want 51", reject.Len())
+	}
+
+	// The explicit variants keep their own behavior
+	if !reject.InsertIfAbsent(200, "x") || reject.InsertIfAbsent(200, "y") {
+		t.Error("InsertIfAbsent should ignore the policy")
+	}
+	reject.InsertWithMerge(200, "z", func(old, new string) string { return old + new })
+	if v, _ := reject.Search(200); v != "xz" {
+		t.Errorf("InsertWithMerge under DuplicateError: value = %q, want xz", v)
+	}
+}
+
+func TestMaxHeight(t *testing.T) {
+	unbounded := NewWithMaxHeight[int, int](2, 0)
+	for i := range 1000 {
+		if err := unbounded.TryInsert(i, i); err != nil {
+			t.Fatalf("unbounded tree: TryInsert(%d) = %v", i, err)
+		}
+	}
+
+	tree := NewWithMaxHeight[int, int](2, 2)
+	rng := rand.New(rand.NewSource(8))
+	inserted, rejected := 0, 0
+	for _, k := range rng.Perm(200) {
+		before := tree.Len()
+		err := tree.TryInsert(k, k)
+		switch {
+		case err == nil:
+			inserted++
+		case errors.Is(err, ErrHeightLimit):
+			rejected++
+			if tree.Len() != before {
+				t.Fatalf("rejected insert of %d changed Len", k)
+			}
+		default:
+			t.Fatalf("TryInsert(%d) = %v", k, err)
+		}
+		if h := tree.height(); h > 2 {
+			t.Fatalf("height %d exceeds the limit", h)
+		}
+	}
+	if err := tree.validate(); err != nil {
+		t.Fatal(err)
+	}
+	// Degree 2 fits at most 4 leaves of 3 entries under one root
+	if inserted > 12 || rejected == 0 || tree.Len() != inserted {
+		t.Fatalf("inserted %d, rejected %d, Len %d", inserted, rejected, tree.Len())
+	}
+
+	// Fill every leaf so that any new key would split the root
+	for k := 0; k < 1000 && tree.Len() < 12; k++ {
+		tree.TryInsert(k, k)
+	}
+	existing := tree.Keys()[0]
+	if err := tree.TryInsert(existing, -1); err != nil {
+		t.Errorf("updating an existing key failed: %v", err)
+	}
+	if v, _ := tree.Search(existing); v != -1 {
+		t.Errorf("update not applied, value = %d", v)
+	}
+	if err := tree.InsertChecked(5000, 0); !errors.Is(err, ErrHeightLimit) {
+		t.Errorf("InsertChecked on a full tree = %v, want ErrHeightLimit", err)
+	}
+	defer func() {
+		if err, _ := recover().(error); !errors.Is(err, ErrHeightLimit) {
+			t.Errorf("Insert on a full tree panicked with %v, want ErrHeightLimit", err)
+		}
+	}()
+	tree.Insert(5000, 0)
+}
+
+func TestConcat(t *testing.T) {
+	sizes := []int{0, 1, 2, 3, 7, 20, 100, 1000}
+	for _, degree := range []int{2, 3, 8} {
+		for _, na := range sizes {
+			for _, nb := range sizes {
+				a := NewWithAggregate[int, int](degree, 0, func(x, y int) int { return x + y }, func(x, y int) int { return x - y })
+				b := New[int, int](degree)
+				var want []Entry[int, int]
+				for i := range na + nb {
+					want = append(want, Entry[int, int]{Key: i, Value: i})
+				}
+				// Full bulk-loaded nodes force splits along the seam,
+				// half-full inserted ones merges
+				if degree == 3 {
+					a.BulkLoadSorted(want[:na])
+					b.BulkLoadSorted(want[na:])
+				} else {
+					for _, e := range want[:na] {
+						a.Insert(e.Key, e.Value)
+					}
+					for _, e := range want[na:] {
+						b.Insert(e.Key, e.Value)
+					}
+				}
+
+				got, err := Concat(a, b)
+				if err != nil || got != a {
+					t.Fatalf("degree %d, %d+%d: Concat = %p, %v", degree, na, nb, got, err)
+				}
+				if err := a.validate(); err != nil {
+					t.Fatalf("degree %d, %d+%d: invalid tree: %v", degree, na, nb, err)
+				}
+				if err := a.CheckLeafChain(); err != nil {
+					t.Fatalf("degree %d, %d+%d: %v", degree, na, nb, err)
+				}
+				if !slices.Equal(a.All(), want) || a.Len() != na+nb || b.Len() != 0 {
+					t.Fatalf("degree %d, %d+%d: wrong contents, Len %d, b.Len %d", degree, na, nb, a.Len(), b.Len())
+				}
+				if sum := (na + nb) * (na + nb - 1) / 2; a.Aggregate() != sum {
+					t.Fatalf("degree %d, %d+%d: Aggregate = %d, want %d", degree, na, nb, a.Aggregate(), sum)
+				}
+
+				// The joined tree keeps working
+				for i := 0; i < na+nb; i += 3 {
+					a.Delete(i)
+				}
+				a.Insert(-1, 0)
+				if err := a.validate(); err != nil {
+					t.Fatalf("degree %d, %d+%d: invalid tree after updates: %v", degree, na, nb, err)
+				}
+			}
+		}
+	}
+}
+
+func TestConcatCallbacks(t *testing.T) {
+	a, b := New[int, int](3), New[int, int](3)
+	for i := 0; i < 20; i++ {
+		a.Insert(i, i)
+		b.Insert(i+100, i)
+	}
+	var aCalls, bCalls []int
+	a.SetMutationHook(func(_ Op, k, _ int) { aCalls = append(aCalls, k) })
+	a.SetEvictionCallback(func(k, _ int) { aCalls = append(aCalls, k) })
+	b.SetMutationHook(func(_ Op, k, _ int) { bCalls = append(bCalls, k) })
+	b.SetEvictionCallback(func(k, _ int) { bCalls = append(bCalls, k) })
+
+	if _, err := Concat(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if len(aCalls) != 0 || len(bCalls) != 0 {
+		t.Fatalf("Concat fired callbacks: a %v, b %v", aCalls, bCalls)
+	}
+
+	// Moved entries now report to a's hook and eviction callback only
+	a.Delete(105)
+	if !slices.Equal(aCalls, []int{105, 105}) || len(bCalls) != 0 {
+		t.Errorf("Deleting a moved entry called a with %v and b with %v", aCalls, bCalls)
+	}
+	b.Insert(7, 7)
+	if !slices.Equal(bCalls, []int{7}) {
+		t.Errorf("b's hook should stay on b, got %v", bCalls)
+	}
+}
+
+func TestConcatErrors(t *testing.T) {
+	a, b := New[int, int](3), New[int, int](3)
+	for i := range 10 {
+		a.Insert(i, i)
+		b.Insert(i+9, i)
+	}
+	if _, err := Concat(a, b); !errors.Is(err, ErrNotDisjoint) {
+		t.Errorf("overlapping trees: got %v, want ErrNotDisjoint", err)
+	}
+	if _, err := Concat(a, New[int, int](4)); !errors.Is(err, ErrIncompatible) {
+		t.Errorf("different degrees: got %v, want ErrIncompatible", err)
+	}
+	if a.Len() != 10 || b.Len() != 10 || a.CheckLeafChain() != nil || b.CheckLeafChain() != nil {
+		t.Error("failed Concat changed its arguments")
+	}
+
+	// A tombstoned key still counts against disjointness
+	b.SetSoftDelete(true)
+	b.Delete(9)
+	if _, err := Concat(a, b); !errors.Is(err, ErrNotDisjoint) {
+		t.Errorf("overlap on a tombstone: got %v, want ErrNotDisjoint", err)
+	}
+}
+
+func TestRangeFrom(t *testing.T) {
+	tree := New[int, int](3)
+	if page, next, more := tree.RangeFrom(0, 5); len(page) != 0 || next != 0 || more {
+		t.Errorf("empty tree: RangeFrom = %v, %d, %v", page, next, more)
+	}
+	for i := 1; i <= 25; i++ {
+		tree.Insert(i*10, i)
+	}
+
+	var got []int
+	cursor, more := 0, true
+	for pages := 0; more; pages++ {
+		if pages > 10 {
+			t.Fatal("pagination does not terminate")
+		}
+		var page []Entry[int, int]
+		page, cursor, more = tree.RangeFrom(cursor, 10)
+		for _, e := range page {
+			got = append(got, e.Key)
+		}
+		// Inserting before the cursor must not shift the next page
+		tree.Insert(cursor-5, 0)
+	}
+	want := tree.RangeKeys(10, 250)
+	want = slices.DeleteFunc(want, func(k int) bool { return k%10 != 0 })
+	if !slices.Equal(got, want) {
+		t.Errorf("paged keys = %v, want %v", got, want)
+	}
+	if cursor != 250 {
+		t.Errorf("final cursor = %d, want 250", cursor)
+	}
+
+	// A page ending exactly on the last key reports no more
+	// Paging inserted 245 just before the final cursor
+	if page, next, more := tree.RangeFrom(240, 2); len(page) != 2 || next != 250 || more {
+		t.Errorf("RangeFrom(240, 2) = %v, %d, %v", page, next, more)
+	}
+	if page, next, more := tree.RangeFrom(250, 5); len(page) != 0 || next != 250 || more {
+		t.Errorf("RangeFrom past the end = %v, %d, %v", page, next, more)
+	}
+	for _, limit := range []int{0, -1} {
+		if page, next, more := tree.RangeFrom(0, limit); len(page) != 0 || next != 0 || more {
+			t.Errorf("RangeFrom with limit %d = %v, %d, %v", limit, page, next, more)
+		}
+	}
+
+	tree.SetSoftDelete(true)
+	tree.Delete(250)
+	if page, next, more := tree.RangeFrom(240, 2); len(page) != 1 || next != 245 || more {
+		t.Errorf("RangeFrom over a tombstone = %v, %d, %v", page, next, more)
+	}
+}
+
+func TestEvictionCallback(t *testing.T) {
+	tree := New[int, string](2)
+	evicted := map[int][]string{}
+	tree.SetEvictionCallback(func(k int, v string) {
+		evicted[k] = append(evicted[k], v)
+	})
+	expect := func(step string, want map[int][]string) {
+		t.Helper()
+		if !maps.EqualFunc(evicted, want, slices.Equal[[]string]) {
+			t.Errorf("%s: evicted %v, want %v", step, evicted, want)
+		}
+		clear(evicted)
+	}
+
+	for i := range 10 {
+		tree.Insert(i, fmt.Sprint("a", i))
+	}
+	expect("fresh inserts", map[int][]string{})
+
+	tree.Insert(3, "b3")
+	tree.InsertWithMerge(4, "!", func(old, new string) string { return old + new })
+	tree.InsertIfAbsent(5, "ignored")
+	expect("overwrites", map[int][]string{3: {"a3"}, 4: {"a4"}})
+
+	tree.Delete(3)
+	tree.Delete(3)
+	tree.DeleteBatch([]int{0, 1, 100})
+	expect("deletes", map[int][]string{3: {"b3"}, 0: {"a0"}, 1: {"a1"}})
+
+	tree.UpdateEach(func(k int, v string) (string, bool) { return "c", k == 2 })
+	tree.Retain(func(k int, _ string) bool { return k != 9 })
+	expect("UpdateEach and Retain", map[int][]string{2: {"a2"}, 9: {"a9"}})
+
+	tree.SetSoftDelete(true)
+	tree.Delete(6)
+	tree.Delete(6)
+	tree.SetSoftDelete(false)
+	tree.Delete(6)
+	tree.Purge()
+	expect("soft delete", map[int][]string{6: {"a6"}})
+
+	tree.BulkLoad([]Entry[int, string]{{Key: 7, Value: "d7"}})
+	expect("BulkLoad", map[int][]string{2: {"c"}, 4: {"a4!"}, 5: {"a5"}, 7: {"a7"}, 8: {"a8"}})
+
+	tree.Clear()
+	expect("Clear", map[int][]string{7: {"d7"}})
+
+	tree.SetEvictionCallback(nil)
+	tree.Insert(1, "x")
+	tree.Delete(1)
+}
+
+// === Benchmarks ===
+
+func BenchmarkInsertSequential(b *testing.B) {
+	for _, degree := range []int{3, 10, 50} {
+		b.Run(fmt.Sprintf("degree=%d", degree), func(b *testing.B) {
+			tree := New[int, int](degree)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tree.Insert(i, i)
+			}
+		})
+	}
+}
+
+// BenchmarkInsertSequentialNoHint drops the last-insert leaf hint before
+// every insert, for comparison with BenchmarkInsertSequential
+func BenchmarkInsertSequentialNoHint(b *testing.B) {
+	for _, degree := range []int{3, 10, 50} {
+		b.Run(fmt.Sprintf("degree=%d", degree), func(b *testing.B) {
+			tree := New[int, int](degree)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tree.hint = nil
+				tree.Insert(i, i)
+			}
+		})
+	}
+}
+
+func BenchmarkInsertRandom(b *testing.B) {
+	keys := make([]int, b.N)
+	for i := range keys {
+		keys[i] = rand.Int()
+	}
+
+	b.ResetTimer()
+	tree := New[int, int](10)
+	for i := 0; i < b.N; i++ {
+		tree.Insert(keys[i], i)
+	}
+}
+
+func BenchmarkSearch(b *testing.B) {
+	tree := New[int, int](10)
+	n := 100000
+
+	for i := 0; i < n; i++ {
+		tree.Insert(i, i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.Search(i % n)
+	}
+}
+
+func BenchmarkSearchRandom(b *testing.B) {
+	tree := New[int, int](10)
+	n := 100000
+
+	for i := 0; i < n; i++ {
+		tree.Insert(i, i)
+	}
+
+	keys := make([]int, b.N)
+	for i := range keys {
+		keys[i] = rand.Intn(n)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.Search(keys[i])
+	}
+}
+
+func BenchmarkDelete(b *testing.B) {
+	for _, degree := range []int{3, 10, 50} {
+		b.Run(fmt.Sprintf("degree=%d", degree), func(b *testing.B) {
+			tree := New[int, int](degree)
+			for i := 0; i < b.N; i++ {
+				tree.Insert(i, i)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tree.Delete(i)
+			}
+		})
+	}
+}
+
+func BenchmarkRange(b *testing.B) {
+	tree := New[int, int](10)
+	n := 100000
+
+	for i := 0; i < n; i++ {
+		tree.Insert(i, i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := i % (n - 100)
+		tree.Range(start, start+100)
+	}
+}
+
+func BenchmarkMixedOps(b *testing.B) {
+	tree := New[int, int](10)
+
+	for i := 0; i < 10000; i++ {
+		tree.Insert(i, i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		op := i % 10
+		key := i % 10000
+
+		switch {
+		case op < 5:
+			tree.Search(key)
+		case op < 8:
+			tree.Insert(key+10000, i)
+		default:
+			tree.Delete(key + 10000)
+		}
+	}
+}
+
+func BenchmarkAll(b *testing.B) {
+	tree := New[int, int](32)
+	n := 1000000
+
+	entries := make([]Entry[int, int], n)
+	for i := range entries {
+		entries[i] = Entry[int, int]{Key: i, Value: i}
+	}
+	if err := tree.BulkLoadSorted(entries); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.All()
+	}
 }
@@ -0,0 +1,299 @@
+package bplustree
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// BulkLoad replaces the contents of the tree with entries, packing every node
+// as full as possible. It is equivalent to BulkLoadFill(entries, 1), except
+// that like Insert it panics on a NaN key.
+func (t *BPlusTree[K, V]) BulkLoad(entries []Entry[K, V]) {
+	if err := t.BulkLoadFill(entries, 1); err != nil {
+		panic(err)
+	}
+}
+
+// BulkLoadFill replaces the contents of the tree with entries, building it
+// bottom-up instead of inserting one entry at a time. Leaves and internal
+// nodes are packed to roughly fillFactor of their capacity, leaving headroom
+// for later inserts, but never below the minimum occupancy. Entries need not
+// be sorted; when a key repeats, the last entry wins, as with Insert. A NaN
+// key yields an error wrapping ErrNaNKey and leaves the tree unchanged.
+func (t *BPlusTree[K, V]) BulkLoadFill(entries []Entry[K, V], fillFactor float64) error {
+	if !(fillFactor > 0 && fillFactor <= 1) {
+		return ErrInvalidFillFactor
+	}
+	if err := checkOrdered(entries); err != nil {
+		return err
+	}
+
+	sorted := slices.Clone(entries)
+	slices.SortStableFunc(sorted, func(a, b Entry[K, V]) int {
+		return cmp.Compare(a.Key, b.Key)
+	})
+	n := 0
+	for i := range sorted {
+		if n > 0 && sorted[n-1].Key == sorted[i].Key {
+			sorted[n-1] = sorted[i]
+		} else {
+			sorted[n] = sorted[i]
+			n++
+		}
+	}
+	t.replace(sorted[:n], fillFactor)
+	return nil
+}
+
+// BulkLoadSorted replaces the contents of the tree with entries, which must
+// already be in strictly increasing key order. It skips the sort done by
+// BulkLoad and returns an error wrapping ErrUnsorted, leaving the tree
+// unchanged, if the order is violated, or one wrapping ErrNaNKey for a NaN
+// key.
+func (t *BPlusTree[K, V]) BulkLoadSorted(entries []Entry[K, V]) error {
+	if err := checkOrdered(entries); err != nil {
+		return err
+	}
+	for i := 1; i < len(entries); i++ {
+		if !(entries[i].Key > entries[i-1].Key) {
+			return fmt.Errorf("%w: key %v at index %d follows %v", ErrUnsorted, entries[i].Key, i, entries[i-1].Key)
+		}
+	}
+
+	t.replace(entries, 1)
+	return nil
+}
+
+// checkOrdered returns an error wrapping ErrNaNKey if any key is NaN
+func checkOrdered[K cmp.Ordered, V any](entries []Entry[K, V]) error {
+	for i, e := range entries {
+		if isNaN(e.Key) {
+			return fmt.Errorf("%w: at index %d", ErrNaNKey, i)
+		}
+	}
+	return nil
+}
+
+// replace builds the tree from sorted and reports the swap to the mutation
+// hook as a delete of every old entry followed by an insert of every new one
+func (t *BPlusTree[K, V]) replace(sorted []Entry[K, V], fillFactor float64) {
+	var old []Entry[K, V]
+	if t.watching() {
+		old = t.All()
+	}
+	t.build(sorted, fillFactor)
+	for _, e := range old {
+		t.reportRemoval(e.Key, e.Value)
+	}
+	if t.hook != nil {
+		for _, e := range sorted {
+			t.hook(OpInsert, e.Key, e.Value)
+		}
+	}
+}
+
+// Retain rebuilds the tree keeping only the entries for which pred returns
+// true. Survivors are collected in one pass over the leaf chain and then bulk
+// loaded, so the result is fully packed.
+func (t *BPlusTree[K, V]) Retain(pred func(K, V) bool) {
+	var kept, dropped []Entry[K, V]
+	for leaf := t.firstLeaf(); leaf != nil; leaf = leaf.next {
+		for _, e := range leaf.entries {
+			if e.deleted {
+				continue
+			}
+			if pred(e.Key, e.Value) {
+				kept = append(kept, e.Entry)
+			} else if t.watching() {
+				dropped = append(dropped, e.Entry)
+			}
+		}
+	}
+	t.build(kept, 1)
+	for _, e := range dropped {
+		t.reportRemoval(e.Key, e.Value)
+	}
+}
+
+// Compact rebuilds the tree with every node fully packed, reclaiming the
+// space left behind by deletes. The contents are unchanged, so no mutation
+// hook events are reported.
+func (t *BPlusTree[K, V]) Compact() {
+	t.build(t.All(), 1)
+}
+
+// CompactFill is like Compact but packs nodes to fillFactor of their
+// capacity, leaving headroom for later inserts
+func (t *BPlusTree[K, V]) CompactFill(fillFactor float64) error {
+	if !(fillFactor > 0 && fillFactor <= 1) {
+		return ErrInvalidFillFactor
+	}
+	t.build(t.All(), fillFactor)
+	return nil
+}
+
+// Defragment merges neighboring leaves that are both less than half full,
+// walking the leaf chain once, and returns the number of merges. Leaves
+// under different parents merge too, the separator above them moving up to
+// the next key. Parents lose a key per merge and are rebalanced as after a
+// delete. Unlike Compact it leaves every other node in place, so it is cheap
+// enough to run incrementally.
+func (t *BPlusTree[K, V]) Defragment() int {
+	t.hint = nil
+	merges := 0
+	leaf := t.firstLeaf()
+	for leaf != nil && leaf.next != nil {
+		next := leaf.next
+		if 2*len(leaf.entries) >= t.leafCap || 2*len(next.entries) >= t.leafCap {
+			leaf = next
+			continue
+		}
+
+		leaf.entries = append(leaf.entries, next.entries...)
+		leaf.next = next.next
+		if next.next != nil {
+			next.next.prev = leaf
+		}
+		if leaf.parent == next.parent {
+			t.deleteFromParent(leaf.parent, slices.Index(leaf.parent.children, leaf), next)
+		} else {
+			t.deleteFirstChild(next.parent)
+		}
+		merges++
+		// Stay on leaf: it may still be small enough to absorb its new next
+	}
+	return merges
+}
+
+// deleteFirstChild removes the first child of parent, whose entries have
+// moved to the preceding subtree, with parent's first key. That key becomes
+// the separator for parent's subtree in the nearest ancestor where it is not
+// the first child.
+func (t *BPlusTree[K, V]) deleteFirstChild(parent *node[K, V]) {
+	sep := parent.keys[0]
+	for n := parent; n.parent != nil; n = n.parent {
+		if i := slices.Index(n.parent.children, n); i > 0 {
+			n.parent.keys[i-1] = sep
+			break
+		}
+	}
+	parent.keys = slices.Delete(parent.keys, 0, 1)
+	parent.children = slices.Delete(parent.children, 0, 1)
+
+	if parent == t.root && len(parent.keys) == 0 {
+		t.root = parent.children[0]
+		t.root.parent = nil
+		return
+	}
+	if parent.parent != nil && len(parent.keys) < t.minInternalKeys() {
+		t.rebalanceInternal(parent)
+	}
+}
+
+// ShrinkToFit reallocates the slices of every node to their exact length,
+// releasing capacity left over from deletes. Unlike Compact it keeps the
+// node structure as is, so it frees less but never moves entries between
+// nodes.
+func (t *BPlusTree[K, V]) ShrinkToFit() {
+	if t.root != nil {
+		shrinkNode(t.root)
+	}
+}
+
+func shrinkNode[K cmp.Ordered, V any](n *node[K, V]) {
+	n.keys = shrink(n.keys)
+	n.entries = shrink(n.entries)
+	n.children = shrink(n.children)
+	for _, child := range n.children {
+		shrinkNode(child)
+	}
+}
+
+// shrink returns s copied into a backing array of exactly its length
+func shrink[T any](s []T) []T {
+	if cap(s) == len(s) {
+		return s
+	}
+	return append(make([]T, 0, len(s)), s...)
+}
+
+// build replaces the contents of the tree with sorted, which must be in
+// strictly increasing key order
+func (t *BPlusTree[K, V]) build(sorted []Entry[K, V], fillFactor float64) {
+	t.root = nil
+	t.size = len(sorted)
+	t.tombstones = 0
+	t.hint = nil
+	if t.aggregate != nil {
+		t.aggregate.total = t.aggregate.zero
+		for _, e := range sorted {
+			t.aggregate.total = t.aggregate.add(t.aggregate.total, e.Value)
+		}
+	}
+	if len(sorted) == 0 {
+		return
+	}
+
+	var level []*node[K, V]
+	var minKeys []K
+	var prev *node[K, V]
+	start := 0
+	for _, size := range t.packSizes(len(sorted), t.minLeafEntries(), t.maxLeafEntries(), fillFactor) {
+		leaf := &node[K, V]{isLeaf: true, entries: make([]leafEntry[K, V], size)}
+		for i, e := range sorted[start : start+size] {
+			leaf.entries[i].Entry = e
+		}
+		if prev != nil {
+			prev.next = leaf
+			leaf.prev = prev
+		}
+		prev = leaf
+		level = append(level, leaf)
+		minKeys = append(minKeys, leaf.entries[0].Key)
+		start += size
+	}
+
+	for len(level) > 1 {
+		var parents []*node[K, V]
+		var parentMinKeys []K
+		start := 0
+		for _, size := range t.packSizes(len(level), t.minInternalKeys()+1, t.maxInternalKeys()+1, fillFactor) {
+			parent := &node[K, V]{
+				keys:     slices.Clone(minKeys[start+1 : start+size]),
+				children: slices.Clone(level[start : start+size]),
+			}
+			for _, child := range parent.children {
+				child.parent = parent
+			}
+			parents = append(parents, parent)
+			parentMinKeys = append(parentMinKeys, minKeys[start])
+			start += size
+		}
+		level, minKeys = parents, parentMinKeys
+	}
+
+	t.root = level[0]
+}
+
+// packSizes splits n items into groups of about fillFactor*maxSize items
+// each, keeping every group within [minSize, maxSize]. A single group may
+// hold fewer than minSize items since it becomes the root.
+func (t *BPlusTree[K, V]) packSizes(n, minSize, maxSize int, fillFactor float64) []int {
+	target := max(int(math.Ceil(fillFactor*float64(maxSize))), minSize, 1)
+
+	groups := (n + target - 1) / target
+	if groups > 1 && n/groups < minSize {
+		groups = max(n/minSize, 1)
+	}
+
+	sizes := make([]int, groups)
+	for i := range sizes {
+		sizes[i] = n / groups
+		if i < n%groups {
+			sizes[i]++
+		}
+	}
+	return sizes
+}
@@ -0,0 +1,69 @@
+package bplustree
+
+// CompositeBPlusTree is a B+ tree over keys of any type, such as structs of
+// several fields, ordered by the bytes encode produces for them. The encoding
+// must be order-preserving: for keys a < b, encode(a) must compare below
+// encode(b) byte by byte, and equal keys must encode identically. Big-endian
+// fixed-width integers (with the sign bit flipped for signed ones) and
+// strings followed by a terminator that sorts below their contents are the
+// usual building blocks; concatenating the fields in order of significance
+// then gives multi-field ordering.
+type CompositeBPlusTree[K, V any] struct {
+	tree   *BPlusTree[string, CompositeEntry[K, V]]
+	encode func(K) []byte
+}
+
+// CompositeEntry is a key-value pair stored in a CompositeBPlusTree
+type CompositeEntry[K, V any] struct {
+	Key   K
+	Value V
+}
+
+// NewComposite creates an empty composite-key tree of the given degree that
+// orders keys by encode
+func NewComposite[K, V any](degree int, encode func(K) []byte) *CompositeBPlusTree[K, V] {
+	return &CompositeBPlusTree[K, V]{
+		tree:   New[string, CompositeEntry[K, V]](degree),
+		encode: encode,
+	}
+}
+
+// Insert adds an entry or replaces the value of an existing key
+func (t *CompositeBPlusTree[K, V]) Insert(key K, value V) {
+	t.tree.Insert(string(t.encode(key)), CompositeEntry[K, V]{Key: key, Value: value})
+}
+
+// Search returns the value stored under key
+func (t *CompositeBPlusTree[K, V]) Search(key K) (V, bool) {
+	e, ok := t.tree.Search(string(t.encode(key)))
+	return e.Value, ok
+}
+
+// Delete removes key and reports whether it was present
+func (t *CompositeBPlusTree[K, V]) Delete(key K) bool {
+	return t.tree.Delete(string(t.encode(key)))
+}
+
+// Range returns the entries with keys in [start, end] in key order
+func (t *CompositeBPlusTree[K, V]) Range(start, end K) []CompositeEntry[K, V] {
+	return t.values(t.tree.Range(string(t.encode(start)), string(t.encode(end))))
+}
+
+// All returns every entry in key order
+func (t *CompositeBPlusTree[K, V]) All() []CompositeEntry[K, V] {
+	return t.values(t.tree.All())
+}
+
+// Len returns the number of entries
+func (t *CompositeBPlusTree[K, V]) Len() int {
+	return t.tree.Len()
+}
+
+// values strips the encoded keys from entries
+func (t *CompositeBPlusTree[K, V]) values(entries []Entry[string, CompositeEntry[K, V]]) []CompositeEntry[K, V] {
+	result := make([]CompositeEntry[K, V], len(entries))
+	for i, e := range entries {
+		result[i] = e.Value
+	}
+	return result
+}
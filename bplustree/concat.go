@@ -0,0 +1,154 @@
+package bplustree
+
+import (
+	"cmp"
+	"fmt"
+)
+
+// Concat moves every entry of b into a, where all keys of a are below all
+// keys of b, as with time-partitioned segments, and returns a. Rather than
+// reinserting, it splices the shorter tree in as the last or first subtree at
+// the matching level of the taller one and links the two leaf chains, so
+// only the nodes along the seam are touched: O(log n). The exception is a
+// running aggregate kept by a but not by b: b's values are then summed by
+// walking all of its leaves, which is O(n) in the size of b. b is left
+// empty.
+//
+// Both trees must use the same node capacities and split ratio, otherwise
+// an error wrapping ErrIncompatible is returned; if a's last key is not below
+// b's first, tombstones included, an error wrapping ErrNotDisjoint is
+// returned. Either way neither tree is changed. The result keeps a's
+// settings, mutation hook and eviction callback, and neither callback is
+// called for the moved entries. b's hook and callback do not follow its
+// entries; they stay on b. A height limit is not enforced.
+func Concat[K cmp.Ordered, V any](a, b *BPlusTree[K, V]) (*BPlusTree[K, V], error) {
+	if a.leafCap != b.leafCap || a.internalCap != b.internalCap || a.splitRatio != b.splitRatio {
+		return nil, fmt.Errorf("%w: node capacities or split ratios differ", ErrIncompatible)
+	}
+	if b.root == nil || a == b {
+		return a, nil
+	}
+
+	var bTotal V
+	if a.aggregate != nil {
+		if b.aggregate != nil {
+			bTotal = b.aggregate.total
+		} else {
+			bTotal = a.aggregate.zero
+			for leaf := b.firstLeaf(); leaf != nil; leaf = leaf.next {
+				for _, e := range leaf.entries {
+					if !e.deleted {
+						bTotal = a.aggregate.add(bTotal, e.Value)
+					}
+				}
+			}
+		}
+	}
+
+	if a.root != nil {
+		last, first := a.lastLeaf(), b.firstLeaf()
+		lastKey, firstKey := last.entries[len(last.entries)-1].Key, first.entries[0].Key
+		if !(lastKey < firstKey) {
+			return nil, fmt.Errorf("%w: %v is not below %v", ErrNotDisjoint, lastKey, firstKey)
+		}
+		last.next, first.prev = first, last
+		a.splice(b.root, firstKey)
+	} else {
+		a.root = b.root
+	}
+
+	a.size += b.size
+	a.tombstones += b.tombstones
+	if a.aggregate != nil {
+		a.aggregate.total = a.aggregate.add(a.aggregate.total, bTotal)
+	}
+	a.hint = nil
+
+	b.root = nil
+	b.size = 0
+	b.tombstones = 0
+	b.hint = nil
+	if b.aggregate != nil {
+		b.aggregate.total = b.aggregate.zero
+	}
+	return a, nil
+}
+
+// splice joins the subtree other, whose keys all lie above those of t and
+// start at sep, onto t's root. The shorter root becomes the outermost child
+// of the node one level above it on the near edge of the taller tree, with
+// sep as its separator. A root below minimum occupancy is then topped up
+// from its new neighbour and an overflowing parent split, as after a delete
+// or an insert.
+func (t *BPlusTree[K, V]) splice(other *node[K, V], sep K) {
+	low, high := t.root, other
+	lowHeight, highHeight := low.height(), high.height()
+
+	var parent, child *node[K, V]
+	switch {
+	case lowHeight == highHeight:
+		parent = &node[K, V]{keys: []K{sep}, children: []*node[K, V]{low, high}}
+		low.parent, high.parent = parent, parent
+		t.root = parent
+		t.fillUnderfull(low)
+		if t.root == parent {
+			// low did not absorb high
+			t.fillUnderfull(high)
+		}
+		return
+	case lowHeight > highHeight:
+		parent = low
+		for range lowHeight - highHeight - 1 {
+			parent = parent.children[len(parent.children)-1]
+		}
+		parent.keys = append(parent.keys, sep)
+		parent.children = append(parent.children, high)
+		child = high
+	default:
+		t.root = high
+		parent = high
+		for range highHeight - lowHeight - 1 {
+			parent = parent.children[0]
+		}
+		parent.keys = append([]K{sep}, parent.keys...)
+		parent.children = append([]*node[K, V]{low}, parent.children...)
+		child = low
+	}
+	child.parent = parent
+
+	t.fillUnderfull(child)
+	if len(parent.keys) > t.maxInternalKeys() {
+		t.splitInternal(parent)
+	}
+}
+
+// fillUnderfull tops up n by borrowing from its siblings one entry at a
+// time until it reaches minimum occupancy, or merges it into a sibling
+func (t *BPlusTree[K, V]) fillUnderfull(n *node[K, V]) {
+	if n.isLeaf {
+		for n.parent != nil && len(n.entries) < t.minLeafEntries() {
+			if t.rebalanceLeaf(n) {
+				return
+			}
+		}
+		return
+	}
+	for n.parent != nil && len(n.keys) < t.minInternalKeys() {
+		parent := n.parent
+		children := len(parent.children)
+		t.rebalanceInternal(n)
+		if len(parent.children) < children {
+			return
+		}
+	}
+}
+
+// height returns the number of levels in the subtree rooted at n
+func (n *node[K, V]) height() int {
+	h := 1
+	for !n.isLeaf {
+		n = n.children[0]
+		h++
+	}
+	return h
+}
@@ -0,0 +1,35 @@
+package bplustree
+
+import "errors"
+
+// Sentinel errors returned by the error-returning operations of this
+// package. They may be wrapped with more detail, so compare with errors.Is.
+var (
+	// ErrInvalidDegree is returned by NewChecked for a degree below 2
+	ErrInvalidDegree = errors.New("bplustree: degree must be at least 2")
+	// ErrInvalidFillFactor is returned for a fill factor outside (0, 1]
+	ErrInvalidFillFactor = errors.New("bplustree: fill factor must be in (0, 1]")
+	// ErrInvalidSplitRatio is returned by NewWithSplitRatio for a ratio
+	// that would leave one side of a split below minimum occupancy
+	ErrInvalidSplitRatio = errors.New("bplustree: split ratio leaves a node below minimum occupancy")
+	// ErrUnsorted is returned when input that must be in strictly
+	// increasing key order is not
+	ErrUnsorted = errors.New("bplustree: keys not in strictly increasing order")
+	// ErrDuplicateKey is returned by InsertChecked, and raised by Insert, for
+	// an existing key in a tree using DuplicateError
+	ErrDuplicateKey = errors.New("bplustree: key already present")
+	// ErrHeightLimit is returned when an insert would grow a tree created
+	// with NewWithMaxHeight past its limit
+	ErrHeightLimit = errors.New("bplustree: tree height limit reached")
+	// ErrNotDisjoint is returned by Concat when the keys of the first tree
+	// do not all lie below those of the second
+	ErrNotDisjoint = errors.New("bplustree: key ranges overlap")
+	// ErrIncompatible is returned by Concat for trees whose nodes are sized
+	// differently
+	ErrIncompatible = errors.New("bplustree: trees have different node layouts")
+	// ErrEmpty is returned by operations that need at least one entry
+	ErrEmpty = errors.New("bplustree: tree is empty")
+	// ErrNaNKey is returned by InsertChecked and the bulk loads for a NaN
+	// key, which has no place in the key order
+	ErrNaNKey = errors.New("bplustree: NaN key cannot be ordered")
+)
@@ -0,0 +1,46 @@
+package bplustree
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+)
+
+// WriteCSV writes one key,value row per entry to w in key order, streaming
+// from the leaf chain without collecting the entries first. keyFmt and valFmt
+// turn keys and values into fields; a nil formatter uses fmt.Sprint. Fields
+// are quoted as needed by encoding/csv. No header row is written.
+func (t *BPlusTree[K, V]) WriteCSV(w io.Writer, keyFmt func(K) string, valFmt func(V) string) error {
+	return t.writeDelimited(w, ',', keyFmt, valFmt)
+}
+
+// WriteTSV is like WriteCSV but separates fields with tabs
+func (t *BPlusTree[K, V]) WriteTSV(w io.Writer, keyFmt func(K) string, valFmt func(V) string) error {
+	return t.writeDelimited(w, '\t', keyFmt, valFmt)
+}
+
+func (t *BPlusTree[K, V]) writeDelimited(w io.Writer, comma rune, keyFmt func(K) string, valFmt func(V) string) error {
+	if keyFmt == nil {
+		keyFmt = func(k K) string { return fmt.Sprint(k) }
+	}
+	if valFmt == nil {
+		valFmt = func(v V) string { return fmt.Sprint(v) }
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	record := make([]string, 2)
+	for leaf := t.firstLeaf(); leaf != nil; leaf = leaf.next {
+		for _, e := range leaf.entries {
+			if e.deleted {
+				continue
+			}
+			record[0], record[1] = keyFmt(e.Key), valFmt(e.Value)
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,59 @@
+package bplustree
+
+// Op identifies the kind of mutation reported to a mutation hook
+type Op int
+
+const (
+	OpInsert Op = iota // a new key was added
+	OpUpdate           // an existing key was given a new value
+	OpDelete           // a key was removed; the hook receives its old value
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpInsert:
+		return "insert"
+	case OpUpdate:
+		return "update"
+	case OpDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+// SetMutationHook registers fn to be called synchronously after every
+// successful logical mutation, once per affected key, for example to append
+// to a write-ahead log. DeleteBatch and Retain report one OpDelete per removed
+// key; BulkLoad reports the previous contents as deletes followed by the new
+// contents as inserts. Passing nil removes the hook.
+func (t *BPlusTree[K, V]) SetMutationHook(fn func(op Op, key K, value V)) {
+	t.hook = fn
+}
+
+// SetEvictionCallback registers fn to be called with every entry that leaves
+// the tree, for example to release a resource the value holds. It fires once
+// per logical removal, after the tree has been updated, with the value that
+// was removed: on Delete, DeleteBatch, Retain, Clear and the replacement of
+// contents by BulkLoad, and with the old value when Insert, InsertWithMerge
+// or UpdateEach overwrites a value. A soft delete counts as the removal, so
+// neither Purge nor a later Delete of the tombstone fires again. Passing nil
+// removes the callback.
+func (t *BPlusTree[K, V]) SetEvictionCallback(fn func(key K, value V)) {
+	t.evict = fn
+}
+
+// watching reports whether removed entries have to be reported to anyone
+func (t *BPlusTree[K, V]) watching() bool {
+	return t.hook != nil || t.evict != nil
+}
+
+// reportRemoval reports an entry that left the tree to the mutation hook
+// and the eviction callback
+func (t *BPlusTree[K, V]) reportRemoval(key K, value V) {
+	if t.hook != nil {
+		t.hook(OpDelete, key, value)
+	}
+	if t.evict != nil {
+		t.evict(key, value)
+	}
+}
@@ -0,0 +1,183 @@
+package bplustree
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// LatchedBPlusTree is an experimental concurrent B+ tree that locks
+// individual nodes instead of the whole tree. Operations descend with
+// lock coupling: a child is locked before its parent is released, so
+// operations working in different subtrees proceed in parallel. Search holds
+// at most two read locks at a time. Insert keeps write locks on the path
+// only from the lowest node that could still split, releasing everything
+// above it as soon as a node with spare room is reached.
+//
+// Only Search, Insert and Len are supported so far; there are no deletes,
+// range scans or leaf links.
+type LatchedBPlusTree[K cmp.Ordered, V any] struct {
+	rootMu      sync.RWMutex // guards root; held by Insert while the root may split
+	root        *latchedNode[K, V]
+	leafCap     int
+	internalCap int
+	size        atomic.Int64
+}
+
+type latchedNode[K cmp.Ordered, V any] struct {
+	mu       sync.RWMutex
+	isLeaf   bool
+	keys     []K
+	children []*latchedNode[K, V]
+	entries  []Entry[K, V]
+}
+
+// NewLatched creates an empty lock-coupled tree, clamping degree like New
+func NewLatched[K cmp.Ordered, V any](degree int) *LatchedBPlusTree[K, V] {
+	degree = max(degree, 2)
+	return &LatchedBPlusTree[K, V]{
+		root:        &latchedNode[K, V]{isLeaf: true},
+		leafCap:     2*degree - 1,
+		internalCap: 2*degree - 1,
+	}
+}
+
+// Search returns the value stored under key
+func (t *LatchedBPlusTree[K, V]) Search(key K) (V, bool) {
+	t.rootMu.RLock()
+	n := t.root
+	n.mu.RLock()
+	t.rootMu.RUnlock()
+
+	for !n.isLeaf {
+		child := n.children[childIndex(n.keys, key)]
+		child.mu.RLock()
+		n.mu.RUnlock()
+		n = child
+	}
+	defer n.mu.RUnlock()
+
+	if i, found := n.find(key); found {
+		return n.entries[i].Value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// Insert adds an entry or updates the value of an existing key. Like
+// BPlusTree.Insert it panics on a NaN key.
+func (t *LatchedBPlusTree[K, V]) Insert(key K, value V) {
+	mustBeOrdered(key)
+	t.rootMu.Lock()
+	rootLocked := true
+	var held []*latchedNode[K, V]
+	release := func() {
+		for _, n := range held {
+			n.mu.Unlock()
+		}
+		held = held[:0]
+		if rootLocked {
+			t.rootMu.Unlock()
+			rootLocked = false
+		}
+	}
+	defer release()
+
+	n := t.root
+	for {
+		n.mu.Lock()
+		if t.safe(n) {
+			// Nothing above n can change, whatever happens below
+			release()
+		}
+		held = append(held, n)
+		if n.isLeaf {
+			break
+		}
+		n = n.children[childIndex(n.keys, key)]
+	}
+
+	i, found := n.find(key)
+	if found {
+		n.entries[i].Value = value
+		return
+	}
+	n.entries = slices.Insert(n.entries, i, Entry[K, V]{Key: key, Value: value})
+	t.size.Add(1)
+
+	// Only the held nodes can overflow, and only the topmost of them can be
+	// the root, in which case rootMu is still held
+	for level := len(held) - 1; level >= 0 && t.overflows(held[level]); level-- {
+		node := held[level]
+		sep, right := node.split()
+		if level == 0 {
+			t.root = &latchedNode[K, V]{keys: []K{sep}, children: []*latchedNode[K, V]{node, right}}
+			break
+		}
+		parent := held[level-1]
+		j := childIndex(parent.keys, key)
+		parent.keys = slices.Insert(parent.keys, j, sep)
+		parent.children = slices.Insert(parent.children, j+1, right)
+	}
+}
+
+// Len returns the number of entries
+func (t *LatchedBPlusTree[K, V]) Len() int {
+	return int(t.size.Load())
+}
+
+// safe reports whether one more insert into n cannot make it split
+func (t *LatchedBPlusTree[K, V]) safe(n *latchedNode[K, V]) bool {
+	if n.isLeaf {
+		return len(n.entries) < t.leafCap
+	}
+	return len(n.keys) < t.internalCap
+}
+
+func (t *LatchedBPlusTree[K, V]) overflows(n *latchedNode[K, V]) bool {
+	if n.isLeaf {
+		return len(n.entries) > t.leafCap
+	}
+	return len(n.keys) > t.internalCap
+}
+
+// find returns the position of key among the entries of leaf n
+func (n *latchedNode[K, V]) find(key K) (int, bool) {
+	return slices.BinarySearchFunc(n.entries, key, func(e Entry[K, V], k K) int {
+		return cmp.Compare(e.Key, k)
+	})
+}
+
+// split moves the upper half of n into a new right sibling and returns the
+// separator key for the parent along with the sibling
+func (n *latchedNode[K, V]) split() (K, *latchedNode[K, V]) {
+	if n.isLeaf {
+		mid := len(n.entries) / 2
+		right := &latchedNode[K, V]{isLeaf: true, entries: slices.Clone(n.entries[mid:])}
+		clear(n.entries[mid:])
+		n.entries = n.entries[:mid]
+		return right.entries[0].Key, right
+	}
+
+	mid := len(n.keys) / 2
+	sep := n.keys[mid]
+	right := &latchedNode[K, V]{
+		keys:     slices.Clone(n.keys[mid+1:]),
+		children: slices.Clone(n.children[mid+1:]),
+	}
+	n.keys = n.keys[:mid]
+	clear(n.children[mid+1:])
+	n.children = n.children[:mid+1]
+	return sep, right
+}
+
+// childIndex returns the child to descend into for key: the number of
+// separators not above it
+func childIndex[K cmp.Ordered](keys []K, key K) int {
+	i, found := slices.BinarySearch(keys, key)
+	if found {
+		i++
+	}
+	return i
+}
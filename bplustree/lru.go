@@ -0,0 +1,103 @@
+package bplustree
+
+import (
+	"cmp"
+	"container/list"
+)
+
+// LRUBPlusTree is an ordered cache holding at most a fixed number of
+// entries. The tree keeps the entries in key order for range queries, and a
+// doubly linked list keeps them in order of use. Inserting a new key into a
+// full cache evicts the least recently used entry from both.
+//
+// Insert and Search count as uses; Range, Len and Contains do not, so a scan
+// over the cache does not push out the entries that are actually hot.
+type LRUBPlusTree[K cmp.Ordered, V any] struct {
+	tree     *BPlusTree[K, *list.Element]
+	order    *list.List // front is the most recently used
+	capacity int
+}
+
+// lruEntry is the payload of an element of the recency list
+type lruEntry[K cmp.Ordered, V any] struct {
+	key   K
+	value V
+}
+
+// NewLRU creates an empty LRU tree of the given degree holding at most
+// capacity entries. A capacity below 1 is treated as 1.
+func NewLRU[K cmp.Ordered, V any](degree, capacity int) *LRUBPlusTree[K, V] {
+	return &LRUBPlusTree[K, V]{
+		tree:     New[K, *list.Element](degree),
+		order:    list.New(),
+		capacity: max(capacity, 1),
+	}
+}
+
+// Insert adds an entry or replaces the value of an existing key, marking it
+// most recently used. If a new key does not fit, the least recently used
+// entry is evicted and returned.
+func (t *LRUBPlusTree[K, V]) Insert(key K, value V) (evicted Entry[K, V], ok bool) {
+	if elem, found := t.tree.Search(key); found {
+		elem.Value.(*lruEntry[K, V]).value = value
+		t.order.MoveToFront(elem)
+		return evicted, false
+	}
+
+	if t.order.Len() >= t.capacity {
+		oldest := t.order.Back()
+		e := t.order.Remove(oldest).(*lruEntry[K, V])
+		t.tree.Delete(e.key)
+		evicted, ok = Entry[K, V]{Key: e.key, Value: e.value}, true
+	}
+	t.tree.Insert(key, t.order.PushFront(&lruEntry[K, V]{key: key, value: value}))
+	return evicted, ok
+}
+
+// Search returns the value stored under key and marks it most recently used
+func (t *LRUBPlusTree[K, V]) Search(key K) (V, bool) {
+	elem, found := t.tree.Search(key)
+	if !found {
+		var zero V
+		return zero, false
+	}
+	t.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry[K, V]).value, true
+}
+
+// Contains reports whether key is cached without marking it used
+func (t *LRUBPlusTree[K, V]) Contains(key K) bool {
+	_, found := t.tree.Search(key)
+	return found
+}
+
+// Delete removes key and reports whether it was present
+func (t *LRUBPlusTree[K, V]) Delete(key K) bool {
+	elem, found := t.tree.Search(key)
+	if !found {
+		return false
+	}
+	t.order.Remove(elem)
+	return t.tree.Delete(key)
+}
+
+// Range returns the entries with keys in [start, end] in key order, without
+// changing their recency
+func (t *LRUBPlusTree[K, V]) Range(start, end K) []Entry[K, V] {
+	elems := t.tree.Range(start, end)
+	result := make([]Entry[K, V], len(elems))
+	for i, e := range elems {
+		result[i] = Entry[K, V]{Key: e.Key, Value: e.Value.Value.(*lruEntry[K, V]).value}
+	}
+	return result
+}
+
+// Len returns the number of cached entries
+func (t *LRUBPlusTree[K, V]) Len() int {
+	return t.order.Len()
+}
+
+// Capacity returns the maximum number of entries
+func (t *LRUBPlusTree[K, V]) Capacity() int {
+	return t.capacity
+}
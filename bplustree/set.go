@@ -0,0 +1,111 @@
+package bplustree
+
+import "cmp"
+
+// Set is an ordered set of keys backed by a B+ tree with empty values.
+// Union, Intersect and Diff merge-walk the sorted keys of both sets and
+// bulk load the result, so they run in linear time.
+type Set[K cmp.Ordered] struct {
+	tree *BPlusTree[K, struct{}]
+}
+
+// NewSet creates an empty set backed by a B+ tree of the given degree
+func NewSet[K cmp.Ordered](degree int) *Set[K] {
+	return &Set[K]{tree: New[K, struct{}](degree)}
+}
+
+// Add inserts k and reports whether it was not already present
+func (s *Set[K]) Add(k K) bool {
+	return s.tree.InsertIfAbsent(k, struct{}{})
+}
+
+// Remove deletes k and reports whether it was present
+func (s *Set[K]) Remove(k K) bool {
+	return s.tree.Delete(k)
+}
+
+// Contains reports whether k is in the set
+func (s *Set[K]) Contains(k K) bool {
+	_, ok := s.tree.Search(k)
+	return ok
+}
+
+// Len returns the number of keys in the set
+func (s *Set[K]) Len() int {
+	return s.tree.Len()
+}
+
+// Range returns the keys in [lo, hi] in ascending order
+func (s *Set[K]) Range(lo, hi K) []K {
+	entries := s.tree.Range(lo, hi)
+	keys := make([]K, len(entries))
+	for i, e := range entries {
+		keys[i] = e.Key
+	}
+	return keys
+}
+
+// Sorted returns every key in ascending order
+func (s *Set[K]) Sorted() []K {
+	return s.tree.Keys()
+}
+
+// Union returns a new set with the keys present in s or other
+func (s *Set[K]) Union(other *Set[K]) *Set[K] {
+	return s.merge(other, true, true, true)
+}
+
+// Intersect returns a new set with the keys present in both s and other
+func (s *Set[K]) Intersect(other *Set[K]) *Set[K] {
+	return s.merge(other, false, true, false)
+}
+
+// Diff returns a new set with the keys of s that are not in other
+func (s *Set[K]) Diff(other *Set[K]) *Set[K] {
+	return s.merge(other, true, false, false)
+}
+
+// merge walks the sorted keys of s and other in step, keeping keys found
+// only in s, in both, or only in other as requested. The result uses the
+// node capacities of s.
+func (s *Set[K]) merge(other *Set[K], onlyS, both, onlyOther bool) *Set[K] {
+	a, b := s.Sorted(), other.Sorted()
+	entries := make([]Entry[K, struct{}], 0, len(a)+len(b))
+	keep := func(k K) {
+		entries = append(entries, Entry[K, struct{}]{Key: k})
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			if onlyS {
+				keep(a[i])
+			}
+			i++
+		case a[i] > b[j]:
+			if onlyOther {
+				keep(b[j])
+			}
+			j++
+		default:
+			if both {
+				keep(a[i])
+			}
+			i++
+			j++
+		}
+	}
+	for ; onlyS && i < len(a); i++ {
+		keep(a[i])
+	}
+	for ; onlyOther && j < len(b); j++ {
+		keep(b[j])
+	}
+
+	// The walk yields keys in strictly increasing order, so the tree can be
+	// built from them directly, with nothing left to check
+	result := &Set[K]{tree: NewWithCapacities[K, struct{}](s.tree.leafCap, s.tree.internalCap)}
+	result.tree.build(entries, 1)
+	return result
+}
@@ -0,0 +1,135 @@
+package bplustree
+
+import (
+	"cmp"
+	"container/heap"
+	"hash/maphash"
+	"sync"
+)
+
+// ShardedBPlusTree spreads keys over a fixed number of independent B+ trees,
+// each guarded by its own lock, so writers touching different shards do not
+// contend. Point operations lock a single shard. Range and All visit every
+// shard and k-way merge the results, which makes them more expensive than on
+// a single tree and means they are not an atomic snapshot across shards. The
+// shard count is fixed at construction.
+type ShardedBPlusTree[K cmp.Ordered, V any] struct {
+	seed   maphash.Seed
+	shards []*shard[K, V]
+}
+
+type shard[K cmp.Ordered, V any] struct {
+	mu   sync.RWMutex
+	tree *BPlusTree[K, V]
+}
+
+// NewSharded creates a sharded tree with the given number of shards, each a
+// B+ tree of the given degree. A shard count below 1 is raised to 1.
+func NewSharded[K cmp.Ordered, V any](shards, degree int) *ShardedBPlusTree[K, V] {
+	if shards < 1 {
+		shards = 1
+	}
+	t := &ShardedBPlusTree[K, V]{
+		seed:   maphash.MakeSeed(),
+		shards: make([]*shard[K, V], shards),
+	}
+	for i := range t.shards {
+		t.shards[i] = &shard[K, V]{tree: New[K, V](degree)}
+	}
+	return t
+}
+
+func (t *ShardedBPlusTree[K, V]) shardFor(key K) *shard[K, V] {
+	h := maphash.Comparable(t.seed, key)
+	return t.shards[h%uint64(len(t.shards))]
+}
+
+func (t *ShardedBPlusTree[K, V]) Insert(key K, value V) {
+	s := t.shardFor(key)
+	s.mu.Lock()
+	s.tree.Insert(key, value)
+	s.mu.Unlock()
+}
+
+func (t *ShardedBPlusTree[K, V]) Search(key K) (V, bool) {
+	s := t.shardFor(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.Search(key)
+}
+
+func (t *ShardedBPlusTree[K, V]) Delete(key K) bool {
+	s := t.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.Delete(key)
+}
+
+func (t *ShardedBPlusTree[K, V]) Len() int {
+	n := 0
+	for _, s := range t.shards {
+		s.mu.RLock()
+		n += s.tree.Len()
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// Range returns the entries with start <= key <= end from every shard,
+// merged into key order
+func (t *ShardedBPlusTree[K, V]) Range(start, end K) []Entry[K, V] {
+	return t.merge(func(tree *BPlusTree[K, V]) []Entry[K, V] {
+		return tree.Range(start, end)
+	})
+}
+
+// All returns every entry from every shard, merged into key order
+func (t *ShardedBPlusTree[K, V]) All() []Entry[K, V] {
+	return t.merge(func(tree *BPlusTree[K, V]) []Entry[K, V] {
+		return tree.All()
+	})
+}
+
+// merge collects a sorted run from each shard under its read lock and k-way
+// merges the runs
+func (t *ShardedBPlusTree[K, V]) merge(collect func(*BPlusTree[K, V]) []Entry[K, V]) []Entry[K, V] {
+	runs := make(mergeHeap[K, V], 0, len(t.shards))
+	total := 0
+	for _, s := range t.shards {
+		s.mu.RLock()
+		run := collect(s.tree)
+		s.mu.RUnlock()
+		if len(run) > 0 {
+			runs = append(runs, run)
+			total += len(run)
+		}
+	}
+
+	result := make([]Entry[K, V], 0, total)
+	heap.Init(&runs)
+	for len(runs) > 0 {
+		result = append(result, runs[0][0])
+		runs[0] = runs[0][1:]
+		if len(runs[0]) == 0 {
+			heap.Pop(&runs)
+		} else {
+			heap.Fix(&runs, 0)
+		}
+	}
+	return result
+}
+
+// mergeHeap orders non-empty sorted runs by their first key
+type mergeHeap[K cmp.Ordered, V any] [][]Entry[K, V]
+
+func (h mergeHeap[K, V]) Len() int           { return len(h) }
+func (h mergeHeap[K, V]) Less(i, j int) bool { return h[i][0].Key < h[j][0].Key }
+func (h mergeHeap[K, V]) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap[K, V]) Push(x any)        { *h = append(*h, x.([]Entry[K, V])) }
+func (h *mergeHeap[K, V]) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
@@ -0,0 +1,108 @@
+package bplustree
+
+import (
+	"cmp"
+	"container/heap"
+	"encoding/gob"
+	"errors"
+	"io"
+)
+
+// Spill writes the contents of the tree to w in key order and then empties
+// the tree, so it can be reused to build the next run of an external sort.
+// The entries are moved rather than deleted, so unlike Clear it does not
+// report them to the mutation hook or the eviction callback. Entries are gob
+// encoded; K and V must be types gob can encode. If writing fails the tree is
+// left unchanged.
+func (t *BPlusTree[K, V]) Spill(w io.Writer) error {
+	enc := gob.NewEncoder(w)
+	for leaf := t.firstLeaf(); leaf != nil; leaf = leaf.next {
+		for _, e := range leaf.entries {
+			if e.deleted {
+				continue
+			}
+			if err := enc.Encode(e.Entry); err != nil {
+				return err
+			}
+		}
+	}
+	t.build(nil, 1)
+	return nil
+}
+
+// MergeSortedStreams k-way merges runs written by Spill into a single sorted
+// run on w, in the same format. Only one entry per reader is held in memory at
+// a time. Keys repeated across runs are all kept, the one from the earlier
+// reader first.
+func MergeSortedStreams[K cmp.Ordered, V any](readers []io.Reader, w io.Writer) error {
+	runs := make(streamHeap[K, V], 0, len(readers))
+	for i, r := range readers {
+		s := &stream[K, V]{dec: gob.NewDecoder(r), index: i}
+		ok, err := s.advance()
+		if err != nil {
+			return err
+		}
+		if ok {
+			runs = append(runs, s)
+		}
+	}
+
+	enc := gob.NewEncoder(w)
+	heap.Init(&runs)
+	for len(runs) > 0 {
+		s := runs[0]
+		if err := enc.Encode(s.head); err != nil {
+			return err
+		}
+		ok, err := s.advance()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&runs, 0)
+		} else {
+			heap.Pop(&runs)
+		}
+	}
+	return nil
+}
+
+// stream is a spilled run being merged, with its next entry decoded
+type stream[K cmp.Ordered, V any] struct {
+	dec   *gob.Decoder
+	head  Entry[K, V]
+	index int
+}
+
+// advance decodes the next entry into head, reporting false at the end of the run
+func (s *stream[K, V]) advance() (bool, error) {
+	var e Entry[K, V]
+	if err := s.dec.Decode(&e); err != nil {
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		return false, err
+	}
+	s.head = e
+	return true, nil
+}
+
+// streamHeap orders streams by their head key, then by reader index
+type streamHeap[K cmp.Ordered, V any] []*stream[K, V]
+
+func (h streamHeap[K, V]) Len() int { return len(h) }
+func (h streamHeap[K, V]) Less(i, j int) bool {
+	if c := cmp.Compare(h[i].head.Key, h[j].head.Key); c != 0 {
+		return c < 0
+	}
+	return h[i].index < h[j].index
+}
+func (h streamHeap[K, V]) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *streamHeap[K, V]) Push(x any)   { *h = append(*h, x.(*stream[K, V])) }
+func (h *streamHeap[K, V]) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
@@ -0,0 +1,59 @@
+package bplustree
+
+// SetSoftDelete switches soft delete mode on or off. While it is on, Delete
+// and DeleteBatch do not remove entries from their leaves but mark them as
+// tombstones: the entries stay in place, so the tree shape does not change,
+// but every read skips them and Len no longer counts them. Inserting a
+// tombstoned key brings it back as a new entry. Purge removes tombstones
+// physically. Switching the mode off keeps existing tombstones until the
+// next Purge or rebuild.
+func (t *BPlusTree[K, V]) SetSoftDelete(enabled bool) {
+	t.softDelete = enabled
+}
+
+// TombstoneCount returns the number of soft-deleted entries still held in
+// the leaves
+func (t *BPlusTree[K, V]) TombstoneCount() int {
+	return t.tombstones
+}
+
+// Purge physically removes every tombstoned entry in one compaction pass,
+// rebuilding the tree fully packed from the live entries. The logical
+// contents are unchanged, so no mutation hook events are reported.
+func (t *BPlusTree[K, V]) Purge() {
+	if t.tombstones == 0 {
+		return
+	}
+	t.build(t.All(), 1)
+}
+
+// nextLive returns the first live entry at or after index i of leaf,
+// following the leaf chain forwards
+func (t *BPlusTree[K, V]) nextLive(leaf *node[K, V], i int) (Entry[K, V], bool) {
+	for leaf != nil {
+		for ; i < len(leaf.entries); i++ {
+			if !leaf.entries[i].deleted {
+				return leaf.entries[i].Entry, true
+			}
+		}
+		leaf, i = leaf.next, 0
+	}
+	return Entry[K, V]{}, false
+}
+
+// prevLive returns the last live entry before index i of leaf, following the
+// leaf chain backwards
+func (t *BPlusTree[K, V]) prevLive(leaf *node[K, V], i int) (Entry[K, V], bool) {
+	for leaf != nil {
+		for i--; i >= 0; i-- {
+			if !leaf.entries[i].deleted {
+				return leaf.entries[i].Entry, true
+			}
+		}
+		leaf = leaf.prev
+		if leaf != nil {
+			i = len(leaf.entries)
+		}
+	}
+	return Entry[K, V]{}, false
+}
@@ -3,6 +3,7 @@ package btree
 import (
 	"fmt"
 	"strings"
+	"sync/atomic"
 )
 
 // Ordered constraint for types that can be compared
@@ -16,6 +17,16 @@ type Ordered interface {
 type BTree[K Ordered, V any] struct {
 	root   *Node[K, V]
 	degree int // minimum degree (t)
+	cow    *copyOnWriteContext
+
+	counting    bool         // whether key comparisons are tallied
+	comparisons atomic.Int64 // added to once per operation
+}
+
+// copyOnWriteContext marks the nodes a tree may modify in place; nodes owned
+// by any other context are shared with a snapshot and are cloned first
+type copyOnWriteContext struct {
+	_ int // non-zero size so every context has a distinct address
 }
 
 // Node represents a node in the B-tree
@@ -24,6 +35,7 @@ type Node[K Ordered, V any] struct {
 	values   []V
 	children []*Node[K, V]
 	isLeaf   bool
+	cow      *copyOnWriteContext
 }
 
 // KeyValue represents a key-value pair
@@ -32,59 +44,208 @@ type KeyValue[K Ordered, V any] struct {
 	Value V
 }
 
-// NewBTree creates a new B-tree with the specified minimum degree
+// TreeInfo describes the shape of a B-tree for white-box testing
+type TreeInfo struct {
+	RootKeys      int   // number of keys in the root node
+	RootIsLeaf    bool  // whether the root node is a leaf
+	NodesPerLevel []int // node count at each level, root first
+	KeysPerLevel  []int // key count at each level, root first
+}
+
+// NewBTree creates a new B-tree with the specified minimum degree. Degrees
+// below 2, including zero and negative values, are raised to 2; Degree
+// reports the value actually in use. Nodes hold at most 2*degree-1 keys and,
+// below the root, at least degree-1, so the smallest tree has nodes of 1 to 3.
 func NewBTree[K Ordered, V any](degree int) *BTree[K, V] {
 	if degree < 2 {
 		degree = 2 // minimum degree should be at least 2
 	}
+	cow := &copyOnWriteContext{}
 	return &BTree[K, V]{
-		root:   newNode[K, V](true),
+		root:   newNode[K, V](true, cow),
 		degree: degree,
+		cow:    cow,
 	}
 }
 
-// newNode creates a new node
-func newNode[K Ordered, V any](isLeaf bool) *Node[K, V] {
+// SuggestDegree returns a minimum degree for a tree expected to hold
+// estimatedEntries entries whose values take valueSize bytes each. It aims
+// for nodes of about 4KB, counting 16 bytes per entry for the key and 8 for
+// the child pointer on top of the value:
+//
+//	maxKeys = 4096 / (valueSize + 24)
+//	degree  = (maxKeys + 1) / 2
+//
+// The result is capped so a node is no larger than the whole dataset, and
+// clamped to [2, 256]. It is a heuristic starting point, not a guarantee.
+func SuggestDegree(estimatedEntries int, valueSize int) int {
+	const (
+		nodeBytes     = 4096
+		entryOverhead = 24
+		maxDegree     = 256
+	)
+
+	maxKeys := nodeBytes / (max(valueSize, 0) + entryOverhead)
+	degree := (maxKeys + 1) / 2
+	// A single node of 2t-1 keys already holds the whole dataset
+	degree = min(degree, (estimatedEntries+1)/2)
+	return min(max(degree, 2), maxDegree)
+}
+
+// newNode creates a new node owned by the given copy-on-write context
+func newNode[K Ordered, V any](isLeaf bool, cow *copyOnWriteContext) *Node[K, V] {
 	return &Node[K, V]{
 		keys:     make([]K, 0),
 		values:   make([]V, 0),
 		children: make([]*Node[K, V], 0),
 		isLeaf:   isLeaf,
+		cow:      cow,
+	}
+}
+
+// mutable returns node if the tree owns it, otherwise a private copy of it
+func (bt *BTree[K, V]) mutable(node *Node[K, V]) *Node[K, V] {
+	if node.cow == bt.cow {
+		return node
 	}
+	clone := newNode[K, V](node.isLeaf, bt.cow)
+	clone.keys = append(clone.keys, node.keys...)
+	clone.values = append(clone.values, node.values...)
+	clone.children = append(clone.children, node.children...)
+	return clone
+}
+
+// mutableChild makes the child at index safe to modify and returns it
+func (bt *BTree[K, V]) mutableChild(parent *Node[K, V], index int) *Node[K, V] {
+	parent.children[index] = bt.mutable(parent.children[index])
+	return parent.children[index]
 }
 
 // Insert inserts a key-value pair into the B-tree
 func (bt *BTree[K, V]) Insert(key K, value V) {
+	bt.root = bt.mutable(bt.root)
 	root := bt.root
 	if bt.isFull(root) {
 		// Root is full, need to split
-		newRoot := newNode[K, V](false)
+		newRoot := newNode[K, V](false, bt.cow)
 		newRoot.children = append(newRoot.children, root)
 		bt.splitChild(newRoot, 0)
 		bt.root = newRoot
 	}
-	bt.insertNonFull(bt.root, key, value)
+	cmps := bt.tally()
+	bt.insertNonFull(bt.root, key, value, cmps)
+	bt.record(cmps)
 }
 
 // Search searches for a key in the B-tree
 func (bt *BTree[K, V]) Search(key K) (V, bool) {
+	if bt.counting {
+		return bt.countedSearch(key)
+	}
 	return bt.searchNode(bt.root, key)
 }
 
+// countedSearch is Search adding its key comparisons to the tally, kept
+// apart so plain searches do no counting at all
+func (bt *BTree[K, V]) countedSearch(key K) (V, bool) {
+	var cmps int
+	defer func() { bt.comparisons.Add(int64(cmps)) }()
+	for node := bt.root; ; {
+		i, found := bt.findKey(node, key)
+		cmps += probes(node, i)
+		if found {
+			return node.values[i], true
+		}
+		if node.isLeaf {
+			var zero V
+			return zero, false
+		}
+		node = node.children[i]
+	}
+}
+
 // Delete removes a key from the B-tree
 func (bt *BTree[K, V]) Delete(key K) bool {
-	deleted := bt.deleteFromNode(bt.root, key)
+	_, deleted := bt.DeleteAndGet(key)
+	return deleted
+}
+
+// DeleteAndGet removes a key from the B-tree and returns its value
+func (bt *BTree[K, V]) DeleteAndGet(key K) (V, bool) {
+	bt.root = bt.mutable(bt.root)
+	cmps := bt.tally()
+	value, deleted := bt.deleteFromNode(bt.root, key, cmps)
+	bt.record(cmps)
 	if len(bt.root.keys) == 0 && !bt.root.isLeaf {
 		bt.root = bt.root.children[0]
 	}
-	return deleted
+	return value, deleted
+}
+
+// DeleteMin removes the smallest key in one descent and returns it with its value
+func (bt *BTree[K, V]) DeleteMin() (K, V, bool) {
+	return bt.deleteEdge(false)
+}
+
+// DeleteMax removes the largest key in one descent and returns it with its value
+func (bt *BTree[K, V]) DeleteMax() (K, V, bool) {
+	return bt.deleteEdge(true)
+}
+
+// deleteEdge removes the first or last key of the tree
+func (bt *BTree[K, V]) deleteEdge(last bool) (K, V, bool) {
+	if len(bt.root.keys) == 0 {
+		var zeroK K
+		var zeroV V
+		return zeroK, zeroV, false
+	}
+	bt.root = bt.mutable(bt.root)
+	key, value := bt.deleteEdgeFromNode(bt.root, last)
+	if len(bt.root.keys) == 0 && !bt.root.isLeaf {
+		bt.root = bt.root.children[0]
+	}
+	return key, value, true
+}
+
+// deleteEdgeFromNode removes the first or last key of a non-empty subtree,
+// topping up each child before descending into it like deleteFromNode
+func (bt *BTree[K, V]) deleteEdgeFromNode(node *Node[K, V], last bool) (K, V) {
+	if node.isLeaf {
+		i := 0
+		if last {
+			i = len(node.keys) - 1
+		}
+		key, value := node.keys[i], node.values[i]
+		node.keys = append(node.keys[:i], node.keys[i+1:]...)
+		node.values = append(node.values[:i], node.values[i+1:]...)
+		return key, value
+	}
+
+	i := 0
+	if last {
+		i = len(node.children) - 1
+	}
+	if len(node.children[i].keys) < bt.degree {
+		i = bt.handleChildUnderflow(node, i)
+	}
+	return bt.deleteEdgeFromNode(bt.mutableChild(node, i), last)
 }
 
 // InOrderTraversal performs in-order traversal of the B-tree
 func (bt *BTree[K, V]) InOrderTraversal() []KeyValue[K, V] {
-	var result []KeyValue[K, V]
-	bt.inOrderTraverseNode(bt.root, &result)
-	return result
+	return bt.AppendAll(nil)
+}
+
+// AppendAll appends every item in key order to dst and returns the extended
+// slice, following the append convention so a buffer can be reused
+func (bt *BTree[K, V]) AppendAll(dst []KeyValue[K, V]) []KeyValue[K, V] {
+	bt.inOrderTraverseNode(bt.root, &dst)
+	return dst
+}
+
+// Degree returns the minimum degree in use after clamping
+func (bt *BTree[K, V]) Degree() int {
+	return bt.degree
 }
 
 // Height returns the height of the B-tree
@@ -97,54 +258,321 @@ func (bt *BTree[K, V]) Size() int {
 	return bt.getSize(bt.root)
 }
 
+// SetCompareCounting turns tallying of key comparisons on or off. Search,
+// Insert, NearestKey, Delete and DeleteAndGet add to the tally while it is
+// on. Each operation reads the setting once, counts into a local variable
+// and adds the result to the tally atomically when done, so concurrent
+// searches stay safe while counting; switching it must not race with other
+// operations. When it is off, Search does no counting work and the other
+// operations one nil check per node visited.
+func (bt *BTree[K, V]) SetCompareCounting(enabled bool) {
+	bt.counting = enabled
+}
+
+// CompareCount returns the number of key comparisons made since the last
+// call and resets the tally
+func (bt *BTree[K, V]) CompareCount() int {
+	return int(bt.comparisons.Swap(0))
+}
+
+// tally returns the counter an operation counts its comparisons in, or nil
+// when counting is off
+func (bt *BTree[K, V]) tally() *int {
+	if bt.counting {
+		return new(int)
+	}
+	return nil
+}
+
+// record adds the comparisons of a finished operation to the tally
+func (bt *BTree[K, V]) record(cmps *int) {
+	if cmps != nil {
+		bt.comparisons.Add(int64(*cmps))
+	}
+}
+
+// count adds n to cmps unless it is nil
+func count(cmps *int, n int) {
+	if cmps != nil {
+		*cmps += n
+	}
+}
+
+// findKey returns the index of the first key in node that is not less than
+// key and whether it equals key
+func (bt *BTree[K, V]) findKey(node *Node[K, V], key K) (int, bool) {
+	i := 0
+	for i < len(node.keys) && key > node.keys[i] {
+		i++
+	}
+	if i == len(node.keys) {
+		return i, false
+	}
+	return i, key == node.keys[i]
+}
+
+// probes returns the number of key comparisons findKey made in node to
+// return index i
+func probes[K Ordered, V any](node *Node[K, V], i int) int {
+	if i == len(node.keys) {
+		return i
+	}
+	// The comparison that stopped the scan plus the equality check
+	return i + 2
+}
+
 // IsEmpty checks if the B-tree is empty
 func (bt *BTree[K, V]) IsEmpty() bool {
 	return len(bt.root.keys) == 0
 }
 
+// MapValues returns a new B-tree with the same keys and degree as tree and
+// values transformed by fn. The source tree is not modified.
+func MapValues[K Ordered, V, V2 any](tree *BTree[K, V], fn func(K, V) V2) *BTree[K, V2] {
+	items := tree.InOrderTraversal()
+	keys := make([]K, len(items))
+	values := make([]V2, len(items))
+	for i, item := range items {
+		keys[i] = item.Key
+		values[i] = fn(item.Key, item.Value)
+	}
+	return buildFromSorted(tree.degree, keys, values)
+}
+
+// Number constraint for the key types NearestKey can measure distances in
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 |
+		~float32 | ~float64
+}
+
+// NearestKey returns the key of tree closest in value to key, with its value:
+// key itself if present, otherwise whichever of the greatest smaller key and
+// the least greater key is nearer, the smaller one on a tie. Both are found
+// in a single descent. It returns false only when the tree is empty.
+func NearestKey[K Number, V any](tree *BTree[K, V], key K) (K, V, bool) {
+	var below, above *Node[K, V]
+	var belowIdx, aboveIdx int
+	cmps := tree.tally()
+	defer tree.record(cmps)
+	for node := tree.root; ; {
+		i, found := tree.findKey(node, key)
+		count(cmps, probes(node, i))
+		if found {
+			return key, node.values[i], true
+		}
+		// Keys met further down lie between these two, so closer to key
+		if i > 0 {
+			below, belowIdx = node, i-1
+		}
+		if i < len(node.keys) {
+			above, aboveIdx = node, i
+		}
+		if node.isLeaf {
+			break
+		}
+		node = node.children[i]
+	}
+
+	switch {
+	case below == nil && above == nil:
+		var zeroK K
+		var zeroV V
+		return zeroK, zeroV, false
+	case above == nil || below != nil && !closer(key, above.keys[aboveIdx], below.keys[belowIdx]):
+		return below.keys[belowIdx], below.values[belowIdx], true
+	default:
+		return above.keys[aboveIdx], above.values[aboveIdx], true
+	}
+}
+
+// closer reports whether above is strictly nearer to key than below, where
+// below < key < above
+func closer[K Number](key, above, below K) bool {
+	var one K = 1
+	if one/2 != 0 {
+		return above-key < key-below
+	}
+	// Integer differences can overflow K but always fit in uint64, where
+	// wrapping subtraction gives them exactly
+	return uint64(above)-uint64(key) < uint64(key)-uint64(below)
+}
+
+// Rebuild returns a new B-tree with the same items at a different minimum
+// degree, clamped like NewBTree. The items are read in order and the new tree
+// is built bottom-up rather than by repeated inserts. The source tree is not
+// modified.
+func (bt *BTree[K, V]) Rebuild(newDegree int) *BTree[K, V] {
+	items := bt.InOrderTraversal()
+	keys := make([]K, len(items))
+	values := make([]V, len(items))
+	for i, item := range items {
+		keys[i] = item.Key
+		values[i] = item.Value
+	}
+	return buildFromSorted(max(newDegree, 2), keys, values)
+}
+
+// buildFromSorted builds a balanced B-tree from strictly increasing keys
+func buildFromSorted[K Ordered, V any](degree int, keys []K, values []V) *BTree[K, V] {
+	bt := &BTree[K, V]{degree: degree, cow: &copyOnWriteContext{}}
+
+	// Find the smallest height whose full capacity holds every key
+	height := 0
+	capacity := 2*degree - 1
+	for capacity < len(keys) {
+		height++
+		capacity = (capacity+1)*2*degree - 1
+	}
+
+	bt.root = bt.buildNode(keys, values, height, true)
+	return bt
+}
+
+// buildNode builds a subtree of the given height holding keys and values
+func (bt *BTree[K, V]) buildNode(keys []K, values []V, height int, isRoot bool) *Node[K, V] {
+	node := newNode[K, V](height == 0, bt.cow)
+	if height == 0 {
+		node.keys = append(node.keys, keys...)
+		node.values = append(node.values, values...)
+		return node
+	}
+
+	// Capacity of a full subtree one level down, plus one separator slot
+	childSlots := 1
+	for i := 0; i < height; i++ {
+		childSlots *= 2 * bt.degree
+	}
+
+	n := len(keys)
+	count := (n + childSlots) / childSlots
+	minChildren := bt.degree
+	if isRoot {
+		minChildren = 2
+	}
+	if count < minChildren {
+		count = minChildren
+	}
+
+	// Spread n+1 slots evenly: each child takes its share minus one key,
+	// and every child but the last is followed by a separator key
+	per, extra := (n+1)/count, (n+1)%count
+	start := 0
+	for i := 0; i < count; i++ {
+		size := per - 1
+		if i < extra {
+			size++
+		}
+		end := start + size
+		node.children = append(node.children, bt.buildNode(keys[start:end], values[start:end], height-1, false))
+		if i < count-1 {
+			node.keys = append(node.keys, keys[end])
+			node.values = append(node.values, values[end])
+		}
+		start = end + 1
+	}
+
+	return node
+}
+
+// Inspect returns a read-only summary of the tree structure
+func (bt *BTree[K, V]) Inspect() TreeInfo {
+	info := TreeInfo{
+		RootKeys:   len(bt.root.keys),
+		RootIsLeaf: bt.root.isLeaf,
+	}
+
+	level := []*Node[K, V]{bt.root}
+	for len(level) > 0 {
+		var next []*Node[K, V]
+		keys := 0
+		for _, node := range level {
+			keys += len(node.keys)
+			if !node.isLeaf {
+				next = append(next, node.children...)
+			}
+		}
+		info.NodesPerLevel = append(info.NodesPerLevel, len(level))
+		info.KeysPerLevel = append(info.KeysPerLevel, keys)
+		level = next
+	}
+
+	return info
+}
+
+// ShrinkToFit reallocates the slices of every node the tree owns to their
+// exact length, releasing capacity left over from deletes. Subtrees shared
+// with a snapshot are left alone, as they may be read concurrently.
+func (bt *BTree[K, V]) ShrinkToFit() {
+	bt.shrinkNode(bt.root)
+}
+
+func (bt *BTree[K, V]) shrinkNode(node *Node[K, V]) {
+	if node.cow != bt.cow {
+		return
+	}
+	node.keys = shrink(node.keys)
+	node.values = shrink(node.values)
+	node.children = shrink(node.children)
+	for _, child := range node.children {
+		bt.shrinkNode(child)
+	}
+}
+
+// shrink returns s copied into a backing array of exactly its length
+func shrink[T any](s []T) []T {
+	if cap(s) == len(s) {
+		return s
+	}
+	return append(make([]T, 0, len(s)), s...)
+}
+
 // isFull checks if a node is full
 func (bt *BTree[K, V]) isFull(node *Node[K, V]) bool {
 	return len(node.keys) == 2*bt.degree-1
 }
 
-// insertNonFull inserts into a non-full node
-func (bt *BTree[K, V]) insertNonFull(node *Node[K, V], key K, value V) {
-	i := len(node.keys) - 1
+// insertNonFull inserts into a non-full node, updating the value in place
+// if the key is already present
+func (bt *BTree[K, V]) insertNonFull(node *Node[K, V], key K, value V, cmps *int) {
+	i, found := bt.findKey(node, key)
+	count(cmps, probes(node, i))
+	if found {
+		node.values[i] = value
+		return
+	}
 
 	if node.isLeaf {
-		// Insert into leaf node
+		// Insert into leaf node at position i
 		node.keys = append(node.keys, key)
 		node.values = append(node.values, value)
-
-		// Shift elements to maintain sorted order
-		for i >= 0 && node.keys[i] > key {
-			node.keys[i+1] = node.keys[i]
-			node.values[i+1] = node.values[i]
-			i--
-		}
-		node.keys[i+1] = key
-		node.values[i+1] = value
+		copy(node.keys[i+1:], node.keys[i:])
+		copy(node.values[i+1:], node.values[i:])
+		node.keys[i] = key
+		node.values[i] = value
 	} else {
-		// Find child to recurse on
-		for i >= 0 && node.keys[i] > key {
-			i--
-		}
-		i++
-
+		// Recurse on child i
 		if bt.isFull(node.children[i]) {
 			bt.splitChild(node, i)
+			// The promoted middle key may be the one being inserted
+			count(cmps, 1)
+			if node.keys[i] == key {
+				node.values[i] = value
+				return
+			}
+			count(cmps, 1)
 			if node.keys[i] < key {
 				i++
 			}
 		}
-		bt.insertNonFull(node.children[i], key, value)
+		bt.insertNonFull(bt.mutableChild(node, i), key, value, cmps)
 	}
 }
 
 // splitChild splits a full child node
 func (bt *BTree[K, V]) splitChild(parent *Node[K, V], index int) {
-	fullChild := parent.children[index]
-	newChild := newNode[K, V](fullChild.isLeaf)
+	fullChild := bt.mutableChild(parent, index)
+	newChild := newNode[K, V](fullChild.isLeaf, bt.cow)
 
 	mid := bt.degree - 1
 
@@ -181,89 +609,90 @@ func (bt *BTree[K, V]) splitChild(parent *Node[K, V], index int) {
 	parent.values[index] = midValue
 }
 
-// searchNode searches for a key in a node
+// searchNode searches for a key in the subtree rooted at node, descending
+// in a loop rather than recursively
 func (bt *BTree[K, V]) searchNode(node *Node[K, V], key K) (V, bool) {
-	var zero V
-	i := 0
-
-	// Find the first key greater than or equal to key
-	for i < len(node.keys) && key > node.keys[i] {
-		i++
-	}
+	for {
+		// Find the first key greater than or equal to key
+		i, found := bt.findKey(node, key)
+		if found {
+			return node.values[i], true
+		}
 
-	// If found
-	if i < len(node.keys) && key == node.keys[i] {
-		return node.values[i], true
-	}
+		// If leaf node, key doesn't exist
+		if node.isLeaf {
+			var zero V
+			return zero, false
+		}
 
-	// If leaf node, key doesn't exist
-	if node.isLeaf {
-		return zero, false
+		node = node.children[i]
 	}
-
-	// Recurse on appropriate child
-	return bt.searchNode(node.children[i], key)
 }
 
-// deleteFromNode deletes a key from a node
-func (bt *BTree[K, V]) deleteFromNode(node *Node[K, V], key K) bool {
-	i := 0
-
-	// Find the index of the key or the child that should contain the key
-	for i < len(node.keys) && key > node.keys[i] {
-		i++
-	}
+// deleteFromNode deletes a key from the subtree rooted at node and returns
+// its value. It works in a single top-down pass: before descending into a
+// child it makes sure the child can lose a key, borrowing from or merging
+// with a sibling if not, so the removal never has to climb back up and
+// nothing is searched twice. Parent pointers are not needed, which keeps
+// nodes shareable between copy-on-write clones.
+func (bt *BTree[K, V]) deleteFromNode(node *Node[K, V], key K, cmps *int) (V, bool) {
+	var value V
+	haveValue := false // value holds the deleted key's value, read before it was overwritten
+	merged := -1       // position of key in node when a merge just put it there
+	for {
+		i, found := merged, true
+		if merged < 0 {
+			i, found = bt.findKey(node, key)
+			count(cmps, probes(node, i))
+		}
+		merged = -1
 
-	if i < len(node.keys) && key == node.keys[i] {
-		// Key found in this node
 		if node.isLeaf {
-			// Delete from leaf
+			if !found {
+				return value, false
+			}
+			if !haveValue {
+				value = node.values[i]
+			}
 			copy(node.keys[i:], node.keys[i+1:])
 			copy(node.values[i:], node.values[i+1:])
 			node.keys = node.keys[:len(node.keys)-1]
 			node.values = node.values[:len(node.values)-1]
-			return true
-		} else {
-			// Delete from internal node
-			return bt.deleteFromInternalNode(node, i)
-		}
-	} else if !node.isLeaf {
-		// Key not found in this node, recurse on child
-		if len(node.children[i].keys) >= bt.degree {
-			return bt.deleteFromNode(node.children[i], key)
-		} else {
-			// Child has minimum keys, need to handle underflow
-			bt.handleChildUnderflow(node, i)
-			return bt.deleteFromNode(node, key)
+			return value, true
 		}
-	}
-
-	return false // Key not found
-}
 
-// deleteFromInternalNode deletes a key from an internal node
-func (bt *BTree[K, V]) deleteFromInternalNode(node *Node[K, V], index int) bool {
-	key := node.keys[index]
-
-	// Case 1: Left child has at least t keys
-	if len(node.children[index].keys) >= bt.degree {
-		pred := bt.getPredecessor(node, index)
-		node.keys[index] = pred.Key
-		node.values[index] = pred.Value
-		return bt.deleteFromNode(node.children[index], pred.Key)
-	}
+		if found {
+			if !haveValue {
+				value = node.values[i]
+				haveValue = true
+			}
+			switch {
+			case len(node.children[i].keys) >= bt.degree:
+				// Overwrite with the predecessor, then delete that from the left
+				pred := bt.getPredecessor(node, i)
+				node.keys[i], node.values[i] = pred.Key, pred.Value
+				key = pred.Key
+			case len(node.children[i+1].keys) >= bt.degree:
+				succ := bt.getSuccessor(node, i)
+				node.keys[i], node.values[i] = succ.Key, succ.Value
+				key = succ.Key
+				i++
+			default:
+				// Both children are minimal: pull key down into their merge,
+				// where it lands right after the left child's keys, and
+				// delete it from there
+				merged = len(node.children[i].keys)
+				bt.mergeChildren(node, i)
+			}
+			node = bt.mutableChild(node, i)
+			continue
+		}
 
-	// Case 2: Right child has at least t keys
-	if len(node.children[index+1].keys) >= bt.degree {
-		succ := bt.getSuccessor(node, index)
-		node.keys[index] = succ.Key
-		node.values[index] = succ.Value
-		return bt.deleteFromNode(node.children[index+1], succ.Key)
+		if len(node.children[i].keys) < bt.degree {
+			i = bt.handleChildUnderflow(node, i)
+		}
+		node = bt.mutableChild(node, i)
 	}
-
-	// Case 3: Both children have t-1 keys, merge
-	bt.mergeChildren(node, index)
-	return bt.deleteFromNode(node.children[index], key)
 }
 
 // getPredecessor gets the predecessor of a key
@@ -285,32 +714,35 @@ func (bt *BTree[K, V]) getSuccessor(node *Node[K, V], index int) KeyValue[K, V]
 	return KeyValue[K, V]{Key: curr.keys[0], Value: curr.values[0]}
 }
 
-// handleChildUnderflow handles child underflow
-func (bt *BTree[K, V]) handleChildUnderflow(node *Node[K, V], index int) {
+// handleChildUnderflow gives the minimal child at index an extra key and
+// returns the index of the child now covering its keys, which moves down by
+// one when it is merged into its left sibling
+func (bt *BTree[K, V]) handleChildUnderflow(node *Node[K, V], index int) int {
 	// Try borrowing from left sibling
 	if index > 0 && len(node.children[index-1].keys) >= bt.degree {
 		bt.borrowFromLeftSibling(node, index)
-		return
+		return index
 	}
 
 	// Try borrowing from right sibling
 	if index < len(node.children)-1 && len(node.children[index+1].keys) >= bt.degree {
 		bt.borrowFromRightSibling(node, index)
-		return
+		return index
 	}
 
 	// Merge with sibling
 	if index > 0 {
 		bt.mergeChildren(node, index-1)
-	} else {
-		bt.mergeChildren(node, index)
+		return index - 1
 	}
+	bt.mergeChildren(node, index)
+	return index
 }
 
 // borrowFromLeftSibling borrows a key from left sibling
 func (bt *BTree[K, V]) borrowFromLeftSibling(parent *Node[K, V], index int) {
-	child := parent.children[index]
-	sibling := parent.children[index-1]
+	child := bt.mutableChild(parent, index)
+	sibling := bt.mutableChild(parent, index-1)
 
 	// Move parent key to child
 	child.keys = append([]K{parent.keys[index-1]}, child.keys...)
@@ -332,8 +764,8 @@ func (bt *BTree[K, V]) borrowFromLeftSibling(parent *Node[K, V], index int) {
 
 // borrowFromRightSibling borrows a key from right sibling
 func (bt *BTree[K, V]) borrowFromRightSibling(parent *Node[K, V], index int) {
-	child := parent.children[index]
-	sibling := parent.children[index+1]
+	child := bt.mutableChild(parent, index)
+	sibling := bt.mutableChild(parent, index+1)
 
 	// Move parent key to child
 	child.keys = append(child.keys, parent.keys[index])
@@ -354,7 +786,7 @@ func (bt *BTree[K, V]) borrowFromRightSibling(parent *Node[K, V], index int) {
 
 // mergeChildren merges two children
 func (bt *BTree[K, V]) mergeChildren(parent *Node[K, V], index int) {
-	child := parent.children[index]
+	child := bt.mutableChild(parent, index)
 	sibling := parent.children[index+1]
 
 	// Move parent key to child
@@ -381,19 +813,42 @@ func (bt *BTree[K, V]) mergeChildren(parent *Node[K, V], index int) {
 	parent.children = parent.children[:len(parent.children)-1]
 }
 
-// inOrderTraverseNode performs in-order traversal of a node
+// inOrderTraverseNode performs in-order traversal of a node. It keeps an
+// explicit stack of the nodes on the current path, each with the index of
+// the next key to emit, instead of recursing.
 func (bt *BTree[K, V]) inOrderTraverseNode(node *Node[K, V], result *[]KeyValue[K, V]) {
-	i := 0
-	for i < len(node.keys) {
-		if !node.isLeaf {
-			bt.inOrderTraverseNode(node.children[i], result)
+	type frame struct {
+		node *Node[K, V]
+		next int
+	}
+
+	// pushLeftmost stacks n and the first child of every node below it. The
+	// stack only outgrows buf in trees taller than any practical size.
+	var buf [32]frame
+	stack := buf[:0]
+	pushLeftmost := func(n *Node[K, V]) {
+		for {
+			stack = append(stack, frame{node: n})
+			if n.isLeaf {
+				return
+			}
+			n = n.children[0]
 		}
-		*result = append(*result, KeyValue[K, V]{Key: node.keys[i], Value: node.values[i]})
-		i++
 	}
 
-	if !node.isLeaf {
-		bt.inOrderTraverseNode(node.children[i], result)
+	pushLeftmost(node)
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.next == len(top.node.keys) {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		n, i := top.node, top.next
+		top.next++
+		*result = append(*result, KeyValue[K, V]{Key: n.keys[i], Value: n.values[i]})
+		if !n.isLeaf {
+			pushLeftmost(n.children[i+1])
+		}
 	}
 }
 
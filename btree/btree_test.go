@@ -3,6 +3,8 @@ package btree
 import (
 	"fmt"
 	"math/rand"
+	"slices"
+	"sync"
 	"testing"
 )
 
@@ -265,6 +267,34 @@ func TestDegreeOne(t *testing.T) {
 	}
 }
 
+func TestDegreeClamping(t *testing.T) {
+	tests := []struct {
+		degree   int
+		expected int
+	}{
+		{-5, 2},
+		{0, 2},
+		{1, 2},
+		{2, 2},
+		{3, 3},
+		{100, 100},
+	}
+
+	for _, tc := range tests {
+		btree := NewBTree[int, int](tc.degree)
+		if got := btree.Degree(); got != tc.expected {
+			t.Errorf("NewBTree(%d).Degree(): expected %d, got %d", tc.degree, tc.expected, got)
+		}
+
+		for i := 1; i <= 50; i++ {
+			btree.Insert(i, i)
+		}
+		if err := btree.validate(); err != nil {
+			t.Errorf("NewBTree(%d): invalid tree: %v", tc.degree, err)
+		}
+	}
+}
+
 // === Tree Structure Validation ===
 
 func (bt *BTree[K, V]) validate() error {
@@ -490,6 +520,855 @@ func TestLargeDataset(t *testing.T) {
 	}
 }
 
+// === Map ===
+
+func (bt *BTree[K, V]) leafDepths(node *Node[K, V], depth int, depths map[int]bool) {
+	if node.isLeaf {
+		depths[depth] = true
+		return
+	}
+	for _, child := range node.children {
+		bt.leafDepths(child, depth+1, depths)
+	}
+}
+
+func TestMapValues(t *testing.T) {
+	btree := NewBTree[int, int](3)
+	for i := 1; i <= 100; i++ {
+		btree.Insert(i, i)
+	}
+
+	mapped := MapValues(btree, func(k, v int) string {
+		return fmt.Sprintf("v%d", k*v)
+	})
+
+	if mapped.Size() != 100 {
+		t.Errorf("Expected size 100, got %d", mapped.Size())
+	}
+	if mapped.degree != btree.degree {
+		t.Errorf("Expected degree %d, got %d", btree.degree, mapped.degree)
+	}
+
+	for i, item := range mapped.InOrderTraversal() {
+		key := i + 1
+		if item.Key != key || item.Value != fmt.Sprintf("v%d", key*key) {
+			t.Errorf("Unexpected item at %d: %v", i, item)
+		}
+	}
+
+	if val, found := btree.Search(10); !found || val != 10 {
+		t.Errorf("Source tree should be unchanged, got found=%v, val=%v", found, val)
+	}
+}
+
+func TestMapValuesBalanced(t *testing.T) {
+	for _, degree := range []int{2, 3, 5} {
+		for n := 0; n <= 300; n++ {
+			btree := NewBTree[int, int](degree)
+			for i := 0; i < n; i++ {
+				btree.Insert(i, i)
+			}
+
+			mapped := MapValues(btree, func(k, v int) int { return v * 2 })
+
+			if err := mapped.validate(); err != nil {
+				t.Fatalf("degree=%d n=%d: invalid tree: %v", degree, n, err)
+			}
+			depths := map[int]bool{}
+			mapped.leafDepths(mapped.root, 0, depths)
+			if len(depths) != 1 {
+				t.Fatalf("degree=%d n=%d: leaves at different depths %v", degree, n, depths)
+			}
+			if mapped.Size() != n {
+				t.Fatalf("degree=%d n=%d: expected size %d, got %d", degree, n, n, mapped.Size())
+			}
+
+			mapped.Insert(n, n)
+			mapped.Delete(0)
+			if err := mapped.validate(); err != nil {
+				t.Fatalf("degree=%d n=%d: invalid tree after updates: %v", degree, n, err)
+			}
+		}
+	}
+}
+
+// === Snapshot ===
+
+func TestSnapshotIsolation(t *testing.T) {
+	btree := NewBTree[int, int](2)
+	for i := 0; i < 100; i++ {
+		btree.Insert(i, i)
+	}
+
+	view := btree.Snapshot()
+
+	for i := 0; i < 100; i += 2 {
+		btree.Delete(i)
+	}
+	for i := 100; i < 200; i++ {
+		btree.Insert(i, i)
+	}
+
+	if view.Size() != 100 {
+		t.Errorf("Expected snapshot size 100, got %d", view.Size())
+	}
+	for i := 0; i < 100; i++ {
+		if val, found := view.Search(i); !found || val != i {
+			t.Errorf("Snapshot lost key %d: found=%v, val=%v", i, found, val)
+		}
+	}
+	if _, found := view.Search(150); found {
+		t.Error("Snapshot should not see keys inserted after it was taken")
+	}
+
+	if btree.Size() != 150 {
+		t.Errorf("Expected tree size 150, got %d", btree.Size())
+	}
+	if err := btree.validate(); err != nil {
+		t.Errorf("Invalid tree after writes: %v", err)
+	}
+}
+
+func TestSnapshotRange(t *testing.T) {
+	btree := NewBTree[int, string](3)
+	for i := 1; i <= 50; i++ {
+		btree.Insert(i, fmt.Sprintf("v%d", i))
+	}
+
+	view := btree.Snapshot()
+	btree.Delete(15)
+
+	items := view.Range(10, 20)
+	if len(items) != 11 {
+		t.Fatalf("Expected 11 items, got %d", len(items))
+	}
+	for i, item := range items {
+		if item.Key != 10+i {
+			t.Errorf("Expected key %d at position %d, got %d", 10+i, i, item.Key)
+		}
+	}
+
+	if items := view.Range(60, 70); len(items) != 0 {
+		t.Errorf("Expected no items outside key range, got %d", len(items))
+	}
+}
+
+func TestSnapshotRandomOps(t *testing.T) {
+	btree := NewBTree[int, int](2)
+	expected := make(map[int]int)
+
+	type frozen struct {
+		view *BTreeView[int, int]
+		want map[int]int
+	}
+	var snapshots []frozen
+
+	for i := 0; i < 2000; i++ {
+		key := rand.Intn(200)
+		if rand.Intn(3) == 0 {
+			if _, ok := expected[key]; ok {
+				btree.Delete(key)
+				delete(expected, key)
+			}
+		} else if _, ok := expected[key]; !ok {
+			btree.Insert(key, i)
+			expected[key] = i
+		}
+
+		if i%250 == 0 {
+			want := make(map[int]int, len(expected))
+			for k, v := range expected {
+				want[k] = v
+			}
+			snapshots = append(snapshots, frozen{btree.Snapshot(), want})
+		}
+	}
+
+	for n, s := range snapshots {
+		items := s.view.InOrderTraversal()
+		if len(items) != len(s.want) {
+			t.Errorf("Snapshot %d: expected %d items, got %d", n, len(s.want), len(items))
+		}
+		for _, item := range items {
+			if v, ok := s.want[item.Key]; !ok || v != item.Value {
+				t.Errorf("Snapshot %d: unexpected item %v", n, item)
+			}
+		}
+	}
+
+	if err := btree.validate(); err != nil {
+		t.Errorf("Invalid tree: %v", err)
+	}
+}
+
+func TestSnapshotConcurrentReads(t *testing.T) {
+	btree := NewBTree[int, int](3)
+	for i := 0; i < 1000; i++ {
+		btree.Insert(i, i)
+	}
+
+	view := btree.Snapshot()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			if val, found := view.Search(i); !found || val != i {
+				t.Errorf("Snapshot read of %d failed: found=%v, val=%v", i, found, val)
+			}
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		btree.Delete(i)
+		btree.Insert(i+1000, i)
+	}
+	wg.Wait()
+}
+
+func TestInspect(t *testing.T) {
+	btree := NewBTree[int, int](2)
+
+	info := btree.Inspect()
+	if info.RootKeys != 0 || !info.RootIsLeaf || len(info.NodesPerLevel) != 1 || info.NodesPerLevel[0] != 1 {
+		t.Errorf("Unexpected info for empty tree: %+v", info)
+	}
+
+	for i := 1; i <= 100; i++ {
+		btree.Insert(i, i)
+	}
+
+	info = btree.Inspect()
+	if info.RootIsLeaf {
+		t.Error("Root should not be a leaf after 100 inserts")
+	}
+	if len(info.NodesPerLevel) != btree.Height()+1 {
+		t.Errorf("Expected %d levels, got %d", btree.Height()+1, len(info.NodesPerLevel))
+	}
+	if info.NodesPerLevel[0] != 1 || info.KeysPerLevel[0] != info.RootKeys {
+		t.Errorf("Root level mismatch: %+v", info)
+	}
+
+	total := 0
+	for i, keys := range info.KeysPerLevel {
+		total += keys
+		if i > 0 && info.NodesPerLevel[i] != info.KeysPerLevel[i-1]+info.NodesPerLevel[i-1] {
+			t.Errorf("Level %d should have one node per child pointer above it", i)
+		}
+	}
+	if total != 100 {
+		t.Errorf("Expected 100 keys across levels, got %d", total)
+	}
+}
+
+func TestDeleteAndGet(t *testing.T) {
+	btree := NewBTree[int, string](2)
+	for i := 1; i <= 100; i++ {
+		btree.Insert(i, fmt.Sprintf("v%d", i))
+	}
+
+	for _, key := range rand.Perm(100) {
+		key++
+		value, deleted := btree.DeleteAndGet(key)
+		if !deleted || value != fmt.Sprintf("v%d", key) {
+			t.Fatalf("DeleteAndGet(%d): expected (v%d, true), got (%q, %v)", key, key, value, deleted)
+		}
+		if err := btree.validate(); err != nil {
+			t.Fatalf("Invalid tree after deleting %d: %v", key, err)
+		}
+	}
+
+	if value, deleted := btree.DeleteAndGet(1); deleted || value != "" {
+		t.Errorf("DeleteAndGet on missing key: expected (\"\", false), got (%q, %v)", value, deleted)
+	}
+}
+
+func TestDeleteMinMax(t *testing.T) {
+	for _, degree := range []int{2, 3, 5} {
+		btree := NewBTree[int, string](degree)
+		if _, _, ok := btree.DeleteMin(); ok {
+			t.Errorf("degree %d: DeleteMin on empty tree returned ok", degree)
+		}
+		if _, _, ok := btree.DeleteMax(); ok {
+			t.Errorf("degree %d: DeleteMax on empty tree returned ok", degree)
+		}
+
+		for _, k := range rand.Perm(200) {
+			btree.Insert(k, fmt.Sprintf("v%d", k))
+		}
+
+		// Drain from both ends alternately
+		lo, hi := 0, 199
+		for i := 0; lo <= hi; i++ {
+			var key, want int
+			var value string
+			var ok bool
+			if i%2 == 0 {
+				key, value, ok = btree.DeleteMin()
+				want = lo
+				lo++
+			} else {
+				key, value, ok = btree.DeleteMax()
+				want = hi
+				hi--
+			}
+			if !ok || key != want || value != fmt.Sprintf("v%d", want) {
+				t.Fatalf("degree %d: expected (%d, v%d, true), got (%d, %q, %v)", degree, want, want, key, value, ok)
+			}
+			if err := btree.validate(); err != nil {
+				t.Fatalf("degree %d: invalid tree after removing %d: %v", degree, want, err)
+			}
+			if btree.Size() != hi-lo+1 {
+				t.Fatalf("degree %d: expected size %d, got %d", degree, hi-lo+1, btree.Size())
+			}
+		}
+
+		if !btree.IsEmpty() || !btree.root.isLeaf {
+			t.Errorf("degree %d: expected an empty leaf root, got size %d", degree, btree.Size())
+		}
+	}
+}
+
+func TestDeleteMinSnapshot(t *testing.T) {
+	btree := NewBTree[int, int](2)
+	for i := 0; i < 50; i++ {
+		btree.Insert(i, i)
+	}
+	view := btree.Snapshot()
+	for i := 0; i < 25; i++ {
+		btree.DeleteMin()
+		btree.DeleteMax()
+	}
+	if view.Size() != 50 || btree.Size() != 0 {
+		t.Errorf("expected snapshot to keep 50 keys and tree to be empty, got %d and %d", view.Size(), btree.Size())
+	}
+}
+
+func TestSuggestDegree(t *testing.T) {
+	tests := []struct {
+		entries, valueSize, expected int
+	}{
+		{1000000, 8, 64},   // 4096/32 = 128 keys
+		{1000000, 0, 85},   // 4096/24 = 170 keys
+		{1000000, 1000, 2}, // 4 keys per node
+		{1000000, 4096, 2}, // values larger than a node
+		{1000000, -5, 85},  // negative size treated as 0
+		{10, 8, 5},         // capped by dataset size
+		{0, 8, 2},
+		{-1, 8, 2},
+	}
+	for _, tc := range tests {
+		if got := SuggestDegree(tc.entries, tc.valueSize); got != tc.expected {
+			t.Errorf("SuggestDegree(%d, %d) = %d, expected %d", tc.entries, tc.valueSize, got, tc.expected)
+		}
+	}
+
+	// Larger values never call for a larger degree
+	prev := SuggestDegree(1<<20, 0)
+	for size := 1; size <= 8192; size *= 2 {
+		d := SuggestDegree(1<<20, size)
+		if d > prev || d < 2 || d > 256 {
+			t.Errorf("SuggestDegree(_, %d) = %d, previous %d", size, d, prev)
+		}
+		prev = d
+	}
+}
+
+func TestInsertDuplicateKey(t *testing.T) {
+	btree := NewBTree[int, string](3)
+	btree.Insert(1, "first")
+	btree.Insert(1, "second")
+	if btree.Size() != 1 {
+		t.Errorf("Expected size 1 after inserting the same key twice, got %d", btree.Size())
+	}
+	if value, found := btree.Search(1); !found || value != "second" {
+		t.Errorf("Expected latest value \"second\", got %q, %v", value, found)
+	}
+}
+
+func TestInsertDuplicateKeysInternal(t *testing.T) {
+	for _, degree := range []int{2, 3, 5} {
+		btree := NewBTree[int, int](degree)
+		for i := 0; i < 300; i++ {
+			btree.Insert(i, i)
+		}
+		// Overwrite every key, including those held in internal nodes and
+		// those promoted by splits during the descent
+		for _, k := range rand.Perm(300) {
+			btree.Insert(k, k*10)
+			if err := btree.validate(); err != nil {
+				t.Fatalf("degree %d: invalid tree after overwriting %d: %v", degree, k, err)
+			}
+		}
+		if btree.Size() != 300 {
+			t.Errorf("degree %d: expected size 300, got %d", degree, btree.Size())
+		}
+		for i := 0; i < 300; i++ {
+			if value, found := btree.Search(i); !found || value != i*10 {
+				t.Fatalf("degree %d: Search(%d) = %d, %v; expected %d", degree, i, value, found, i*10)
+			}
+		}
+	}
+}
+
+func TestCompareCount(t *testing.T) {
+	btree := NewBTree[int, int](2)
+	btree.Insert(1, 1)
+	if n := btree.CompareCount(); n != 0 {
+		t.Errorf("expected no comparisons counted while disabled, got %d", n)
+	}
+
+	btree.SetCompareCounting(true)
+	for _, k := range []int{10, 20, 30} {
+		btree.Insert(k, k)
+	}
+	if n := btree.CompareCount(); n == 0 {
+		t.Error("expected inserts to count comparisons")
+	}
+	if n := btree.CompareCount(); n != 0 {
+		t.Errorf("expected CompareCount to reset, got %d", n)
+	}
+
+	// Inserting 30 split the full root into [1] 10 [20 30]; searching 20
+	// passes 10 in the root, then stops at and matches 20 in the leaf
+	btree.Search(20)
+	if n := btree.CompareCount(); n != 3 {
+		t.Errorf("expected 3 comparisons for Search(20), got %d", n)
+	}
+
+	// Concurrent searches each add their own count
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				btree.Search(20)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := btree.CompareCount(); n != 1200 {
+		t.Errorf("expected 1200 comparisons for 400 concurrent searches, got %d", n)
+	}
+
+	// Comparisons per search grow logarithmically with the tree size
+	perSearch := func(n int) float64 {
+		tree := NewBTree[int, int](2)
+		for i := 0; i < n; i++ {
+			tree.Insert(i, i)
+		}
+		tree.SetCompareCounting(true)
+		for i := 0; i < n; i++ {
+			tree.Search(i)
+		}
+		return float64(tree.CompareCount()) / float64(n)
+	}
+	small, large := perSearch(100), perSearch(100000)
+	if large > small*4 {
+		t.Errorf("comparisons per search grew from %.1f to %.1f for 1000x more keys", small, large)
+	}
+
+	btree.SetCompareCounting(false)
+	btree.Delete(10)
+	if n := btree.CompareCount(); n != 0 {
+		t.Errorf("expected no comparisons counted after disabling, got %d", n)
+	}
+}
+
+func TestPersistentBTree(t *testing.T) {
+	empty := NewPersistentBTree[int, int](2)
+	v1 := empty.Insert(1, 10)
+	v2 := v1.Insert(2, 20)
+	v3 := v2.Insert(1, 11)
+	v4 := v3.Delete(2)
+
+	if !empty.IsEmpty() {
+		t.Error("expected the original version to stay empty")
+	}
+	if value, _ := v1.Search(1); value != 10 || v1.Size() != 1 {
+		t.Errorf("v1 changed: Search(1) = %d, size %d", value, v1.Size())
+	}
+	if value, _ := v2.Search(1); value != 10 || v2.Size() != 2 {
+		t.Errorf("v2 changed: Search(1) = %d, size %d", value, v2.Size())
+	}
+	if value, _ := v3.Search(1); value != 11 {
+		t.Errorf("expected v3 to hold the overwrite, got %d", value)
+	}
+	if _, found := v4.Search(2); found || v3.Size() != 2 || v4.Size() != 1 {
+		t.Errorf("expected v4 without 2 and v3 unchanged, sizes %d and %d", v3.Size(), v4.Size())
+	}
+	if v4.Delete(42) != v4 {
+		t.Error("deleting a missing key should return the same version")
+	}
+}
+
+func TestPersistentBTreeRandomOps(t *testing.T) {
+	rng := rand.New(rand.NewSource(9))
+	versions := []*PersistentBTree[int, int]{NewPersistentBTree[int, int](2)}
+	expected := []map[int]int{{}}
+
+	for i := 0; i < 2000; i++ {
+		prev := versions[len(versions)-1]
+		model := make(map[int]int, len(expected[len(expected)-1]))
+		for k, v := range expected[len(expected)-1] {
+			model[k] = v
+		}
+
+		key := rng.Intn(300)
+		var next *PersistentBTree[int, int]
+		if rng.Intn(3) == 0 {
+			next = prev.Delete(key)
+			delete(model, key)
+		} else {
+			next = prev.Insert(key, i)
+			model[key] = i
+		}
+		if err := next.tree.validate(); err != nil {
+			t.Fatalf("invalid version %d: %v", i, err)
+		}
+		versions = append(versions, next)
+		expected = append(expected, model)
+	}
+
+	// Every version still holds exactly what it held when created
+	for i, version := range versions {
+		if version.Size() != len(expected[i]) {
+			t.Fatalf("version %d: size %d, expected %d", i, version.Size(), len(expected[i]))
+		}
+		for k, v := range expected[i] {
+			if got, found := version.Search(k); !found || got != v {
+				t.Fatalf("version %d: Search(%d) = %d, %v; expected %d", i, k, got, found, v)
+			}
+		}
+	}
+
+	last := versions[len(versions)-1]
+	items := last.Range(100, 150)
+	for _, item := range items {
+		if item.Key < 100 || item.Key > 150 || expected[len(expected)-1][item.Key] != item.Value {
+			t.Fatalf("unexpected range item %v", item)
+		}
+	}
+}
+
+// spareCapacity sums the unused slice capacity of node and its subtree
+func (bt *BTree[K, V]) spareCapacity(node *Node[K, V]) int {
+	spare := cap(node.keys) - len(node.keys) + cap(node.values) - len(node.values) +
+		cap(node.children) - len(node.children)
+	for _, child := range node.children {
+		spare += bt.spareCapacity(child)
+	}
+	return spare
+}
+
+func TestShrinkToFit(t *testing.T) {
+	btree := NewBTree[int, int](8)
+	for i := 0; i < 5000; i++ {
+		btree.Insert(i, i)
+	}
+	for i := 0; i < 5000; i++ {
+		if i%50 != 0 {
+			btree.Delete(i)
+		}
+	}
+	want := btree.InOrderTraversal()
+
+	btree.ShrinkToFit()
+	if spare := btree.spareCapacity(btree.root); spare != 0 {
+		t.Errorf("Expected no spare capacity after ShrinkToFit, got %d", spare)
+	}
+	if err := btree.validate(); err != nil {
+		t.Fatalf("Invalid tree after ShrinkToFit: %v", err)
+	}
+	if got := btree.InOrderTraversal(); !slices.Equal(got, want) {
+		t.Error("ShrinkToFit changed the tree contents")
+	}
+
+	// Nodes shared with a snapshot keep their slices
+	view := btree.Snapshot()
+	btree.Insert(-1, -1)
+	root := view.tree.root
+	keys := root.keys
+	btree.ShrinkToFit()
+	if &root.keys[0] != &keys[0] {
+		t.Error("ShrinkToFit reallocated a node shared with a snapshot")
+	}
+	if view.Size() != len(want) {
+		t.Errorf("Expected snapshot size %d, got %d", len(want), view.Size())
+	}
+	btree.Insert(5001, 5001)
+	if err := btree.validate(); err != nil {
+		t.Fatalf("Invalid tree after insert following ShrinkToFit: %v", err)
+	}
+}
+
+func TestTallTreeTraversal(t *testing.T) {
+	const n = 200000
+	btree := NewBTree[int, int](2)
+	for i := 0; i < n; i++ {
+		btree.Insert(i, -i)
+	}
+	if btree.Height() < 10 {
+		t.Fatalf("Expected a tall tree, got height %d", btree.Height())
+	}
+
+	items := btree.InOrderTraversal()
+	if len(items) != n {
+		t.Fatalf("Expected %d items, got %d", n, len(items))
+	}
+	for i, item := range items {
+		if item.Key != i || item.Value != -i {
+			t.Fatalf("Item %d is %v", i, item)
+		}
+	}
+	for _, key := range []int{0, 1, n / 2, n - 1} {
+		if val, found := btree.Search(key); !found || val != -key {
+			t.Errorf("Search(%d) = %v, %v", key, val, found)
+		}
+	}
+	if _, found := btree.Search(n); found {
+		t.Errorf("Search(%d) found a missing key", n)
+	}
+}
+
+func TestVersionedBTree(t *testing.T) {
+	vt := NewVersionedBTree[string, int](2)
+	vt.Insert("a", 10, 10)
+	vt.Insert("a", 30, 30)
+	vt.Insert("a", 20, 20) // out of order
+	vt.Insert("b", 5, 5)
+	vt.Insert("a", 31, 30) // replaces version 30
+
+	tests := []struct {
+		key     string
+		version uint64
+		want    int
+		found   bool
+	}{
+		{"a", 9, 0, false},
+		{"a", 10, 10, true},
+		{"a", 15, 10, true},
+		{"a", 20, 20, true},
+		{"a", 29, 20, true},
+		{"a", 30, 31, true},
+		{"a", 1000, 31, true},
+		{"b", 4, 0, false},
+		{"b", 5, 5, true},
+		{"c", 100, 0, false},
+	}
+	for _, test := range tests {
+		got, found := vt.SearchAsOf(test.key, test.version)
+		if got != test.want || found != test.found {
+			t.Errorf("SearchAsOf(%q, %d) = %d, %v; want %d, %v",
+				test.key, test.version, got, found, test.want, test.found)
+		}
+	}
+	if vt.Size() != 2 || vt.Versions("a") != 3 {
+		t.Errorf("Expected 2 keys and 3 versions of a, got %d and %d", vt.Size(), vt.Versions("a"))
+	}
+
+	if dropped := vt.PruneBefore(25); dropped != 1 {
+		t.Errorf("Expected PruneBefore(25) to drop 1 version, dropped %d", dropped)
+	}
+	if vt.Versions("a") != 2 || vt.Versions("b") != 1 {
+		t.Errorf("Expected 2 versions of a and 1 of b, got %d and %d", vt.Versions("a"), vt.Versions("b"))
+	}
+	for _, test := range tests {
+		if test.version < 25 {
+			continue
+		}
+		got, found := vt.SearchAsOf(test.key, test.version)
+		if got != test.want || found != test.found {
+			t.Errorf("After prune, SearchAsOf(%q, %d) = %d, %v; want %d, %v",
+				test.key, test.version, got, found, test.want, test.found)
+		}
+	}
+	if _, found := vt.SearchAsOf("a", 10); found {
+		t.Error("Expected version 10 of a to be pruned")
+	}
+}
+
+func TestAppendAll(t *testing.T) {
+	btree := NewBTree[int, string](3)
+	for i := 0; i < 100; i++ {
+		btree.Insert(i, fmt.Sprint(i))
+	}
+
+	prefix := []KeyValue[int, string]{{Key: -1, Value: "x"}}
+	got := btree.AppendAll(prefix)
+	if len(got) != 101 || got[0] != prefix[0] {
+		t.Fatalf("Expected the prefix followed by 100 items, got %d items", len(got))
+	}
+	if !slices.Equal(got[1:], btree.InOrderTraversal()) {
+		t.Error("AppendAll differs from InOrderTraversal")
+	}
+
+	buf := make([]KeyValue[int, string], 0, 100)
+	allocs := testing.AllocsPerRun(10, func() {
+		buf = btree.AppendAll(buf[:0])
+	})
+	if allocs != 0 {
+		t.Errorf("Expected no allocations reusing a large enough buffer, got %v", allocs)
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	btree := NewBTree[int, string](4)
+	for i := 0; i < 3000; i++ {
+		btree.Insert(i*7%3001, fmt.Sprint(i))
+	}
+	want := btree.InOrderTraversal()
+
+	for _, degree := range []int{32, 2, 4, 0} {
+		rebuilt := btree.Rebuild(degree)
+		if wantDegree := max(degree, 2); rebuilt.Degree() != wantDegree {
+			t.Errorf("Rebuild(%d): expected degree %d, got %d", degree, wantDegree, rebuilt.Degree())
+		}
+		if err := rebuilt.validate(); err != nil {
+			t.Fatalf("Rebuild(%d): invalid tree: %v", degree, err)
+		}
+		if got := rebuilt.InOrderTraversal(); !slices.Equal(got, want) {
+			t.Errorf("Rebuild(%d) changed the contents", degree)
+		}
+		rebuilt.Insert(-1, "new")
+		if err := rebuilt.validate(); err != nil {
+			t.Fatalf("Rebuild(%d): invalid tree after insert: %v", degree, err)
+		}
+	}
+
+	if btree.Size() != len(want) || btree.Degree() != 4 {
+		t.Error("Rebuild modified the source tree")
+	}
+	if empty := NewBTree[int, int](3).Rebuild(8); !empty.IsEmpty() || empty.validate() != nil {
+		t.Error("Rebuild of an empty tree should give a valid empty tree")
+	}
+}
+
+func TestSmallestDegreeDeleteToEmpty(t *testing.T) {
+	// Degrees below 2 are clamped, so all of these run the smallest real
+	// configuration: at most 3 keys and, below the root, at least 1 per node
+	for _, degree := range []int{0, 1, 2} {
+		for seed := int64(0); seed < 5; seed++ {
+			btree := NewBTree[int, int](degree)
+			rng := rand.New(rand.NewSource(seed))
+			keys := rng.Perm(200)
+			for _, k := range keys {
+				btree.Insert(k, k)
+				if err := btree.validate(); err != nil {
+					t.Fatalf("degree %d, seed %d: after Insert(%d): %v", degree, seed, k, err)
+				}
+			}
+
+			rng.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+			for i, k := range keys {
+				if !btree.Delete(k) {
+					t.Fatalf("degree %d, seed %d: Delete(%d) found nothing", degree, seed, k)
+				}
+				if err := btree.validate(); err != nil {
+					t.Fatalf("degree %d, seed %d: after Delete(%d): %v", degree, seed, k, err)
+				}
+				if btree.Size() != len(keys)-i-1 {
+					t.Fatalf("degree %d, seed %d: Size() = %d, want %d", degree, seed, btree.Size(), len(keys)-i-1)
+				}
+			}
+			if _, ok := btree.Search(keys[0]); ok {
+				t.Errorf("degree %d, seed %d: emptied tree still finds keys", degree, seed)
+			}
+		}
+	}
+}
+
+func TestNearestKey(t *testing.T) {
+	empty := NewBTree[int, string](2)
+	if _, _, ok := NearestKey(empty, 5); ok {
+		t.Error("Expected false for an empty tree")
+	}
+
+	btree := NewBTree[int, string](2)
+	for _, k := range []int{10, 20, 30, 45, 100} {
+		btree.Insert(k, fmt.Sprint("v", k))
+	}
+	tests := []struct{ query, want int }{
+		{20, 20},
+		{-5, 10},
+		{500, 100},
+		{24, 20},
+		{26, 30},
+		{25, 20}, // tie goes to the smaller key
+		{40, 45},
+		{72, 45},
+		{73, 100},
+	}
+	for _, tt := range tests {
+		k, v, ok := NearestKey(btree, tt.query)
+		if !ok || k != tt.want || v != fmt.Sprint("v", tt.want) {
+			t.Errorf("NearestKey(%d) = %d, %q, %v; want %d", tt.query, k, v, ok, tt.want)
+		}
+	}
+
+	// Distances that overflow the key type
+	small := NewBTree[int8, int](2)
+	small.Insert(-128, 0)
+	small.Insert(127, 0)
+	if k, _, _ := NearestKey(small, 1); k != 127 {
+		t.Errorf("NearestKey(int8 1) = %d, want 127", k)
+	}
+	if k, _, _ := NearestKey(small, -1); k != -128 {
+		t.Errorf("NearestKey(int8 -1) = %d, want -128", k)
+	}
+
+	floats := NewBTree[float64, int](3)
+	for i := range 50 {
+		floats.Insert(float64(i)/2, i)
+	}
+	if k, v, _ := NearestKey(floats, 3.3); k != 3.5 || v != 7 {
+		t.Errorf("NearestKey(3.3) = %v, %d; want 3.5, 7", k, v)
+	}
+	if k, _, _ := NearestKey(floats, 3.2); k != 3 {
+		t.Errorf("NearestKey(3.2) = %v, want 3", k)
+	}
+}
+
+func TestDeleteStressWithSnapshots(t *testing.T) {
+	for _, degree := range []int{2, 3, 7} {
+		rng := rand.New(rand.NewSource(int64(degree)))
+		btree := NewBTree[int, int](degree)
+		model := map[int]int{}
+		for i := 0; i < 3000; i++ {
+			k := rng.Intn(500)
+			if rng.Intn(3) == 0 {
+				btree.Insert(k, i)
+				model[k] = i
+				continue
+			}
+
+			var view *BTreeView[int, int]
+			var before []KeyValue[int, int]
+			if i%50 == 0 {
+				view = btree.Snapshot()
+				before = btree.InOrderTraversal()
+			}
+			value, deleted := btree.DeleteAndGet(k)
+			want, present := model[k]
+			if deleted != present || value != want {
+				t.Fatalf("degree %d: DeleteAndGet(%d) = %d, %v; want %d, %v", degree, k, value, deleted, want, present)
+			}
+			delete(model, k)
+			if err := btree.validate(); err != nil {
+				t.Fatalf("degree %d: invalid tree after deleting %d: %v", degree, k, err)
+			}
+			if view != nil && !slices.Equal(view.Range(-1, 1000), before) {
+				t.Fatalf("degree %d: delete of %d changed an earlier snapshot", degree, k)
+			}
+		}
+		if btree.Size() != len(model) {
+			t.Errorf("degree %d: Size() = %d, want %d", degree, btree.Size(), len(model))
+		}
+	}
+}
+
 // === Benchmarks ===
 
 func BenchmarkBTreeInsertSequential(b *testing.B) {
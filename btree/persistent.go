@@ -0,0 +1,80 @@
+package btree
+
+// PersistentBTree is an immutable B-tree. Insert and Delete leave the
+// receiver untouched and return a new version that shares every unchanged
+// subtree with it, copying only the nodes on the path to the modified key.
+//
+// Compared with the mutable BTree, every update allocates O(height) nodes of
+// up to 2*degree-1 keys each instead of editing in place, so writes are
+// slower and produce more garbage. In exchange, keeping an old version costs
+// only the nodes that differ from newer ones, which makes cheap undo stacks
+// and consistent readers possible. Reads cost the same as on a BTree.
+type PersistentBTree[K Ordered, V any] struct {
+	tree *BTree[K, V]
+}
+
+// NewPersistentBTree creates an empty persistent B-tree with the specified
+// minimum degree, clamped like NewBTree
+func NewPersistentBTree[K Ordered, V any](degree int) *PersistentBTree[K, V] {
+	return &PersistentBTree[K, V]{tree: NewBTree[K, V](degree)}
+}
+
+// next returns a mutable tree sharing all nodes with this version. It owns
+// none of them, so every node it modifies is copied first.
+func (p *PersistentBTree[K, V]) next() *BTree[K, V] {
+	return &BTree[K, V]{
+		root:   p.tree.root,
+		degree: p.tree.degree,
+		cow:    &copyOnWriteContext{},
+	}
+}
+
+// Insert returns a new version with key set to value
+func (p *PersistentBTree[K, V]) Insert(key K, value V) *PersistentBTree[K, V] {
+	tree := p.next()
+	tree.Insert(key, value)
+	return &PersistentBTree[K, V]{tree: tree}
+}
+
+// Delete returns a new version without key, or the receiver itself if key
+// is not present
+func (p *PersistentBTree[K, V]) Delete(key K) *PersistentBTree[K, V] {
+	if _, found := p.tree.Search(key); !found {
+		return p
+	}
+	tree := p.next()
+	tree.Delete(key)
+	return &PersistentBTree[K, V]{tree: tree}
+}
+
+// Search searches for a key in this version
+func (p *PersistentBTree[K, V]) Search(key K) (V, bool) {
+	return p.tree.Search(key)
+}
+
+// Range returns all key-value pairs with start <= key <= end in sorted order
+func (p *PersistentBTree[K, V]) Range(start, end K) []KeyValue[K, V] {
+	var result []KeyValue[K, V]
+	p.tree.rangeNode(p.tree.root, start, end, &result)
+	return result
+}
+
+// InOrderTraversal returns all key-value pairs in this version in sorted order
+func (p *PersistentBTree[K, V]) InOrderTraversal() []KeyValue[K, V] {
+	return p.tree.InOrderTraversal()
+}
+
+// Size returns the total number of keys in this version
+func (p *PersistentBTree[K, V]) Size() int {
+	return p.tree.Size()
+}
+
+// Height returns the height of this version
+func (p *PersistentBTree[K, V]) Height() int {
+	return p.tree.Height()
+}
+
+// IsEmpty checks if this version is empty
+func (p *PersistentBTree[K, V]) IsEmpty() bool {
+	return p.tree.IsEmpty()
+}
@@ -0,0 +1,71 @@
+package btree
+
+// BTreeView is a read-only view of a B-tree as it was when the snapshot was
+// taken. It shares nodes with the source tree; the source clones any shared
+// node before modifying it, so later writes never show through the view.
+type BTreeView[K Ordered, V any] struct {
+	tree *BTree[K, V]
+}
+
+// Snapshot returns a read-only view of the current contents of the B-tree.
+// Taking a snapshot is O(1); the cost of copying is paid lazily by later
+// writes, one node per modified path.
+func (bt *BTree[K, V]) Snapshot() *BTreeView[K, V] {
+	frozen := &BTree[K, V]{
+		root:   bt.root,
+		degree: bt.degree,
+		cow:    bt.cow,
+	}
+	// Every existing node now belongs to the frozen tree
+	bt.cow = &copyOnWriteContext{}
+	return &BTreeView[K, V]{tree: frozen}
+}
+
+// Search searches for a key in the view
+func (v *BTreeView[K, V]) Search(key K) (V, bool) {
+	return v.tree.Search(key)
+}
+
+// Range returns all key-value pairs with start <= key <= end in sorted order
+func (v *BTreeView[K, V]) Range(start, end K) []KeyValue[K, V] {
+	var result []KeyValue[K, V]
+	v.tree.rangeNode(v.tree.root, start, end, &result)
+	return result
+}
+
+// InOrderTraversal returns all key-value pairs in the view in sorted order
+func (v *BTreeView[K, V]) InOrderTraversal() []KeyValue[K, V] {
+	return v.tree.InOrderTraversal()
+}
+
+// Size returns the total number of keys in the view
+func (v *BTreeView[K, V]) Size() int {
+	return v.tree.Size()
+}
+
+// IsEmpty checks if the view is empty
+func (v *BTreeView[K, V]) IsEmpty() bool {
+	return v.tree.IsEmpty()
+}
+
+// rangeNode collects the pairs of a subtree that fall within [start, end]
+func (bt *BTree[K, V]) rangeNode(node *Node[K, V], start, end K, result *[]KeyValue[K, V]) {
+	i := 0
+	for i < len(node.keys) && node.keys[i] < start {
+		i++
+	}
+
+	for ; i < len(node.keys); i++ {
+		if !node.isLeaf {
+			bt.rangeNode(node.children[i], start, end, result)
+		}
+		if node.keys[i] > end {
+			return
+		}
+		*result = append(*result, KeyValue[K, V]{Key: node.keys[i], Value: node.values[i]})
+	}
+
+	if !node.isLeaf {
+		bt.rangeNode(node.children[i], start, end, result)
+	}
+}
@@ -0,0 +1,85 @@
+package btree
+
+import (
+	"cmp"
+	"slices"
+	"sort"
+)
+
+// VersionedBTree keeps the history of every key: each key maps to its
+// values sorted by version, so a lookup can ask for the value as of any
+// version. Nothing is discarded on its own; memory grows with every Insert
+// of a new version, and PruneBefore has to be called to drop history that is
+// no longer needed.
+type VersionedBTree[K Ordered, V any] struct {
+	tree *BTree[K, []versionedValue[V]]
+}
+
+// versionedValue is one entry in the history of a key
+type versionedValue[V any] struct {
+	version uint64
+	value   V
+}
+
+// NewVersionedBTree creates an empty versioned B-tree with the specified
+// minimum degree, clamped like NewBTree
+func NewVersionedBTree[K Ordered, V any](degree int) *VersionedBTree[K, V] {
+	return &VersionedBTree[K, V]{tree: NewBTree[K, []versionedValue[V]](degree)}
+}
+
+// Insert records value as the value of key from version on. Versions may
+// arrive in any order; inserting a version the key already has replaces
+// that version's value.
+func (vt *VersionedBTree[K, V]) Insert(key K, value V, version uint64) {
+	history, _ := vt.tree.Search(key)
+	i, found := sort.Find(len(history), func(i int) int {
+		return cmp.Compare(version, history[i].version)
+	})
+	if found {
+		history[i].value = value
+		return
+	}
+	vt.tree.Insert(key, slices.Insert(history, i, versionedValue[V]{version: version, value: value}))
+}
+
+// SearchAsOf returns the value of key with the greatest version not above
+// version, or false if key had no value yet at that version
+func (vt *VersionedBTree[K, V]) SearchAsOf(key K, version uint64) (V, bool) {
+	history, _ := vt.tree.Search(key)
+	i := sort.Search(len(history), func(i int) bool { return history[i].version > version })
+	if i == 0 {
+		var zero V
+		return zero, false
+	}
+	return history[i-1].value, true
+}
+
+// PruneBefore drops the versions older than version that no lookup at
+// version or later can return. For each key the newest version not above
+// version is kept, so SearchAsOf gives the same answers as before for any
+// version at or after the cutoff; lookups before it may no longer find a
+// value. It returns the number of versions dropped.
+func (vt *VersionedBTree[K, V]) PruneBefore(version uint64) int {
+	dropped := 0
+	for _, item := range vt.tree.InOrderTraversal() {
+		history := item.Value
+		i := sort.Search(len(history), func(i int) bool { return history[i].version > version })
+		if i <= 1 {
+			continue
+		}
+		dropped += i - 1
+		vt.tree.Insert(item.Key, slices.Clone(history[i-1:]))
+	}
+	return dropped
+}
+
+// Versions returns the number of versions stored for key
+func (vt *VersionedBTree[K, V]) Versions(key K) int {
+	history, _ := vt.tree.Search(key)
+	return len(history)
+}
+
+// Size returns the number of keys, regardless of how many versions each has
+func (vt *VersionedBTree[K, V]) Size() int {
+	return vt.tree.Size()
+}
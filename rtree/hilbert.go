@@ -0,0 +1,120 @@
+package rtree
+
+import (
+	"cmp"
+	"math"
+	"slices"
+)
+
+// hilbertOrder is the number of bits per axis of the Hilbert grid
+const hilbertOrder = 32
+
+// HilbertValue returns the position of the center of r along a Hilbert curve
+// covering the whole plane. Rectangles with close values are close in space,
+// which makes the value a good sort key for grouping nearby items. Each
+// coordinate is mapped onto the grid through its float64 bit pattern, ordered
+// so that larger values map further along, so no extent is needed. Only the
+// leading 32 bits of each pattern fit, so values that agree in their sign,
+// exponent and first 20 mantissa bits share a cell; HilbertValueIn spreads
+// the grid over a known extent instead, for finer keys.
+func HilbertValue(r Rectangle) uint64 {
+	c := r.Center()
+	return hilbertIndex(orderedBits(c.X), orderedBits(c.Y))
+}
+
+// HilbertValueIn is like HilbertValue but lays the curve over extent, divided
+// into a 2^32 by 2^32 grid; centers outside it are clamped to its edge
+func HilbertValueIn(r, extent Rectangle) uint64 {
+	c := r.Center()
+	x := hilbertCoord(c.X, extent.MinX, extent.MaxX)
+	y := hilbertCoord(c.Y, extent.MinY, extent.MaxY)
+	return hilbertIndex(x, y)
+}
+
+// hilbertIndex returns the distance along the Hilbert curve of grid cell x, y
+func hilbertIndex(x, y uint64) uint64 {
+	const n = uint64(1) << hilbertOrder
+	var d uint64
+	for s := n / 2; s > 0; s /= 2 {
+		var rx, ry uint64
+		if x&s != 0 {
+			rx = 1
+		}
+		if y&s != 0 {
+			ry = 1
+		}
+		d += s * s * ((3 * rx) ^ ry)
+
+		// Rotate the quadrant so the curve below it has the right orientation
+		if ry == 0 {
+			if rx == 1 {
+				x = n - 1 - x
+				y = n - 1 - y
+			}
+			x, y = y, x
+		}
+	}
+	return d
+}
+
+// hilbertCoord maps v in [lo, hi] onto the Hilbert grid
+func hilbertCoord(v, lo, hi float64) uint64 {
+	if hi <= lo {
+		return 0
+	}
+	const maxCoord = float64(uint64(1)<<hilbertOrder - 1)
+	f := (v - lo) / (hi - lo) * maxCoord
+	return uint64(math.Max(0, math.Min(f, maxCoord)))
+}
+
+// orderedBits maps v onto the Hilbert grid through the leading bits of its
+// float64 value, flipped so that unsigned order matches numeric order
+func orderedBits(v float64) uint64 {
+	b := math.Float64bits(v)
+	if b&(1<<63) != 0 {
+		b = ^b
+	} else {
+		b |= 1 << 63
+	}
+	return b >> (64 - hilbertOrder)
+}
+
+// InsertMany adds a batch of items. Into an empty tree they are packed
+// top-down rather than inserted: the batch is cut in two again and again
+// where the halves overlap least, as a split would, and every group becomes
+// a node, so siblings overlap far less than after inserting one item at a
+// time. Into a tree that already holds items the batch is sorted by the
+// Hilbert value of the item centers over the extent of the batch and the
+// tree, then inserted one at a time, so consecutive inserts land
+// in neighbouring nodes. Bounds are snapped as by Insert; the items slice
+// itself is not reordered.
+func (t *RTree) InsertMany(items []*Item) {
+	if len(items) == 0 {
+		return
+	}
+	for _, item := range items {
+		item.Bounds = t.snap(item.Bounds)
+	}
+	if t.size == 0 {
+		t.pack(slices.Clone(items))
+		return
+	}
+
+	extent := t.root.bounds
+	for _, item := range items {
+		extent.Expand(item.Bounds)
+	}
+	type keyed struct {
+		key  uint64
+		item *Item
+	}
+	sorted := make([]keyed, len(items))
+	for i, item := range items {
+		sorted[i] = keyed{HilbertValueIn(item.Bounds, extent), item}
+	}
+	slices.SortStableFunc(sorted, func(a, b keyed) int { return cmp.Compare(a.key, b.key) })
+	for _, k := range sorted {
+		t.size++
+		t.insertItem(k.item)
+	}
+}
@@ -0,0 +1,99 @@
+package rtree
+
+import (
+	"cmp"
+	"math"
+	"slices"
+)
+
+// pack builds the tree from items top-down, replacing the root. Each node's
+// items are split in two, again and again, along whichever axis and at
+// whichever position leaves the two halves overlapping least, until every
+// group fits in one child subtree. Every node ends up between half full and
+// full, so the minimum entry count always holds.
+func (t *RTree) pack(items []*Item) {
+	capacity := t.maxEntries
+	for capacity < len(items) {
+		capacity *= t.maxEntries
+	}
+	t.root = t.packNode(items, capacity)
+	t.size = len(items)
+}
+
+// packNode builds a subtree of items, which number at most capacity, the
+// number of items a subtree of its height holds when full
+func (t *RTree) packNode(items []*Item, capacity int) *Node {
+	if capacity == t.maxEntries {
+		leaf := t.newNode(true)
+		leaf.items = append(leaf.items, items...)
+		t.updateBounds(leaf)
+		return leaf
+	}
+
+	sub := capacity / t.maxEntries
+	node := t.newNode(false)
+	groups := (len(items) + sub - 1) / sub
+	for _, group := range t.partition(items, sub, groups, nil) {
+		child := t.packNode(group, sub)
+		child.parent = node
+		node.children = append(node.children, child)
+	}
+	t.updateBounds(node)
+	return node
+}
+
+// partition divides items into groups of between size/2 and size items each,
+// appending them to out. It cuts items in two where the halves overlap least,
+// breaking ties by area as splitNode does, and recurses into both halves.
+func (t *RTree) partition(items []*Item, size, groups int, out [][]*Item) [][]*Item {
+	if groups == 1 {
+		return append(out, items)
+	}
+	half := size / 2
+	left, right := groups/2, groups-groups/2
+	lo := max(left*half, len(items)-right*size)
+	hi := min(left*size, len(items)-right*half)
+
+	bestAxis, bestCut := 0, lo
+	minOverlap, minArea := math.MaxFloat64, math.MaxFloat64
+	suffix := make([]Rectangle, len(items))
+	for axis := range 2 {
+		sortByMin(items, axis)
+		suffix[len(items)-1] = items[len(items)-1].Bounds
+		for i := len(items) - 2; i >= lo; i-- {
+			suffix[i] = suffix[i+1].Union(items[i].Bounds)
+		}
+		prefix := items[0].Bounds
+		for i := 1; i < lo; i++ {
+			prefix.Expand(items[i].Bounds)
+		}
+		for cut := lo; cut <= hi; cut++ {
+			if cut > lo {
+				prefix.Expand(items[cut-1].Bounds)
+			}
+			overlap := prefix.IntersectionArea(suffix[cut])
+			area := prefix.Area() + suffix[cut].Area()
+			if overlap < minOverlap || (overlap == minOverlap && area < minArea) {
+				minOverlap, minArea = overlap, area
+				bestAxis, bestCut = axis, cut
+			}
+		}
+	}
+	if bestAxis == 0 {
+		sortByMin(items, bestAxis)
+	}
+
+	out = t.partition(items[:bestCut], size, left, out)
+	return t.partition(items[bestCut:], size, right, out)
+}
+
+// sortByMin sorts items by the low edge of their bounds, along x for axis 0
+// and y otherwise
+func sortByMin(items []*Item, axis int) {
+	slices.SortStableFunc(items, func(a, b *Item) int {
+		if axis == 0 {
+			return cmp.Compare(a.Bounds.MinX, b.Bounds.MinX)
+		}
+		return cmp.Compare(a.Bounds.MinY, b.Bounds.MinY)
+	})
+}
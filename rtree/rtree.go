@@ -1,7 +1,12 @@
 package rtree
 
 import (
+	"container/heap"
+	"context"
+	"fmt"
+	"iter"
 	"math"
+	"sort"
 )
 
 // Point represents a point in 2D space
@@ -35,8 +40,26 @@ type RTree struct {
 	minEntries int
 	maxEntries int
 	size       int
+	cell       float64 // grid resolution for snapping, 0 when disabled
+	choose     ChooseStrategy
+	free       []*Node // nodes released by Clear, reused by splits
 }
 
+// ChooseStrategy selects how an insert picks the subtree to descend into
+type ChooseStrategy int
+
+const (
+	// ChooseLeastEnlargement picks the child whose bounds grow the least,
+	// breaking ties by area. This is the default.
+	ChooseLeastEnlargement ChooseStrategy = iota
+	// ChooseLeastOverlap applies the R*-tree ChooseSubtree rule: among
+	// children that are leaves it picks the one whose overlap with its
+	// siblings grows the least, breaking ties by enlargement and then area.
+	// Higher levels still use least enlargement. Inserts cost more, but
+	// leaves overlap less, so searches visit fewer nodes.
+	ChooseLeastOverlap
+)
+
 // NewRTree creates a new R-tree with specified min/max entries per node
 func NewRTree(minEntries, maxEntries int) *RTree {
 	if minEntries < 1 || minEntries > maxEntries/2 {
@@ -51,6 +74,51 @@ func NewRTree(minEntries, maxEntries int) *RTree {
 	}
 }
 
+// NewRTreeSnapped creates an R-tree that snaps every coordinate to the
+// nearest multiple of cell, both for inserted bounds and for queries. This
+// merges near-duplicate coordinates caused by floating-point jitter. Inserts
+// and updates write the snapped bounds back into the caller's Item, since the
+// tree stores the pointer itself. A cell that is not positive disables
+// snapping.
+func NewRTreeSnapped(minEntries, maxEntries int, cell float64) *RTree {
+	t := NewRTree(minEntries, maxEntries)
+	if cell > 0 {
+		t.cell = cell
+	}
+	return t
+}
+
+// SetChooseStrategy sets how later inserts choose a subtree. Items already in
+// the tree are not moved.
+func (t *RTree) SetChooseStrategy(s ChooseStrategy) {
+	t.choose = s
+}
+
+// snap rounds a rectangle to the tree's grid
+func (t *RTree) snap(r Rectangle) Rectangle {
+	if t.cell == 0 {
+		return r
+	}
+	return Rectangle{
+		MinX: t.snapValue(r.MinX),
+		MinY: t.snapValue(r.MinY),
+		MaxX: t.snapValue(r.MaxX),
+		MaxY: t.snapValue(r.MaxY),
+	}
+}
+
+// snapPoint rounds a point to the tree's grid
+func (t *RTree) snapPoint(p Point) Point {
+	if t.cell == 0 {
+		return p
+	}
+	return Point{X: t.snapValue(p.X), Y: t.snapValue(p.Y)}
+}
+
+func (t *RTree) snapValue(v float64) float64 {
+	return math.Round(v/t.cell) * t.cell
+}
+
 // NewRectangle creates a new rectangle
 func NewRectangle(minX, minY, maxX, maxY float64) Rectangle {
 	return Rectangle{
@@ -61,6 +129,19 @@ func NewRectangle(minX, minY, maxX, maxY float64) Rectangle {
 	}
 }
 
+// BoundingRectangle returns the smallest rectangle containing all points,
+// or the zero rectangle when points is empty
+func BoundingRectangle(points []Point) Rectangle {
+	if len(points) == 0 {
+		return Rectangle{}
+	}
+	r := Rectangle{points[0].X, points[0].Y, points[0].X, points[0].Y}
+	for _, p := range points[1:] {
+		r.Expand(Rectangle{p.X, p.Y, p.X, p.Y})
+	}
+	return r
+}
+
 // NewPoint creates a point as a rectangle with zero area
 func NewPoint(x, y float64) Rectangle {
 	return Rectangle{x, y, x, y}
@@ -71,6 +152,11 @@ func (r Rectangle) Area() float64 {
 	return (r.MaxX - r.MinX) * (r.MaxY - r.MinY)
 }
 
+// Center returns the centroid of a rectangle
+func (r Rectangle) Center() Point {
+	return Point{X: (r.MinX + r.MaxX) / 2, Y: (r.MinY + r.MaxY) / 2}
+}
+
 // Margin calculates the margin (perimeter) of a rectangle
 func (r Rectangle) Margin() float64 {
 	return (r.MaxX - r.MinX) + (r.MaxY - r.MinY)
@@ -82,6 +168,32 @@ func (r Rectangle) Intersects(other Rectangle) bool {
 		r.MinY <= other.MaxY && r.MaxY >= other.MinY
 }
 
+// IntersectionArea calculates the area shared by two rectangles, or 0 when
+// they do not overlap
+func (r Rectangle) IntersectionArea(other Rectangle) float64 {
+	w := math.Min(r.MaxX, other.MaxX) - math.Max(r.MinX, other.MinX)
+	h := math.Min(r.MaxY, other.MaxY) - math.Max(r.MinY, other.MinY)
+	if w <= 0 || h <= 0 {
+		return 0
+	}
+	return w * h
+}
+
+// Intersection returns the rectangle shared by r and other, and false if
+// they are disjoint. Rectangles that only touch share a rectangle of zero
+// width or height, as Intersects counts them as intersecting.
+func (r Rectangle) Intersection(other Rectangle) (Rectangle, bool) {
+	if !r.Intersects(other) {
+		return Rectangle{}, false
+	}
+	return Rectangle{
+		MinX: math.Max(r.MinX, other.MinX),
+		MinY: math.Max(r.MinY, other.MinY),
+		MaxX: math.Min(r.MaxX, other.MaxX),
+		MaxY: math.Min(r.MaxY, other.MaxY),
+	}, true
+}
+
 // Contains checks if this rectangle contains another
 func (r Rectangle) Contains(other Rectangle) bool {
 	return r.MinX <= other.MinX && r.MaxX >= other.MaxX &&
@@ -124,10 +236,185 @@ func (r Rectangle) Distance(p Point) float64 {
 	return math.Sqrt(dx*dx + dy*dy)
 }
 
-// Insert adds an item to the R-tree
+// DistanceTo calculates minimum distance between two rectangles, 0 if they
+// overlap or touch
+func (r Rectangle) DistanceTo(other Rectangle) float64 {
+	dx := math.Max(0, math.Max(r.MinX-other.MaxX, other.MinX-r.MaxX))
+	dy := math.Max(0, math.Max(r.MinY-other.MaxY, other.MinY-r.MaxY))
+	return math.Sqrt(dx*dx + dy*dy)
+}
+
+// Insert adds an item to the R-tree. The tree keeps the item pointer, so in
+// a tree created with NewRTreeSnapped it snaps the caller's item.Bounds in
+// place, and queries then see the snapped bounds.
 func (t *RTree) Insert(item *Item) {
+	item.Bounds = t.snap(item.Bounds)
+	t.size++
+	t.insertItem(item)
+}
+
+// Delete removes an item from the R-tree, matching it by pointer
+func (t *RTree) Delete(item *Item) bool {
+	leaf, index := t.findItem(t.root, item)
+	if leaf == nil {
+		return false
+	}
+
+	leaf.items = append(leaf.items[:index], leaf.items[index+1:]...)
+	t.size--
+	t.condenseTree(leaf)
+	return true
+}
+
+// Update moves an item to new bounds. If the new bounds still fit inside the
+// item's current leaf, the item is updated in place and only the bounding
+// boxes above it are recomputed; otherwise it is deleted and reinserted.
+func (t *RTree) Update(item *Item, newBounds Rectangle) bool {
+	leaf, _ := t.findItem(t.root, item)
+	if leaf == nil {
+		return false
+	}
+
+	newBounds = t.snap(newBounds)
+	if leaf.bounds.Contains(newBounds) {
+		item.Bounds = newBounds
+		t.updateBounds(leaf)
+		return true
+	}
+
+	t.Delete(item)
+	item.Bounds = newBounds
+	t.Insert(item)
+	return true
+}
+
+// findItem finds the leaf holding item and its index within that leaf
+func (t *RTree) findItem(node *Node, item *Item) (*Node, int) {
+	if !node.bounds.Contains(item.Bounds) {
+		return nil, -1
+	}
+
+	if node.isLeaf {
+		for i, it := range node.items {
+			if it == item {
+				return node, i
+			}
+		}
+		return nil, -1
+	}
+
+	for _, child := range node.children {
+		if leaf, i := t.findItem(child, item); leaf != nil {
+			return leaf, i
+		}
+	}
+	return nil, -1
+}
+
+// condenseTree removes underfull nodes on the path from leaf to the root,
+// reinserts their items and shrinks the bounding boxes along the way
+func (t *RTree) condenseTree(leaf *Node) {
+	var orphans []*Item
+
+	node := leaf
+	for node.parent != nil {
+		parent := node.parent
+		count := len(node.items)
+		if !node.isLeaf {
+			count = len(node.children)
+		}
+
+		if count < t.minEntries {
+			for i, child := range parent.children {
+				if child == node {
+					parent.children = append(parent.children[:i], parent.children[i+1:]...)
+					break
+				}
+			}
+			t.collectItems(node, &orphans)
+		} else {
+			t.recalculateBounds(node)
+		}
+		node = parent
+	}
+	t.recalculateBounds(t.root)
+
+	for !t.root.isLeaf && len(t.root.children) == 1 {
+		t.root = t.root.children[0]
+		t.root.parent = nil
+	}
+	if !t.root.isLeaf && len(t.root.children) == 0 {
+		t.root = &Node{isLeaf: true}
+	}
+
+	for _, item := range orphans {
+		t.insertItem(item)
+	}
+}
+
+// recalculateBounds recomputes the bounding box of a single node
+func (t *RTree) recalculateBounds(node *Node) {
+	node.bounds = Rectangle{}
+	if node.isLeaf {
+		for i, item := range node.items {
+			if i == 0 {
+				node.bounds = item.Bounds
+			} else {
+				node.bounds.Expand(item.Bounds)
+			}
+		}
+	} else {
+		for i, child := range node.children {
+			if i == 0 {
+				node.bounds = child.bounds
+			} else {
+				node.bounds.Expand(child.bounds)
+			}
+		}
+	}
+}
+
+// collectItems gathers every item stored in a subtree
+func (t *RTree) collectItems(node *Node, items *[]*Item) {
+	if node.isLeaf {
+		*items = append(*items, node.items...)
+		return
+	}
+	for _, child := range node.children {
+		t.collectItems(child, items)
+	}
+}
+
+// InsertWithChooser adds an item like Insert, snapping item.Bounds in place
+// the same way, but lets choose decide the path down to its leaf instead of
+// the least-enlargement rule. At each internal node choose receives the
+// bounds of the children and the item's bounds and returns the index of the
+// child to descend into; it must be in range. The candidates slice is reused
+// between calls. Splits, and reinsertion of items orphaned by later deletes,
+// still follow the tree's own policy.
+func (t *RTree) InsertWithChooser(item *Item, choose func(candidates []Rectangle, itemBounds Rectangle) int) {
+	item.Bounds = t.snap(item.Bounds)
 	t.size++
-	leaf := t.chooseLeaf(t.root, item.Bounds)
+
+	node := t.root
+	var candidates []Rectangle
+	for !node.isLeaf {
+		candidates = candidates[:0]
+		for _, child := range node.children {
+			candidates = append(candidates, child.bounds)
+		}
+		node = node.children[choose(candidates, item.Bounds)]
+	}
+	t.addToLeaf(node, item)
+}
+
+// insertItem places an item in the tree without touching the size counter
+func (t *RTree) insertItem(item *Item) {
+	t.addToLeaf(t.chooseLeaf(t.root, item.Bounds), item)
+}
+
+// addToLeaf appends item to leaf, splitting it if it overflows
+func (t *RTree) addToLeaf(leaf *Node, item *Item) {
 	leaf.items = append(leaf.items, item)
 	t.updateBounds(leaf)
 
@@ -142,6 +429,10 @@ func (t *RTree) chooseLeaf(node *Node, bounds Rectangle) *Node {
 		return node
 	}
 
+	if t.choose == ChooseLeastOverlap && node.children[0].isLeaf {
+		return t.chooseLeastOverlap(node, bounds)
+	}
+
 	var best *Node
 	minEnlargement := math.MaxFloat64
 	minArea := math.MaxFloat64
@@ -161,6 +452,38 @@ func (t *RTree) chooseLeaf(node *Node, bounds Rectangle) *Node {
 	return t.chooseLeaf(best, bounds)
 }
 
+// chooseLeastOverlap picks the leaf child of node whose overlap with its
+// siblings grows the least when extended to cover bounds
+func (t *RTree) chooseLeastOverlap(node *Node, bounds Rectangle) *Node {
+	var best *Node
+	minOverlap := math.MaxFloat64
+	minEnlargement := math.MaxFloat64
+	minArea := math.MaxFloat64
+
+	for _, child := range node.children {
+		grown := child.bounds.Union(bounds)
+		overlap := 0.0
+		for _, sibling := range node.children {
+			if sibling != child {
+				overlap += grown.IntersectionArea(sibling.bounds) - child.bounds.IntersectionArea(sibling.bounds)
+			}
+		}
+		enlargement := child.bounds.EnlargementNeeded(bounds)
+		area := child.bounds.Area()
+
+		if overlap < minOverlap ||
+			(overlap == minOverlap && enlargement < minEnlargement) ||
+			(overlap == minOverlap && enlargement == minEnlargement && area < minArea) {
+			minOverlap = overlap
+			minEnlargement = enlargement
+			minArea = area
+			best = child
+		}
+	}
+
+	return best
+}
+
 // updateBounds updates the bounding box of a node
 func (t *RTree) updateBounds(node *Node) {
 	if node.isLeaf {
@@ -188,19 +511,28 @@ func (t *RTree) updateBounds(node *Node) {
 
 // splitNode splits an overflowing node using R*-tree splitting algorithm
 func (t *RTree) splitNode(node *Node) {
-	axis := t.chooseSplitAxis(node)
-	index := t.chooseSplitIndex(node, axis)
-
-	newNode := &Node{
-		isLeaf: node.isLeaf,
-		parent: node.parent,
+	var index int
+	if t.allSameBounds(node) {
+		// Every distribution has the same overlap and area, so the R* search
+		// would just pick the smallest group; split evenly in insertion order
+		if node.isLeaf {
+			index = len(node.items) / 2
+		} else {
+			index = len(node.children) / 2
+		}
+	} else {
+		axis := t.chooseSplitAxis(node)
+		index = t.chooseSplitIndex(node, axis)
 	}
 
+	newNode := t.newNode(node.isLeaf)
+	newNode.parent = node.parent
+
 	if node.isLeaf {
-		newNode.items = append([]*Item{}, node.items[index:]...)
+		newNode.items = append(newNode.items, node.items[index:]...)
 		node.items = node.items[:index]
 	} else {
-		newNode.children = append([]*Node{}, node.children[index:]...)
+		newNode.children = append(newNode.children, node.children[index:]...)
 		node.children = node.children[:index]
 		for _, child := range newNode.children {
 			child.parent = newNode
@@ -212,10 +544,8 @@ func (t *RTree) splitNode(node *Node) {
 
 	if node.parent == nil {
 		// Create new root
-		t.root = &Node{
-			isLeaf:   false,
-			children: []*Node{node, newNode},
-		}
+		t.root = t.newNode(false)
+		t.root.children = append(t.root.children, node, newNode)
 		node.parent = t.root
 		newNode.parent = t.root
 		t.updateBounds(t.root)
@@ -229,6 +559,24 @@ func (t *RTree) splitNode(node *Node) {
 	}
 }
 
+// allSameBounds reports whether every entry of a node has identical bounds
+func (t *RTree) allSameBounds(node *Node) bool {
+	if node.isLeaf {
+		for _, item := range node.items[1:] {
+			if item.Bounds != node.items[0].Bounds {
+				return false
+			}
+		}
+		return true
+	}
+	for _, child := range node.children[1:] {
+		if child.bounds != node.children[0].bounds {
+			return false
+		}
+	}
+	return true
+}
+
 // chooseSplitAxis determines the best axis to split on
 func (t *RTree) chooseSplitAxis(node *Node) int {
 	xMargin, yMargin := 0.0, 0.0
@@ -400,98 +748,674 @@ func (t *RTree) sortNodesByMinY(nodes []*Node) {
 
 // Search finds all items that intersect with the given rectangle
 func (t *RTree) Search(bounds Rectangle) []*Item {
+	// Same walk as searchWhere, written out so the intersection tests are
+	// inlined on this hot path rather than called through a closure
+	bounds = t.snap(bounds)
 	result := []*Item{}
-	t.searchNode(t.root, bounds, &result)
+	var buf [32]*Node
+	stack := append(buf[:0], t.root)
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !node.bounds.Intersects(bounds) {
+			continue
+		}
+		if node.isLeaf {
+			for _, item := range node.items {
+				if item.Bounds.Intersects(bounds) {
+					result = append(result, item)
+				}
+			}
+		} else {
+			for i := len(node.children) - 1; i >= 0; i-- {
+				stack = append(stack, node.children[i])
+			}
+		}
+	}
 	return result
 }
 
-func (t *RTree) searchNode(node *Node, bounds Rectangle, result *[]*Item) {
-	if !node.bounds.Intersects(bounds) {
-		return
+// SearchWithCoverage is like Search but also returns the fraction of the
+// query's area covered by the results: the area of the union of their bounds,
+// clipped to the query, over the area of the query. Overlapping results are
+// counted once. A query of zero area is fully covered if any item touches it.
+// The union is computed by a sweep over the results, O(n² log n) in their
+// number.
+func (t *RTree) SearchWithCoverage(bounds Rectangle) ([]*Item, float64) {
+	items := t.Search(bounds)
+	bounds = t.snap(bounds)
+	area := bounds.Area()
+	if area == 0 {
+		if len(items) > 0 {
+			return items, 1
+		}
+		return items, 0
 	}
 
-	if node.isLeaf {
-		for _, item := range node.items {
-			if item.Bounds.Intersects(bounds) {
-				*result = append(*result, item)
-			}
-		}
-	} else {
-		for _, child := range node.children {
-			t.searchNode(child, bounds, result)
+	clipped := make([]Rectangle, 0, len(items))
+	for _, item := range items {
+		if r, _ := item.Bounds.Intersection(bounds); r.Area() > 0 {
+			clipped = append(clipped, r)
 		}
 	}
+	return items, math.Min(1, unionArea(clipped)/area)
 }
 
-// SearchPoint finds all items that contain the given point
-func (t *RTree) SearchPoint(p Point) []*Item {
-	result := []*Item{}
-	t.searchPointNode(t.root, p, &result)
+// ClippedItem is a search result together with the part of its bounds
+// inside the query
+type ClippedItem struct {
+	Item    *Item
+	Clipped Rectangle
+}
+
+// SearchClipped is like Search but pairs each item with its bounds clipped
+// to viewport, as needed to draw only the visible part of each feature
+func (t *RTree) SearchClipped(viewport Rectangle) []ClippedItem {
+	items := t.Search(viewport)
+	viewport = t.snap(viewport)
+	result := make([]ClippedItem, len(items))
+	for i, item := range items {
+		clipped, _ := item.Bounds.Intersection(viewport)
+		result[i] = ClippedItem{Item: item, Clipped: clipped}
+	}
 	return result
 }
 
-func (t *RTree) searchPointNode(node *Node, p Point, result *[]*Item) {
-	if !node.bounds.ContainsPoint(p) {
-		return
+// SearchStats describes the work done by a search
+type SearchStats struct {
+	NodesVisited  int // nodes whose bounds intersected the query, the root included
+	NodesPruned   int // nodes skipped because their bounds missed the query
+	LeavesScanned int // visited nodes that were leaves
+}
+
+// SearchStream finds the same items as Search but sends them on the returned
+// channel from a separate goroutine as they are found. Unlike Search it takes
+// a context, as the goroutine would otherwise be stuck forever on a consumer
+// that stops reading early: the channel is closed once the search is done or
+// ctx is cancelled, so such a consumer must cancel ctx. The returned function
+// reports what the search did up to that point. It blocks until the channel
+// has been closed, so calling it before the channel is drained, without
+// cancelling ctx, deadlocks. The tree must not be modified while streaming.
+func (t *RTree) SearchStream(ctx context.Context, bounds Rectangle) (<-chan *Item, func() SearchStats) {
+	bounds = t.snap(bounds)
+	ch := make(chan *Item)
+	done := make(chan struct{})
+	var stats SearchStats
+
+	go func() {
+		defer close(done)
+		defer close(ch)
+		stack := []*Node{t.root}
+		for len(stack) > 0 {
+			node := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if !node.bounds.Intersects(bounds) {
+				stats.NodesPruned++
+				continue
+			}
+			stats.NodesVisited++
+
+			if node.isLeaf {
+				stats.LeavesScanned++
+				for _, item := range node.items {
+					if !item.Bounds.Intersects(bounds) {
+						continue
+					}
+					select {
+					case ch <- item:
+					case <-ctx.Done():
+						return
+					}
+				}
+			} else {
+				for i := len(node.children) - 1; i >= 0; i-- {
+					stack = append(stack, node.children[i])
+				}
+			}
+		}
+	}()
+
+	return ch, func() SearchStats {
+		<-done
+		return stats
 	}
+}
 
-	if node.isLeaf {
-		for _, item := range node.items {
-			if item.Bounds.ContainsPoint(p) {
-				*result = append(*result, item)
+// unionArea returns the area covered by at least one of rects. It cuts the
+// plane into vertical slabs at every x edge and, in each slab, adds up the
+// merged y intervals of the rectangles spanning it.
+func unionArea(rects []Rectangle) float64 {
+	xs := make([]float64, 0, 2*len(rects))
+	for _, r := range rects {
+		xs = append(xs, r.MinX, r.MaxX)
+	}
+	sort.Float64s(xs)
+
+	var spans [][2]float64
+	total := 0.0
+	for i := 1; i < len(xs); i++ {
+		left, right := xs[i-1], xs[i]
+		if left == right {
+			continue
+		}
+		spans = spans[:0]
+		for _, r := range rects {
+			if r.MinX <= left && r.MaxX >= right {
+				spans = append(spans, [2]float64{r.MinY, r.MaxY})
 			}
 		}
-	} else {
-		for _, child := range node.children {
-			t.searchPointNode(child, p, result)
+		sort.Slice(spans, func(a, b int) bool { return spans[a][0] < spans[b][0] })
+
+		covered, end := 0.0, math.Inf(-1)
+		for _, s := range spans {
+			if s[0] > end {
+				covered += s[1] - s[0]
+				end = s[1]
+			} else if s[1] > end {
+				covered += s[1] - end
+				end = s[1]
+			}
 		}
+		total += covered * (right - left)
 	}
+	return total
 }
 
-// NearestNeighbor finds the k nearest items to a point
-func (t *RTree) NearestNeighbor(p Point, k int) []*Item {
-	type queueItem struct {
-		node     *Node
-		item     *Item
-		distance float64
+// SearchSorted finds all items that intersect with the given rectangle,
+// ordered by less. A nil less orders by MinX, then MinY, MaxX and MaxY. The
+// sort is stable, so items less considers equal keep the order Search found
+// them in, which depends on the tree's shape.
+func (t *RTree) SearchSorted(bounds Rectangle, less func(a, b *Item) bool) []*Item {
+	result := t.Search(bounds)
+	if less == nil {
+		less = lessByBounds
+	}
+	sort.SliceStable(result, func(i, j int) bool { return less(result[i], result[j]) })
+	return result
+}
+
+// SearchSortedByDistance finds all items that intersect region, ordered by
+// ascending distance from a point. Unlike NearestWithin it returns every
+// match; items at equal distance keep the order Search found them in.
+func (t *RTree) SearchSortedByDistance(region Rectangle, from Point) []*Item {
+	result := t.Search(region)
+	from = t.snapPoint(from)
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Bounds.Distance(from) < result[j].Bounds.Distance(from)
+	})
+	return result
+}
+
+func lessByBounds(a, b *Item) bool {
+	switch {
+	case a.Bounds.MinX != b.Bounds.MinX:
+		return a.Bounds.MinX < b.Bounds.MinX
+	case a.Bounds.MinY != b.Bounds.MinY:
+		return a.Bounds.MinY < b.Bounds.MinY
+	case a.Bounds.MaxX != b.Bounds.MaxX:
+		return a.Bounds.MaxX < b.Bounds.MaxX
+	default:
+		return a.Bounds.MaxY < b.Bounds.MaxY
 	}
+}
 
-	queue := []queueItem{{node: t.root, distance: t.root.bounds.Distance(p)}}
+// searchWhere returns the items whose bounds satisfy match, descending only
+// into nodes whose bounds satisfy it too, so match must hold for a node
+// whenever it holds for anything inside it. The tree is walked depth-first
+// with an explicit stack rather than recursion, visiting children in order.
+func (t *RTree) searchWhere(match func(Rectangle) bool) []*Item {
 	result := []*Item{}
+	var buf [32]*Node // room for a few levels of fanout before growing
+	stack := append(buf[:0], t.root)
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !match(node.bounds) {
+			continue
+		}
 
-	for len(queue) > 0 && len(result) < k {
-		// Find minimum distance item in queue
-		minIdx := 0
-		for i := 1; i < len(queue); i++ {
-			if queue[i].distance < queue[minIdx].distance {
-				minIdx = i
+		if node.isLeaf {
+			for _, item := range node.items {
+				if match(item.Bounds) {
+					result = append(result, item)
+				}
+			}
+		} else {
+			for i := len(node.children) - 1; i >= 0; i-- {
+				stack = append(stack, node.children[i])
 			}
 		}
+	}
+	return result
+}
+
+// SearchOverlapping finds all items whose intersection with bounds has an
+// area of at least minOverlapArea. Nodes that overlap the query by less than
+// the threshold are skipped, since nothing inside them can overlap more.
+func (t *RTree) SearchOverlapping(bounds Rectangle, minOverlapArea float64) []*Item {
+	bounds = t.snap(bounds)
+	return t.searchWhere(func(r Rectangle) bool {
+		return r.Intersects(bounds) && r.IntersectionArea(bounds) >= minOverlapArea
+	})
+}
+
+// SearchPoint finds all items that contain the given point
+func (t *RTree) SearchPoint(p Point) []*Item {
+	p = t.snapPoint(p)
+	return t.searchWhere(func(r Rectangle) bool { return r.ContainsPoint(p) })
+}
+
+// NearestNeighbor finds the k nearest items to a point, sorted by ascending
+// distance. Fewer than k items are returned when the tree holds fewer, and
+// k <= 0 yields an empty, non-nil slice.
+func (t *RTree) NearestNeighbor(p Point, k int) []*Item {
+	return t.nearestNeighbor(t.pointQuery(p, k, nil))
+}
+
+// NearestNeighborExcluding finds the k nearest items to a point, skipping
+// items for which exclude returns true
+func (t *RTree) NearestNeighborExcluding(p Point, k int, exclude func(*Item) bool) []*Item {
+	q := t.pointQuery(p, k, nil)
+	q.exclude = exclude
+	return t.nearestNeighbor(q)
+}
+
+// NearestNeighborMetric finds the k nearest items to a point under a custom
+// distance such as a weighted or Manhattan metric. Pruning relies on dist of
+// a node's bounds never exceeding dist of any rectangle inside it, so dist
+// must be a lower bound over contained rectangles; the Euclidean
+// Rectangle.Distance used by NearestNeighbor satisfies this.
+func (t *RTree) NearestNeighborMetric(p Point, k int, dist func(Rectangle, Point) float64) []*Item {
+	return t.nearestNeighbor(t.pointQuery(p, k, dist))
+}
+
+// NearestWithin finds the k items nearest to a point among those whose bounds
+// intersect region, sorted by ascending distance. Subtrees outside region are
+// pruned during the search rather than filtered afterwards.
+func (t *RTree) NearestWithin(p Point, k int, region Rectangle) []*Item {
+	q := t.pointQuery(p, k, nil)
+	region = t.snap(region)
+	q.within = &region
+	return t.nearestNeighbor(q)
+}
+
+// NearestToRect finds the k items nearest to a rectangle, sorted by ascending
+// minimum box-to-box distance. Items overlapping r are at distance 0.
+func (t *RTree) NearestToRect(r Rectangle, k int) []*Item {
+	r = t.snap(r)
+	return t.nearestNeighbor(nnQuery{k: k, distance: r.DistanceTo})
+}
+
+// NearestNeighborSeq returns an iterator over all items in ascending
+// distance from a point. Items are found lazily by the best-first search, so
+// taking the first few costs about as much as NearestNeighbor with a small k
+// and the caller can stop at any time. The tree must not be modified while
+// iterating.
+func (t *RTree) NearestNeighborSeq(p Point) iter.Seq[*Item] {
+	q := t.pointQuery(p, 0, nil)
+	return func(yield func(*Item) bool) {
+		t.bestFirst(q, nil, func(item *Item, _ float64) bool { return yield(item) })
+	}
+}
+
+// KthNearestDistance returns the distance from a point to its k-th nearest
+// item, or false if the tree holds fewer than k items or k < 1. No result
+// slice is built.
+func (t *RTree) KthNearestDistance(p Point, k int) (float64, bool) {
+	if k < 1 {
+		return 0, false
+	}
+	seen := 0
+	var kth float64
+	t.bestFirst(t.pointQuery(p, k, nil), nil, func(_ *Item, distance float64) bool {
+		seen++
+		kth = distance
+		return seen < k
+	})
+	return kth, seen == k
+}
+
+// NearestNeighborBatch runs a k-nearest search for each point, returning the
+// results in the same order as points. The search queue is allocated once and
+// reused across queries; no traversal work is shared between points.
+func (t *RTree) NearestNeighborBatch(points []Point, k int) [][]*Item {
+	results := make([][]*Item, len(points))
+	var queue nnQueue
+	for i, p := range points {
+		results[i], queue = t.nearestNeighborWithQueue(t.pointQuery(p, k, nil), queue[:0])
+	}
+	return results
+}
+
+// nnQueueItem is a pending node or item in the best-first search queue
+type nnQueueItem struct {
+	node     *Node
+	item     *Item
+	distance float64
+	seq      int // insertion order, breaks distance ties first-in first-out
+}
+
+// nnQueue is a min-heap of queue items ordered by distance, then insertion
+type nnQueue []nnQueueItem
+
+func (q nnQueue) Len() int { return len(q) }
+func (q nnQueue) Less(i, j int) bool {
+	if q[i].distance != q[j].distance {
+		return q[i].distance < q[j].distance
+	}
+	return q[i].seq < q[j].seq
+}
+func (q nnQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *nnQueue) Push(x any)   { *q = append(*q, x.(nnQueueItem)) }
+func (q *nnQueue) Pop() any {
+	old := *q
+	n := len(old)
+	x := old[n-1]
+	*q = old[:n-1]
+	return x
+}
+
+// nnQuery describes a best-first search: how many items to return, how far
+// the query is from a rectangle, which items to skip and, if within is set,
+// the region results must intersect
+type nnQuery struct {
+	k        int
+	distance func(Rectangle) float64
+	exclude  func(*Item) bool
+	within   *Rectangle
+}
+
+// pointQuery builds a query for the k items nearest to a point, measured by
+// dist or by Euclidean distance when dist is nil
+func (t *RTree) pointQuery(p Point, k int, dist func(Rectangle, Point) float64) nnQuery {
+	p = t.snapPoint(p)
+	if dist == nil {
+		return nnQuery{k: k, distance: func(r Rectangle) float64 { return r.Distance(p) }}
+	}
+	return nnQuery{k: k, distance: func(r Rectangle) float64 { return dist(r, p) }}
+}
 
-		current := queue[minIdx]
-		queue = append(queue[:minIdx], queue[minIdx+1:]...)
+func (t *RTree) nearestNeighbor(q nnQuery) []*Item {
+	result, _ := t.nearestNeighborWithQueue(q, nil)
+	return result
+}
+
+// nearestNeighborWithQueue performs a best-first search using queue as
+// scratch space and returns the grown queue so callers can reuse it
+func (t *RTree) nearestNeighborWithQueue(q nnQuery, queue nnQueue) ([]*Item, nnQueue) {
+	result := []*Item{}
+	if q.k <= 0 {
+		return result, queue
+	}
+	queue = t.bestFirst(q, queue, func(item *Item, _ float64) bool {
+		result = append(result, item)
+		return len(result) < q.k
+	})
+	return result, queue
+}
+
+// bestFirst passes items and their distances to yield in ascending distance
+// until yield returns false or the tree is exhausted, ignoring q.k. It uses
+// queue as scratch space and returns it.
+func (t *RTree) bestFirst(q nnQuery, queue nnQueue, yield func(*Item, float64) bool) nnQueue {
+	// Appending and fixing up, rather than heap.Push and heap.Pop, avoids
+	// boxing every entry in an interface
+	seq := 0
+	push := func(entry nnQueueItem) {
+		entry.seq = seq
+		seq++
+		queue = append(queue, entry)
+		heap.Fix(&queue, len(queue)-1)
+	}
+
+	if q.within != nil && !t.root.bounds.Intersects(*q.within) {
+		return queue
+	}
+	push(nnQueueItem{node: t.root, distance: q.distance(t.root.bounds)})
+
+	for len(queue) > 0 {
+		current := queue[0]
+		last := len(queue) - 1
+		queue[0] = queue[last]
+		queue = queue[:last]
+		if last > 0 {
+			heap.Fix(&queue, 0)
+		}
 
 		if current.item != nil {
-			result = append(result, current.item)
+			if !yield(current.item, current.distance) {
+				break
+			}
 			continue
 		}
 
 		if current.node.isLeaf {
 			for _, item := range current.node.items {
-				dist := item.Bounds.Distance(p)
-				queue = append(queue, queueItem{item: item, distance: dist})
+				if q.exclude != nil && q.exclude(item) {
+					continue
+				}
+				if q.within != nil && !item.Bounds.Intersects(*q.within) {
+					continue
+				}
+				push(nnQueueItem{item: item, distance: q.distance(item.Bounds)})
 			}
 		} else {
 			for _, child := range current.node.children {
-				dist := child.bounds.Distance(p)
-				queue = append(queue, queueItem{node: child, distance: dist})
+				if q.within != nil && !child.bounds.Intersects(*q.within) {
+					continue
+				}
+				push(nnQueueItem{node: child, distance: q.distance(child.bounds)})
+			}
+		}
+	}
+	return queue
+}
+
+// Join calls fn for every pair of items, x from a and y from b, whose bounds
+// intersect. Both trees are descended together and a pair of nodes is only
+// expanded when their bounds intersect, so the cost is proportional to the
+// number of pairs reported plus the node pairs visited before pruning, rather
+// than one search of b per item of a. Pairs are reported in no particular
+// order. Neither tree may be modified from fn.
+func Join(a, b *RTree, fn func(x, y *Item)) {
+	if a.size == 0 || b.size == 0 {
+		return
+	}
+	joinNodes(a.root, b.root, fn)
+}
+
+func joinNodes(na, nb *Node, fn func(x, y *Item)) {
+	if !na.bounds.Intersects(nb.bounds) {
+		return
+	}
+
+	switch {
+	case na.isLeaf && nb.isLeaf:
+		for _, x := range na.items {
+			if !x.Bounds.Intersects(nb.bounds) {
+				continue
+			}
+			for _, y := range nb.items {
+				if x.Bounds.Intersects(y.Bounds) {
+					fn(x, y)
+				}
+			}
+		}
+	case na.isLeaf:
+		for _, child := range nb.children {
+			joinNodes(na, child, fn)
+		}
+	case nb.isLeaf:
+		for _, child := range na.children {
+			joinNodes(child, nb, fn)
+		}
+	default:
+		for _, ca := range na.children {
+			for _, cb := range nb.children {
+				joinNodes(ca, cb, fn)
 			}
 		}
 	}
+}
 
+// All returns every item stored in the tree, in no particular order
+func (t *RTree) All() []*Item {
+	result := make([]*Item, 0, t.size)
+	t.collectItems(t.root, &result)
 	return result
 }
 
+// Bounds returns the smallest rectangle containing every item, read from the
+// root, or false when the tree is empty
+func (t *RTree) Bounds() (Rectangle, bool) {
+	if t.size == 0 {
+		return Rectangle{}, false
+	}
+	return t.root.bounds, true
+}
+
+// NodesAtDepth returns the bounding rectangles of the nodes at the given
+// depth, where 0 is the root and Height()-1 the leaves, in left to right
+// order. Shallow depths give a few coarse clusters and deeper ones finer
+// groupings. It returns an empty slice when the tree is empty or depth is out
+// of range.
+func (t *RTree) NodesAtDepth(depth int) []Rectangle {
+	if t.size == 0 || depth < 0 {
+		return []Rectangle{}
+	}
+
+	level := []*Node{t.root}
+	for ; depth > 0 && len(level) > 0; depth-- {
+		var next []*Node
+		for _, node := range level {
+			next = append(next, node.children...)
+		}
+		level = next
+	}
+
+	result := make([]Rectangle, len(level))
+	for i, node := range level {
+		result[i] = node.bounds
+	}
+	return result
+}
+
+// Validate checks the structure of the tree: every node other than the root
+// holds between minEntries and maxEntries entries and an internal root at
+// least two, every node's bounds are exactly the union of its contents,
+// children point back to their parent, all leaves are at the same depth and
+// the item count matches Size. It returns the first violation found.
+func (t *RTree) Validate() error {
+	count := 0
+	leafDepth := -1
+	if err := t.validateNode(t.root, 0, &leafDepth, &count); err != nil {
+		return err
+	}
+	if count != t.size {
+		return fmt.Errorf("size mismatch: counted %d items, size is %d", count, t.size)
+	}
+	return nil
+}
+
+func (t *RTree) validateNode(node *Node, depth int, leafDepth *int, count *int) error {
+	entries := len(node.items)
+	if !node.isLeaf {
+		entries = len(node.children)
+	}
+	if entries > t.maxEntries {
+		return fmt.Errorf("node has too many entries: %d > %d", entries, t.maxEntries)
+	}
+	if node != t.root && entries < t.minEntries {
+		return fmt.Errorf("non-root node has too few entries: %d < %d", entries, t.minEntries)
+	}
+	if node == t.root && node.parent != nil {
+		return fmt.Errorf("root has a parent")
+	}
+
+	var union Rectangle
+	if node.isLeaf {
+		if *leafDepth == -1 {
+			*leafDepth = depth
+		} else if *leafDepth != depth {
+			return fmt.Errorf("leaves at different depths: %d and %d", *leafDepth, depth)
+		}
+		*count += len(node.items)
+		for i, item := range node.items {
+			if i == 0 {
+				union = item.Bounds
+			} else {
+				union.Expand(item.Bounds)
+			}
+		}
+	} else {
+		if node == t.root && len(node.children) < 2 {
+			return fmt.Errorf("internal root has %d children", len(node.children))
+		}
+		for i, child := range node.children {
+			if child.parent != node {
+				return fmt.Errorf("child %d has wrong parent", i)
+			}
+			if err := t.validateNode(child, depth+1, leafDepth, count); err != nil {
+				return err
+			}
+			if i == 0 {
+				union = child.bounds
+			} else {
+				union.Expand(child.bounds)
+			}
+		}
+	}
+
+	// An empty root leaf has no contents to bound
+	if entries > 0 && union != node.bounds {
+		return fmt.Errorf("node bounds %+v differ from union of contents %+v", node.bounds, union)
+	}
+	return nil
+}
+
+// Clear removes every item while keeping the tree's settings, so the tree
+// can be refilled, as when a spatial index is rebuilt every frame. The nodes
+// of the old structure are kept and reused by the splits of later inserts
+// instead of being allocated again. Only the nodes of the tree just cleared
+// are kept: any still unused from an earlier Clear are released, so after a
+// large tree is cleared and a smaller one built, the next Clear gives the
+// surplus back. Items are owned by the caller and are not modified, so *Item
+// pointers returned by earlier queries stay valid.
+func (t *RTree) Clear() {
+	clear(t.free)
+	free := t.free[:0]
+	stack := []*Node{t.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = append(stack[:len(stack)-1], node.children...)
+
+		clear(node.items)
+		clear(node.children)
+		*node = Node{items: node.items[:0], children: node.children[:0]}
+		free = append(free, node)
+	}
+	if cap(free) > 2*len(free) {
+		// Let go of the backing array sized for a much larger tree
+		free = append([]*Node(nil), free...)
+	}
+	t.free = free
+	t.root = t.newNode(true)
+	t.size = 0
+}
+
+// newNode returns an empty node, taken from the nodes released by Clear when
+// there are any
+func (t *RTree) newNode(isLeaf bool) *Node {
+	if n := len(t.free); n > 0 {
+		node := t.free[n-1]
+		t.free[n-1] = nil
+		t.free = t.free[:n-1]
+		node.isLeaf = isLeaf
+		return node
+	}
+	return &Node{isLeaf: isLeaf}
+}
+
 // Size returns the number of items in the tree
 func (t *RTree) Size() int {
 	return t.size
@@ -1,8 +1,12 @@
 package rtree
 
 import (
+	"context"
 	"math"
+	"math/rand"
+	"slices"
 	"testing"
+	"time"
 )
 
 // TestNewRTree tests R-tree creation
@@ -134,6 +138,44 @@ func TestRectangleUnion(t *testing.T) {
 	}
 }
 
+// TestBoundingRectangle tests the MBR of a point set
+func TestBoundingRectangle(t *testing.T) {
+	tests := []struct {
+		points   []Point
+		expected Rectangle
+	}{
+		{nil, Rectangle{}},
+		{[]Point{{3, 4}}, NewRectangle(3, 4, 3, 4)},
+		{[]Point{{1, 5}, {-2, 3}, {4, -1}}, NewRectangle(-2, -1, 4, 5)},
+	}
+
+	for _, test := range tests {
+		result := BoundingRectangle(test.points)
+		if result != test.expected {
+			t.Errorf("Expected %+v, got %+v for points %v", test.expected, result, test.points)
+		}
+	}
+}
+
+// TestRectangleCenter tests rectangle centroid calculation
+func TestRectangleCenter(t *testing.T) {
+	tests := []struct {
+		rect     Rectangle
+		expected Point
+	}{
+		{NewRectangle(0, 0, 10, 10), Point{5, 5}},
+		{NewRectangle(-4, 2, 2, 8), Point{-1, 5}},
+		{NewPoint(3, 7), Point{3, 7}},
+	}
+
+	for _, test := range tests {
+		center := test.rect.Center()
+		if center != test.expected {
+			t.Errorf("Expected center %+v, got %+v for rect %+v", test.expected, center, test.rect)
+		}
+	}
+}
+
 // TestRectangleDistance tests distance calculation
 func TestRectangleDistance(t *testing.T) {
 	rect := NewRectangle(0, 0, 10, 10)
@@ -446,6 +488,1370 @@ func TestOverlappingRectangles(t *testing.T) {
 	}
 }
 
+// TestNearestNeighborExcluding tests k-nearest search skipping the query item
+func TestNearestNeighborExcluding(t *testing.T) {
+	tree := NewRTree(2, 4)
+
+	self := &Item{Bounds: NewPoint(0, 0), Data: "A"}
+	tree.Insert(self)
+	tree.Insert(&Item{Bounds: NewPoint(10, 0), Data: "B"})
+	tree.Insert(&Item{Bounds: NewPoint(5, 5), Data: "C"})
+	tree.Insert(&Item{Bounds: NewPoint(20, 20), Data: "D"})
+	tree.Insert(&Item{Bounds: NewPoint(30, 30), Data: "E"})
+
+	results := tree.NearestNeighborExcluding(Point{0, 0}, 2, func(it *Item) bool {
+		return it == self
+	})
+
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+
+	if results[0].Data.(string) != "C" || results[1].Data.(string) != "B" {
+		t.Errorf("Expected C then B, got %v then %v", results[0].Data, results[1].Data)
+	}
+
+	all := tree.NearestNeighborExcluding(Point{0, 0}, 10, func(it *Item) bool {
+		return it == self
+	})
+	if len(all) != 4 {
+		t.Errorf("Expected 4 results when excluding one of 5 items, got %d", len(all))
+	}
+}
+
+// TestDelete tests removing items
+func TestDelete(t *testing.T) {
+	tree := NewRTree(2, 4)
+
+	items := make([]*Item, 50)
+	for i := range items {
+		x := float64(i % 10 * 10)
+		y := float64(i / 10 * 10)
+		items[i] = &Item{Bounds: NewRectangle(x, y, x+5, y+5), Data: i}
+		tree.Insert(items[i])
+	}
+
+	if tree.Delete(&Item{Bounds: items[0].Bounds}) {
+		t.Error("Delete should match items by pointer")
+	}
+
+	for i, item := range items {
+		if !tree.Delete(item) {
+			t.Fatalf("Delete(%d) should succeed", i)
+		}
+		if tree.Delete(item) {
+			t.Fatalf("Second Delete(%d) should fail", i)
+		}
+		if err := tree.Validate(); err != nil {
+			t.Fatalf("Invalid tree after deleting %d: %v", i, err)
+		}
+		for _, found := range tree.Search(item.Bounds) {
+			if found == item {
+				t.Errorf("Deleted item %d still found", i)
+			}
+		}
+	}
+
+	if tree.Size() != 0 {
+		t.Errorf("Expected empty tree, got size %d", tree.Size())
+	}
+	if !tree.root.isLeaf {
+		t.Error("Root should be a leaf after deleting everything")
+	}
+}
+
+// TestDeleteRandom tests deletes interleaved with inserts
+func TestDeleteRandom(t *testing.T) {
+	tree := NewRTree(2, 6)
+	rng := rand.New(rand.NewSource(1))
+	var live []*Item
+
+	for i := 0; i < 2000; i++ {
+		if len(live) > 0 && rng.Intn(3) == 0 {
+			j := rng.Intn(len(live))
+			if !tree.Delete(live[j]) {
+				t.Fatalf("Delete of live item failed at iteration %d", i)
+			}
+			live = append(live[:j], live[j+1:]...)
+		} else {
+			x, y := rng.Float64()*100, rng.Float64()*100
+			item := &Item{Bounds: NewRectangle(x, y, x+rng.Float64()*5, y+rng.Float64()*5)}
+			tree.Insert(item)
+			live = append(live, item)
+		}
+
+		if err := tree.Validate(); err != nil {
+			t.Fatalf("Invalid tree at iteration %d: %v", i, err)
+		}
+	}
+
+	if len(tree.Search(NewRectangle(-10, -10, 200, 200))) != len(live) {
+		t.Errorf("Expected %d items in tree", len(live))
+	}
+}
+
+// TestUpdate tests moving items in place and across leaves
+func TestUpdate(t *testing.T) {
+	tree := NewRTree(2, 4)
+
+	items := make([]*Item, 40)
+	for i := range items {
+		x := float64(i * 10)
+		items[i] = &Item{Bounds: NewRectangle(x, 0, x+2, 2), Data: i}
+		tree.Insert(items[i])
+	}
+
+	// Small move that stays inside the current leaf
+	leaf, _ := tree.findItem(tree.root, items[5])
+	if !tree.Update(items[5], NewRectangle(50.5, 0.5, 51.5, 1.5)) {
+		t.Fatal("Update should succeed for a stored item")
+	}
+	if after, _ := tree.findItem(tree.root, items[5]); after != leaf {
+		t.Error("Small move should keep the item in the same leaf")
+	}
+
+	// Large move to the other end of the tree
+	if !tree.Update(items[0], NewRectangle(1000, 1000, 1001, 1001)) {
+		t.Fatal("Update should succeed for a stored item")
+	}
+
+	if err := tree.Validate(); err != nil {
+		t.Fatalf("Invalid tree after updates: %v", err)
+	}
+	if tree.Size() != len(items) {
+		t.Errorf("Expected size %d, got %d", len(items), tree.Size())
+	}
+
+	results := tree.SearchPoint(Point{1000.5, 1000.5})
+	if len(results) != 1 || results[0] != items[0] {
+		t.Errorf("Expected moved item at new location, got %v", results)
+	}
+	if len(tree.SearchPoint(Point{1, 1})) != 0 {
+		t.Error("Moved item should not be found at its old location")
+	}
+
+	if tree.Update(&Item{}, NewPoint(0, 0)) {
+		t.Error("Update of an unknown item should fail")
+	}
+}
+
+// TestNearestNeighborBatch tests that batched queries match single queries
+func TestNearestNeighborBatch(t *testing.T) {
+	tree := NewRTree(2, 6)
+	rng := rand.New(rand.NewSource(3))
+	for i := 0; i < 300; i++ {
+		tree.Insert(&Item{Bounds: NewPoint(rng.Float64()*100, rng.Float64()*100), Data: i})
+	}
+
+	points := []Point{{0, 0}, {50, 50}, {99, 1}, {25, 75}, {50, 50}}
+	batch := tree.NearestNeighborBatch(points, 4)
+
+	if len(batch) != len(points) {
+		t.Fatalf("Expected %d result sets, got %d", len(points), len(batch))
+	}
+	for i, p := range points {
+		single := tree.NearestNeighbor(p, 4)
+		if len(batch[i]) != len(single) {
+			t.Fatalf("Point %d: expected %d results, got %d", i, len(single), len(batch[i]))
+		}
+		for j := range single {
+			if batch[i][j].Bounds.Distance(p) != single[j].Bounds.Distance(p) {
+				t.Errorf("Point %d result %d: batch distance differs from single query", i, j)
+			}
+		}
+	}
+
+	if len(tree.NearestNeighborBatch(nil, 3)) != 0 {
+		t.Error("Expected no result sets for no points")
+	}
+}
+
+// TestSnappedTree tests grid snapping of inserted bounds and queries
+func TestSnappedTree(t *testing.T) {
+	tree := NewRTreeSnapped(2, 4, 0.5)
+
+	a := &Item{Bounds: NewPoint(1.0000001, 2.9999999), Data: "A"}
+	b := &Item{Bounds: NewPoint(0.9999999, 3.0000001), Data: "B"}
+	tree.Insert(a)
+	tree.Insert(b)
+
+	if a.Bounds != b.Bounds || a.Bounds != NewPoint(1, 3) {
+		t.Errorf("Expected both items snapped to (1, 3), got %+v and %+v", a.Bounds, b.Bounds)
+	}
+
+	if results := tree.SearchPoint(Point{1.1, 2.9}); len(results) != 2 {
+		t.Errorf("Expected snapped point query to find 2 items, got %d", len(results))
+	}
+	if results := tree.Search(NewRectangle(0.8, 2.8, 0.9, 2.9)); len(results) != 2 {
+		t.Errorf("Expected snapped rectangle query to find 2 items, got %d", len(results))
+	}
+
+	nearest := tree.NearestNeighbor(Point{1.2, 3.2}, 1)
+	if len(nearest) != 1 || nearest[0].Bounds.Distance(Point{1, 3}) != 0 {
+		t.Errorf("Expected nearest item at snapped location, got %v", nearest)
+	}
+
+	tree.Update(a, NewPoint(5.1, 5.1))
+	if a.Bounds != NewPoint(5, 5) {
+		t.Errorf("Expected updated bounds snapped to (5, 5), got %+v", a.Bounds)
+	}
+
+	if NewRTreeSnapped(2, 4, -1).cell != 0 {
+		t.Error("Non-positive cell should disable snapping")
+	}
+}
+
+// TestNearestNeighborEdgeCases tests k larger than size, k <= 0 and ordering
+func TestNearestNeighborEdgeCases(t *testing.T) {
+	tree := NewRTree(2, 4)
+
+	if results := tree.NearestNeighbor(Point{0, 0}, 3); results == nil || len(results) != 0 {
+		t.Errorf("Expected empty non-nil result on empty tree, got %v", results)
+	}
+
+	tree.Insert(&Item{Bounds: NewPoint(3, 0), Data: "A"})
+	tree.Insert(&Item{Bounds: NewPoint(1, 0), Data: "B"})
+	tree.Insert(&Item{Bounds: NewPoint(2, 0), Data: "C"})
+
+	results := tree.NearestNeighbor(Point{0, 0}, 10)
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results for k larger than size, got %d", len(results))
+	}
+	for i, expected := range []string{"B", "C", "A"} {
+		if results[i].Data.(string) != expected {
+			t.Errorf("Expected %s at position %d, got %v", expected, i, results[i].Data)
+		}
+	}
+
+	for _, k := range []int{0, -1} {
+		if results := tree.NearestNeighbor(Point{0, 0}, k); results == nil || len(results) != 0 {
+			t.Errorf("Expected empty non-nil result for k=%d, got %v", k, results)
+		}
+	}
+}
+
+// TestNearestNeighborSorted tests that results come back in distance order
+func TestNearestNeighborSorted(t *testing.T) {
+	tree := NewRTree(2, 5)
+	rng := rand.New(rand.NewSource(5))
+	for i := 0; i < 500; i++ {
+		x, y := rng.Float64()*100, rng.Float64()*100
+		tree.Insert(&Item{Bounds: NewRectangle(x, y, x+rng.Float64()*3, y+rng.Float64()*3)})
+	}
+
+	p := Point{40, 60}
+	results := tree.NearestNeighbor(p, 500)
+	if len(results) != 500 {
+		t.Fatalf("Expected 500 results, got %d", len(results))
+	}
+	for i := 1; i < len(results); i++ {
+		if results[i].Bounds.Distance(p) < results[i-1].Bounds.Distance(p) {
+			t.Fatalf("Results not sorted by distance at %d", i)
+		}
+	}
+}
+
+// TestAll tests enumerating every stored item exactly once
+func TestAll(t *testing.T) {
+	tree := NewRTree(2, 4)
+
+	if items := tree.All(); items == nil || len(items) != 0 {
+		t.Errorf("Expected empty non-nil result on empty tree, got %v", items)
+	}
+
+	items := make([]*Item, 100)
+	for i := range items {
+		items[i] = &Item{Bounds: NewPoint(float64(i%10), float64(i/10)), Data: i}
+		tree.Insert(items[i])
+	}
+	for i := 0; i < 100; i += 3 {
+		tree.Delete(items[i])
+	}
+
+	seen := make(map[*Item]int)
+	for _, item := range tree.All() {
+		seen[item]++
+	}
+
+	for i, item := range items {
+		want := 1
+		if i%3 == 0 {
+			want = 0
+		}
+		if seen[item] != want {
+			t.Errorf("Item %d: expected to appear %d times, got %d", i, want, seen[item])
+		}
+	}
+	if len(seen) != tree.Size() {
+		t.Errorf("Expected %d distinct items, got %d", tree.Size(), len(seen))
+	}
+}
+
+// TestNearestNeighborMetric tests k-nearest search under a custom metric
+func TestNearestNeighborMetric(t *testing.T) {
+	tree := NewRTree(2, 4)
+
+	tree.Insert(&Item{Bounds: NewPoint(3, 0), Data: "A"})
+	tree.Insert(&Item{Bounds: NewPoint(0, 2), Data: "B"})
+	tree.Insert(&Item{Bounds: NewPoint(2, 2), Data: "C"})
+	for i := 0; i < 20; i++ {
+		tree.Insert(&Item{Bounds: NewPoint(float64(50+i), float64(50+i)), Data: i})
+	}
+
+	// Weighting Y by 10 makes A nearer than B
+	weighted := func(r Rectangle, p Point) float64 {
+		dx := math.Max(0, math.Max(r.MinX-p.X, p.X-r.MaxX))
+		dy := math.Max(0, math.Max(r.MinY-p.Y, p.Y-r.MaxY))
+		return math.Sqrt(dx*dx + 100*dy*dy)
+	}
+	results := tree.NearestNeighborMetric(Point{0, 0}, 2, weighted)
+	if len(results) != 2 || results[0].Data != "A" || results[1].Data != "B" {
+		t.Errorf("Expected A then B under weighted metric, got %v", results)
+	}
+
+	// Under Manhattan distance C (4) is farther than B (2) and A (3)
+	manhattan := func(r Rectangle, p Point) float64 {
+		dx := math.Max(0, math.Max(r.MinX-p.X, p.X-r.MaxX))
+		dy := math.Max(0, math.Max(r.MinY-p.Y, p.Y-r.MaxY))
+		return dx + dy
+	}
+	results = tree.NearestNeighborMetric(Point{0, 0}, 3, manhattan)
+	if len(results) != 3 || results[0].Data != "B" || results[1].Data != "A" || results[2].Data != "C" {
+		t.Errorf("Expected B, A, C under Manhattan metric, got %v", results)
+	}
+}
+
+// TestIntersectionArea tests the overlap area of two rectangles
+func TestIntersectionArea(t *testing.T) {
+	a := NewRectangle(0, 0, 4, 4)
+	tests := []struct {
+		other Rectangle
+		want  float64
+	}{
+		{NewRectangle(2, 2, 6, 6), 4},
+		{NewRectangle(1, 1, 2, 2), 1},
+		{NewRectangle(4, 0, 8, 4), 0},
+		{NewRectangle(5, 5, 6, 6), 0},
+	}
+	for _, tt := range tests {
+		if got := a.IntersectionArea(tt.other); got != tt.want {
+			t.Errorf("IntersectionArea(%v) = %v, want %v", tt.other, got, tt.want)
+		}
+		if got := tt.other.IntersectionArea(a); got != tt.want {
+			t.Errorf("IntersectionArea is not symmetric for %v", tt.other)
+		}
+	}
+}
+
+// TestSearchOverlapping tests filtering by overlap area
+func TestSearchOverlapping(t *testing.T) {
+	tree := NewRTree(2, 4)
+
+	deep := &Item{Bounds: NewRectangle(1, 1, 5, 5), Data: "deep"}         // overlap 9
+	shallow := &Item{Bounds: NewRectangle(3.5, 0, 6, 4), Data: "shallow"} // overlap 2
+	touching := &Item{Bounds: NewRectangle(4, 0, 6, 4), Data: "touching"} // overlap 0
+	tree.Insert(deep)
+	tree.Insert(shallow)
+	tree.Insert(touching)
+	for i := 0; i < 30; i++ {
+		tree.Insert(&Item{Bounds: NewRectangle(float64(20+i), 20, float64(21+i), 21), Data: i})
+	}
+
+	query := NewRectangle(0, 0, 4, 4)
+	if got := tree.SearchOverlapping(query, 3); len(got) != 1 || got[0] != deep {
+		t.Errorf("Expected only deep item, got %v", got)
+	}
+	if got := tree.SearchOverlapping(query, 1); len(got) != 2 {
+		t.Errorf("Expected deep and shallow items, got %d", len(got))
+	}
+
+	// A zero threshold behaves like Search, including touching items
+	if got, want := len(tree.SearchOverlapping(query, 0)), len(tree.Search(query)); got != want {
+		t.Errorf("Expected %d items with zero threshold, got %d", want, got)
+	}
+
+	if got := tree.SearchOverlapping(query, 100); got == nil || len(got) != 0 {
+		t.Errorf("Expected empty non-nil result, got %v", got)
+	}
+}
+
+// searchVisits counts the nodes a Search for bounds would visit
+func (t *RTree) searchVisits(bounds Rectangle) int {
+	var visit func(*Node) int
+	visit = func(node *Node) int {
+		if !node.bounds.Intersects(bounds) {
+			return 0
+		}
+		n := 1
+		for _, child := range node.children {
+			n += visit(child)
+		}
+		return n
+	}
+	return visit(t.root)
+}
+
+// randomRectTree builds a tree of small random rectangles
+func randomRectTree(s ChooseStrategy, n int, seed int64) *RTree {
+	tree := NewRTree(4, 16)
+	tree.SetChooseStrategy(s)
+	rng := rand.New(rand.NewSource(seed))
+	for i := 0; i < n; i++ {
+		x, y := rng.Float64()*1000, rng.Float64()*1000
+		tree.Insert(&Item{Bounds: NewRectangle(x, y, x+rng.Float64()*10, y+rng.Float64()*10), Data: i})
+	}
+	return tree
+}
+
+// TestChooseLeastOverlap tests the R*-tree ChooseSubtree strategy
+func TestChooseLeastOverlap(t *testing.T) {
+	plain := randomRectTree(ChooseLeastEnlargement, 5000, 1)
+	rstar := randomRectTree(ChooseLeastOverlap, 5000, 1)
+
+	if err := rstar.Validate(); err != nil {
+		t.Fatalf("Invalid tree: %v", err)
+	}
+	if rstar.Size() != 5000 {
+		t.Errorf("Expected 5000 items, got %d", rstar.Size())
+	}
+
+	rng := rand.New(rand.NewSource(2))
+	plainVisits, rstarVisits := 0, 0
+	for i := 0; i < 200; i++ {
+		x, y := rng.Float64()*1000, rng.Float64()*1000
+		query := NewRectangle(x, y, x+20, y+20)
+		if a, b := len(plain.Search(query)), len(rstar.Search(query)); a != b {
+			t.Fatalf("Strategies disagree on %v: %d vs %d items", query, a, b)
+		}
+		plainVisits += plain.searchVisits(query)
+		rstarVisits += rstar.searchVisits(query)
+	}
+	if rstarVisits > plainVisits {
+		t.Errorf("Expected least overlap to visit no more nodes: %d > %d", rstarVisits, plainVisits)
+	}
+	t.Logf("node visits: least enlargement %d, least overlap %d", plainVisits, rstarVisits)
+}
+
+// TestBounds tests the overall extent of the tree
+func TestBounds(t *testing.T) {
+	tree := NewRTree(2, 4)
+	if _, ok := tree.Bounds(); ok {
+		t.Error("Expected no bounds for empty tree")
+	}
+
+	var items []*Item
+	for i := 0; i < 50; i++ {
+		item := &Item{Bounds: NewRectangle(float64(i), float64(-i), float64(i+1), float64(-i+2)), Data: i}
+		items = append(items, item)
+		tree.Insert(item)
+	}
+	if b, ok := tree.Bounds(); !ok || b != NewRectangle(0, -49, 50, 2) {
+		t.Errorf("Expected bounds (0,-49)-(50,2), got %v, %v", b, ok)
+	}
+
+	// Removing the extreme items shrinks the extent
+	tree.Delete(items[0])
+	tree.Delete(items[49])
+	if b, ok := tree.Bounds(); !ok || b != NewRectangle(1, -48, 49, 1) {
+		t.Errorf("Expected bounds (1,-48)-(49,1) after deletes, got %v, %v", b, ok)
+	}
+
+	for _, item := range items[1:49] {
+		tree.Delete(item)
+	}
+	if _, ok := tree.Bounds(); ok {
+		t.Error("Expected no bounds after deleting everything")
+	}
+}
+
+// linearNearestNeighbor is the original best-first search that scans the
+// whole queue for its minimum, kept as a reference for the heap version
+func (t *RTree) linearNearestNeighbor(p Point, k int) []*Item {
+	queue := []nnQueueItem{{node: t.root, distance: t.root.bounds.Distance(p)}}
+	result := []*Item{}
+	for len(queue) > 0 && len(result) < k {
+		minIdx := 0
+		for i := 1; i < len(queue); i++ {
+			if queue[i].distance < queue[minIdx].distance {
+				minIdx = i
+			}
+		}
+		current := queue[minIdx]
+		queue = append(queue[:minIdx], queue[minIdx+1:]...)
+
+		if current.item != nil {
+			result = append(result, current.item)
+		} else if current.node.isLeaf {
+			for _, item := range current.node.items {
+				queue = append(queue, nnQueueItem{item: item, distance: item.Bounds.Distance(p)})
+			}
+		} else {
+			for _, child := range current.node.children {
+				queue = append(queue, nnQueueItem{node: child, distance: child.bounds.Distance(p)})
+			}
+		}
+	}
+	return result
+}
+
+// TestNearestNeighborHeapMatchesLinear tests that the heap-based search
+// returns exactly what the linear scan did, including the order of ties
+func TestNearestNeighborHeapMatchesLinear(t *testing.T) {
+	tree := NewRTree(2, 6)
+	rng := rand.New(rand.NewSource(5))
+	// Integer coordinates produce many equal distances
+	for i := 0; i < 2000; i++ {
+		tree.Insert(&Item{Bounds: NewPoint(float64(rng.Intn(40)), float64(rng.Intn(40))), Data: i})
+	}
+
+	for i := 0; i < 100; i++ {
+		p := Point{float64(rng.Intn(50) - 5), float64(rng.Intn(50) - 5)}
+		k := rng.Intn(60) + 1
+		got := tree.NearestNeighbor(p, k)
+		want := tree.linearNearestNeighbor(p, k)
+		if len(got) != len(want) {
+			t.Fatalf("NearestNeighbor(%v, %d): got %d items, want %d", p, k, len(got), len(want))
+		}
+		for j := range got {
+			if got[j] != want[j] {
+				t.Fatalf("NearestNeighbor(%v, %d): item %d differs from linear scan", p, k, j)
+			}
+		}
+	}
+}
+
+// TestIdenticalBounds tests that many items sharing one point keep the tree
+// shallow and well filled
+func TestIdenticalBounds(t *testing.T) {
+	for _, c := range [][2]int{{2, 4}, {4, 16}} {
+		tree := NewRTree(c[0], c[1])
+		items := make([]*Item, 1000)
+		for i := range items {
+			items[i] = &Item{Bounds: NewPoint(5, 5), Data: i}
+			tree.Insert(items[i])
+		}
+
+		if err := tree.Validate(); err != nil {
+			t.Fatalf("%v: invalid tree: %v", c, err)
+		}
+		maxHeight := 1 + int(math.Ceil(math.Log(1000)/math.Log(float64(c[0]))))
+		if tree.Height() > maxHeight {
+			t.Errorf("%v: height %d exceeds logarithmic bound %d", c, tree.Height(), maxHeight)
+		}
+
+		// Even splits leave leaves at least half full on average
+		leaves := 0
+		var count func(*Node)
+		count = func(n *Node) {
+			if n.isLeaf {
+				leaves++
+			}
+			for _, child := range n.children {
+				count(child)
+			}
+		}
+		count(tree.root)
+		if avg := 1000 / leaves; avg < c[1]/2 {
+			t.Errorf("%v: average leaf holds %d items, expected at least %d", c, avg, c[1]/2)
+		}
+
+		if got := len(tree.SearchPoint(Point{5, 5})); got != 1000 {
+			t.Errorf("%v: expected 1000 items at the point, got %d", c, got)
+		}
+		for _, item := range items[:500] {
+			if !tree.Delete(item) {
+				t.Fatalf("%v: failed to delete item %v", c, item.Data)
+			}
+		}
+		if err := tree.Validate(); err != nil || tree.Size() != 500 {
+			t.Fatalf("%v: after deletes size %d, err %v", c, tree.Size(), err)
+		}
+	}
+}
+
+// TestNearestWithin tests k-nearest search restricted to a region
+func TestNearestWithin(t *testing.T) {
+	tree := NewRTree(2, 4)
+	var items []*Item
+	for x := 0; x < 20; x++ {
+		for y := 0; y < 20; y++ {
+			item := &Item{Bounds: NewPoint(float64(x), float64(y)), Data: [2]int{x, y}}
+			items = append(items, item)
+			tree.Insert(item)
+		}
+	}
+
+	// The query point lies outside the region; results must come from inside
+	region := NewRectangle(10, 10, 12, 12)
+	p := Point{0, 0}
+	results := tree.NearestWithin(p, 4, region)
+	if len(results) != 4 {
+		t.Fatalf("Expected 4 results, got %d", len(results))
+	}
+	if results[0].Data != [2]int{10, 10} {
+		t.Errorf("Expected (10,10) first, got %v", results[0].Data)
+	}
+	for i, item := range results {
+		if !item.Bounds.Intersects(region) {
+			t.Errorf("Result %v lies outside the region", item.Data)
+		}
+		if i > 0 && item.Bounds.Distance(p) < results[i-1].Bounds.Distance(p) {
+			t.Errorf("Results not sorted by distance at %d", i)
+		}
+	}
+
+	// Asking for more than the region holds returns all of it
+	if got := tree.NearestWithin(p, 100, region); len(got) != 9 {
+		t.Errorf("Expected all 9 items in the region, got %d", len(got))
+	}
+	if got := tree.NearestWithin(p, 5, NewRectangle(100, 100, 200, 200)); got == nil || len(got) != 0 {
+		t.Errorf("Expected empty non-nil result for a region with no items, got %v", got)
+	}
+}
+
+func TestRectangleDistanceTo(t *testing.T) {
+	rect := NewRectangle(0, 0, 10, 10)
+
+	tests := []struct {
+		other    Rectangle
+		expected float64
+	}{
+		{NewRectangle(5, 5, 15, 15), 0.0},    // Overlapping
+		{NewRectangle(10, 0, 20, 10), 0.0},   // Touching
+		{NewRectangle(13, 2, 20, 8), 3.0},    // Right
+		{NewRectangle(2, -8, 8, -4), 4.0},    // Bottom
+		{NewRectangle(13, 14, 20, 20), 5.0},  // Diagonal
+		{NewRectangle(-3, -4, -3, -4), 5.0},  // Degenerate point
+		{NewRectangle(-20, 2, -10, 8), 10.0}, // Left
+		{NewRectangle(-5, -5, 15, 15), 0.0},  // Containing
+	}
+
+	for _, test := range tests {
+		result := rect.DistanceTo(test.other)
+		if math.Abs(result-test.expected) > 0.0001 {
+			t.Errorf("Expected distance from rect to %v to be %.4f, got %.4f",
+				test.other, test.expected, result)
+		}
+		if back := test.other.DistanceTo(rect); math.Abs(back-result) > 0.0001 {
+			t.Errorf("DistanceTo not symmetric for %v: %.4f vs %.4f", test.other, result, back)
+		}
+	}
+}
+
+func TestNearestToRect(t *testing.T) {
+	tree := randomRectTree(ChooseLeastEnlargement, 2000, 3)
+	all := tree.All()
+
+	query := NewRectangle(40, 40, 55, 48)
+	for _, k := range []int{1, 5, 30} {
+		results := tree.NearestToRect(query, k)
+		if len(results) != k {
+			t.Fatalf("NearestToRect(k=%d) returned %d items", k, len(results))
+		}
+
+		dists := make([]float64, len(all))
+		for i, item := range all {
+			dists[i] = item.Bounds.DistanceTo(query)
+		}
+		slices.Sort(dists)
+		for i, item := range results {
+			if d := item.Bounds.DistanceTo(query); d != dists[i] {
+				t.Errorf("k=%d: result %d at distance %.4f, want %.4f", k, i, d, dists[i])
+			}
+		}
+	}
+
+	if got := tree.NearestToRect(query, 0); got == nil || len(got) != 0 {
+		t.Errorf("Expected empty non-nil result for k=0, got %v", got)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	a := randomRectTree(ChooseLeastEnlargement, 1500, 1)
+
+	// Fewer, larger rectangles in a tree of different height
+	b := NewRTree(2, 4)
+	rng := rand.New(rand.NewSource(2))
+	for i := 0; i < 300; i++ {
+		x, y := rng.Float64()*1000, rng.Float64()*1000
+		b.Insert(&Item{Bounds: NewRectangle(x, y, x+rng.Float64()*40, y+rng.Float64()*40), Data: i})
+	}
+	if a.Height() == b.Height() {
+		t.Fatalf("Expected trees of different height, both are %d", a.Height())
+	}
+
+	type pair struct{ x, y *Item }
+	want := map[pair]bool{}
+	for _, x := range a.All() {
+		for _, y := range b.All() {
+			if x.Bounds.Intersects(y.Bounds) {
+				want[pair{x, y}] = true
+			}
+		}
+	}
+	if len(want) == 0 {
+		t.Fatal("Test data has no intersecting pairs")
+	}
+
+	got := map[pair]bool{}
+	Join(a, b, func(x, y *Item) {
+		p := pair{x, y}
+		if got[p] {
+			t.Errorf("Pair %v, %v reported twice", x.Data, y.Data)
+		}
+		got[p] = true
+	})
+	if len(got) != len(want) {
+		t.Errorf("Join reported %d pairs, want %d", len(got), len(want))
+	}
+	for p := range want {
+		if !got[p] {
+			t.Errorf("Join missed pair %v, %v", p.x.Data, p.y.Data)
+		}
+	}
+
+	Join(a, NewRTree(2, 4), func(x, y *Item) {
+		t.Errorf("Join with an empty tree reported %v, %v", x.Data, y.Data)
+	})
+}
+
+func TestSearchSorted(t *testing.T) {
+	query := NewRectangle(100, 100, 400, 400)
+
+	// The same items inserted in different orders give differently shaped
+	// trees, but the same sorted result
+	var want []int
+	for seed := int64(1); seed <= 3; seed++ {
+		tree := NewRTree(2, 4)
+		rng := rand.New(rand.NewSource(seed))
+		for _, i := range rng.Perm(500) {
+			x, y := float64(i%25)*20, float64(i/25)*20
+			tree.Insert(&Item{Bounds: NewRectangle(x, y, x+5, y+5), Data: i})
+		}
+
+		results := tree.SearchSorted(query, nil)
+		if len(results) != len(tree.Search(query)) {
+			t.Fatalf("SearchSorted returned %d items, Search %d", len(results), len(tree.Search(query)))
+		}
+		got := make([]int, len(results))
+		for i, item := range results {
+			got[i] = item.Data.(int)
+			if i > 0 && lessByBounds(item, results[i-1]) {
+				t.Errorf("Result %d out of order", i)
+			}
+		}
+		if want == nil {
+			want = got
+		} else if !slices.Equal(got, want) {
+			t.Errorf("Seed %d gave order %v, want %v", seed, got, want)
+		}
+	}
+
+	tree := randomRectTree(ChooseLeastEnlargement, 300, 4)
+	byData := tree.SearchSorted(NewRectangle(0, 0, 1000, 1000), func(a, b *Item) bool {
+		return a.Data.(int) > b.Data.(int)
+	})
+	for i := 1; i < len(byData); i++ {
+		if byData[i].Data.(int) > byData[i-1].Data.(int) {
+			t.Fatalf("Custom order violated at %d", i)
+		}
+	}
+}
+
+func TestTallTreeSearch(t *testing.T) {
+	const side = 200
+	tree := NewRTree(1, 2)
+	for i := 0; i < side*side; i++ {
+		x, y := float64(i%side), float64(i/side)
+		tree.Insert(&Item{Bounds: NewPoint(x, y), Data: i})
+	}
+	if tree.Height() < 12 {
+		t.Fatalf("Expected a tall tree, got height %d", tree.Height())
+	}
+
+	if got := tree.Search(NewRectangle(-1, -1, side, side)); len(got) != side*side {
+		t.Errorf("Expected all %d items, got %d", side*side, len(got))
+	}
+	if got := tree.Search(NewRectangle(10, 10, 19, 14)); len(got) != 50 {
+		t.Errorf("Expected 50 items in the window, got %d", len(got))
+	}
+	if got := tree.SearchPoint(Point{57, 3}); len(got) != 1 || got[0].Data != 3*side+57 {
+		t.Errorf("SearchPoint returned %v", got)
+	}
+	if got := tree.SearchOverlapping(NewRectangle(0, 0, 5, 5), 0); len(got) != 36 {
+		t.Errorf("Expected 36 overlapping items, got %d", len(got))
+	}
+}
+
+func TestSearchSortedByDistance(t *testing.T) {
+	tree := randomRectTree(ChooseLeastEnlargement, 2000, 5)
+	region := NewRectangle(200, 200, 500, 450)
+	from := Point{X: 480, Y: 220}
+
+	results := tree.SearchSortedByDistance(region, from)
+	if len(results) != len(tree.Search(region)) {
+		t.Fatalf("Expected %d items, got %d", len(tree.Search(region)), len(results))
+	}
+	for i, item := range results {
+		if !item.Bounds.Intersects(region) {
+			t.Errorf("Result %v lies outside the region", item.Data)
+		}
+		if i > 0 && item.Bounds.Distance(from) < results[i-1].Bounds.Distance(from) {
+			t.Errorf("Results not sorted by distance at %d", i)
+		}
+	}
+
+	if got := tree.SearchSortedByDistance(NewRectangle(2000, 2000, 3000, 3000), from); got == nil || len(got) != 0 {
+		t.Errorf("Expected empty non-nil result, got %v", got)
+	}
+}
+
+func TestNodesAtDepth(t *testing.T) {
+	tree := randomRectTree(ChooseLeastEnlargement, 3000, 6)
+	height := tree.Height()
+	if height < 3 {
+		t.Fatalf("Expected height of at least 3, got %d", height)
+	}
+
+	root := tree.NodesAtDepth(0)
+	if bounds, _ := tree.Bounds(); len(root) != 1 || root[0] != bounds {
+		t.Errorf("Expected the root bounds at depth 0, got %v", root)
+	}
+
+	prev := root
+	for depth := 1; depth < height; depth++ {
+		level := tree.NodesAtDepth(depth)
+		if len(level) < len(prev) {
+			t.Errorf("Depth %d has %d nodes, fewer than depth %d", depth, len(level), depth-1)
+		}
+		// Every cluster lies inside some cluster one level up
+		for _, r := range level {
+			if !slices.ContainsFunc(prev, func(p Rectangle) bool { return p.Contains(r) }) {
+				t.Errorf("Depth %d rectangle %v not inside any parent", depth, r)
+			}
+		}
+		prev = level
+	}
+
+	// Leaf rectangles together cover every item
+	for _, item := range tree.All() {
+		if !slices.ContainsFunc(prev, func(r Rectangle) bool { return r.Contains(item.Bounds) }) {
+			t.Fatalf("Item %v not covered by any leaf rectangle", item.Data)
+		}
+	}
+
+	for _, depth := range []int{-1, height} {
+		if got := tree.NodesAtDepth(depth); got == nil || len(got) != 0 {
+			t.Errorf("Expected empty result for depth %d, got %d rectangles", depth, len(got))
+		}
+	}
+	if got := NewRTree(2, 4).NodesAtDepth(0); len(got) != 0 {
+		t.Errorf("Expected no rectangles for an empty tree, got %v", got)
+	}
+}
+
+func TestValidateDetectsCorruption(t *testing.T) {
+	build := func() *RTree {
+		tree := randomRectTree(ChooseLeastEnlargement, 200, 7)
+		if err := tree.Validate(); err != nil {
+			t.Fatalf("Fresh tree invalid: %v", err)
+		}
+		return tree
+	}
+	if err := NewRTree(2, 4).Validate(); err != nil {
+		t.Errorf("Empty tree invalid: %v", err)
+	}
+
+	corruptions := map[string]func(tree *RTree){
+		"loose bounds": func(tree *RTree) {
+			leaf := tree.root.children[0]
+			for !leaf.isLeaf {
+				leaf = leaf.children[0]
+			}
+			leaf.bounds.MaxX += 1
+		},
+		"wrong parent": func(tree *RTree) {
+			tree.root.children[0].parent = tree.root.children[1]
+		},
+		"underfull node": func(tree *RTree) {
+			child := tree.root.children[0]
+			for !child.isLeaf {
+				child = child.children[0]
+			}
+			child.items = child.items[:1]
+		},
+		"uneven leaves": func(tree *RTree) {
+			leaf := tree.root.children[0]
+			for !leaf.isLeaf {
+				leaf = leaf.children[0]
+			}
+			leaf.parent = tree.root
+			tree.root.children[0] = leaf
+		},
+		"size mismatch": func(tree *RTree) {
+			tree.size++
+		},
+	}
+	for name, corrupt := range corruptions {
+		tree := build()
+		corrupt(tree)
+		if err := tree.Validate(); err == nil {
+			t.Errorf("Validate missed %s", name)
+		}
+	}
+}
+
+func TestNearestNeighborSeq(t *testing.T) {
+	tree := randomRectTree(ChooseLeastEnlargement, 1000, 8)
+	p := Point{X: 321, Y: 654}
+
+	var all []*Item
+	for item := range tree.NearestNeighborSeq(p) {
+		all = append(all, item)
+	}
+	if !slices.Equal(all, tree.NearestNeighbor(p, tree.Size())) {
+		t.Error("Full sequence differs from NearestNeighbor with k = Size")
+	}
+
+	var first []*Item
+	for item := range tree.NearestNeighborSeq(p) {
+		first = append(first, item)
+		if len(first) == 3 {
+			break
+		}
+	}
+	if !slices.Equal(first, tree.NearestNeighbor(p, 3)) {
+		t.Error("Stopping after 3 items differs from NearestNeighbor with k = 3")
+	}
+
+	for range NewRTree(2, 4).NearestNeighborSeq(p) {
+		t.Error("Empty tree yielded an item")
+	}
+}
+
+func TestHilbertValue(t *testing.T) {
+	extent := NewRectangle(0, 0, 4, 4)
+	cell := func(x, y float64) uint64 {
+		return HilbertValueIn(NewPoint(x, y), extent)
+	}
+
+	// The four quadrants are visited in Hilbert order: lower left, upper
+	// left, upper right, lower right
+	quadrants := []uint64{cell(1, 1), cell(1, 3), cell(3, 3), cell(3, 1)}
+	for i := 1; i < len(quadrants); i++ {
+		if quadrants[i] <= quadrants[i-1] {
+			t.Errorf("Quadrant %d has value %d, not after %d", i, quadrants[i], quadrants[i-1])
+		}
+	}
+	if cell(0, 0) != 0 {
+		t.Errorf("Expected the origin corner at 0, got %d", cell(0, 0))
+	}
+	if got := HilbertValueIn(NewPoint(-10, 100), extent); got != cell(0, 4) {
+		t.Errorf("Expected a point outside the extent to clamp to the edge, got %d", got)
+	}
+
+	// Without an extent the curve spans the whole plane, negative
+	// coordinates included, in the same quadrant order
+	whole := []uint64{
+		HilbertValue(NewPoint(-3, -3)), HilbertValue(NewPoint(-3, 3)),
+		HilbertValue(NewPoint(3, 3)), HilbertValue(NewPoint(3, -3)),
+	}
+	for i := 1; i < len(whole); i++ {
+		if whole[i] <= whole[i-1] {
+			t.Errorf("Plane quadrant %d has value %d, not after %d", i, whole[i], whole[i-1])
+		}
+	}
+	if HilbertValue(NewRectangle(0, 0, 2, 2)) != HilbertValue(NewPoint(1, 1)) {
+		t.Error("Expected HilbertValue to use the center of the rectangle")
+	}
+
+	// Coordinates beyond float32 range still get distinct, ordered cells
+	if a, b := HilbertValue(NewPoint(1e300, 0)), HilbertValue(NewPoint(1e301, 0)); a == b {
+		t.Errorf("Expected distinct values for 1e300 and 1e301, both got %d", a)
+	}
+}
+
+// totalOverlap sums the pairwise intersection areas of the children of
+// every internal node
+func totalOverlap(tree *RTree) float64 {
+	total := 0.0
+	var walk func(*Node)
+	walk = func(n *Node) {
+		for i, a := range n.children {
+			for _, b := range n.children[i+1:] {
+				if r, ok := a.bounds.Intersection(b.bounds); ok {
+					total += r.Area()
+				}
+			}
+			walk(a)
+		}
+	}
+	walk(tree.root)
+	return total
+}
+
+func TestInsertMany(t *testing.T) {
+	rng := rand.New(rand.NewSource(9))
+	randomItems := func(n, first int) []*Item {
+		items := make([]*Item, n)
+		for i := range items {
+			x, y := rng.Float64()*1000, rng.Float64()*1000
+			items[i] = &Item{Bounds: NewRectangle(x, y, x+rng.Float64()*10, y+rng.Float64()*10), Data: first + i}
+		}
+		return items
+	}
+
+	items := randomItems(5000, 0)
+	naive, batched := NewRTree(4, 16), NewRTree(4, 16)
+	for _, item := range items {
+		naive.Insert(item)
+	}
+	batched.InsertMany(items)
+
+	if err := batched.Validate(); err != nil {
+		t.Fatalf("Invalid tree after InsertMany: %v", err)
+	}
+	if batched.Size() != len(items) {
+		t.Fatalf("Expected %d items, got %d", len(items), batched.Size())
+	}
+	if items[0].Data != 0 || items[len(items)-1].Data != len(items)-1 {
+		t.Error("InsertMany reordered the caller's slice")
+	}
+	// Packing an empty tree leaves siblings overlapping far less
+	got, want := totalOverlap(batched), totalOverlap(naive)
+	if got > want*0.75 {
+		t.Errorf("Node overlap from empty: InsertMany %.0f, naive %.0f, want at least a quarter less", got, want)
+	}
+	query := NewRectangle(100, 100, 300, 300)
+	if batched.searchVisits(query) >= naive.searchVisits(query) {
+		t.Errorf("Packed tree visits %d nodes for a query, naive %d", batched.searchVisits(query), naive.searchVisits(query))
+	}
+	if len(batched.Search(query)) != len(naive.Search(query)) {
+		t.Error("InsertMany tree returns different search results")
+	}
+
+	// A populated tree takes the batch one item at a time in curve order,
+	// leaving about as much overlap as arbitrary order
+	more := randomItems(5000, len(items))
+	for _, item := range more {
+		naive.Insert(item)
+	}
+	batched.InsertMany(more)
+	if err := batched.Validate(); err != nil || batched.Size() != len(items)+len(more) {
+		t.Fatalf("Adding to a non-empty tree: size %d, %v", batched.Size(), err)
+	}
+	plain, sorted := NewRTree(4, 16), NewRTree(4, 16)
+	for _, item := range items {
+		plain.Insert(item)
+		sorted.Insert(item)
+	}
+	for _, item := range more {
+		plain.Insert(item)
+	}
+	sorted.InsertMany(more)
+	if got, want := totalOverlap(sorted), totalOverlap(plain); got > want*1.1 {
+		t.Errorf("Node overlap after a batch into a populated tree: InsertMany %.0f, naive %.0f", got, want)
+	}
+	if len(batched.Search(query)) != len(naive.Search(query)) {
+		t.Error("InsertMany tree returns different search results")
+	}
+
+	batched.InsertMany(nil)
+	if batched.Size() != len(items)+len(more) {
+		t.Error("Empty batch changed the tree")
+	}
+
+	// Packing keeps every node within its entry limits for any batch size
+	for _, limits := range [][2]int{{2, 4}, {3, 9}, {4, 16}} {
+		for n := 1; n <= 300; n++ {
+			tree := NewRTree(limits[0], limits[1])
+			tree.InsertMany(randomItems(n, 0))
+			if err := tree.Validate(); err != nil || tree.Size() != n {
+				t.Fatalf("Packing %d items with limits %v: size %d, %v", n, limits, tree.Size(), err)
+			}
+		}
+	}
+}
+
+func TestKthNearestDistance(t *testing.T) {
+	tree := randomRectTree(ChooseLeastEnlargement, 500, 10)
+	p := Point{X: 500, Y: 500}
+
+	for _, k := range []int{1, 2, 10, 500} {
+		got, ok := tree.KthNearestDistance(p, k)
+		want := tree.NearestNeighbor(p, k)[k-1].Bounds.Distance(p)
+		if !ok || got != want {
+			t.Errorf("KthNearestDistance(k=%d) = %.4f, %v; want %.4f", k, got, ok, want)
+		}
+	}
+	for _, k := range []int{0, -1, 501} {
+		if _, ok := tree.KthNearestDistance(p, k); ok {
+			t.Errorf("Expected false for k=%d", k)
+		}
+	}
+	if _, ok := NewRTree(2, 4).KthNearestDistance(p, 1); ok {
+		t.Error("Expected false for an empty tree")
+	}
+}
+
+func TestInsertWithChooser(t *testing.T) {
+	tree := NewRTree(2, 4)
+	rng := rand.New(rand.NewSource(21))
+
+	calls := 0
+	last := func(candidates []Rectangle, itemBounds Rectangle) int {
+		calls++
+		if len(candidates) < 2 {
+			t.Errorf("Chooser got %d candidates", len(candidates))
+		}
+		return len(candidates) - 1
+	}
+
+	var items []*Item
+	for i := 0; i < 200; i++ {
+		x, y := rng.Float64()*100, rng.Float64()*100
+		item := &Item{Bounds: NewRectangle(x, y, x+1, y+1), Data: i}
+		items = append(items, item)
+		tree.InsertWithChooser(item, last)
+	}
+
+	if calls == 0 {
+		t.Error("Chooser was never called")
+	}
+	if tree.Size() != len(items) {
+		t.Errorf("Size() = %d, want %d", tree.Size(), len(items))
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range items {
+		found := false
+		for _, got := range tree.Search(item.Bounds) {
+			found = found || got == item
+		}
+		if !found {
+			t.Fatalf("Item %v not found", item.Data)
+		}
+	}
+}
+
+func TestClear(t *testing.T) {
+	tree := NewRTree(4, 16)
+	items := make([]*Item, 2000)
+	for i := range items {
+		x, y := float64(i%50), float64(i/50)
+		items[i] = &Item{Bounds: NewRectangle(x, y, x+1, y+1), Data: i}
+		tree.Insert(items[i])
+	}
+	held := tree.Search(NewRectangle(0, 0, 5, 5))
+
+	tree.Clear()
+	if tree.Size() != 0 || tree.Height() != 1 {
+		t.Errorf("After Clear: Size() = %d, Height() = %d", tree.Size(), tree.Height())
+	}
+	if got := tree.Search(NewRectangle(0, 0, 100, 100)); len(got) != 0 {
+		t.Errorf("Cleared tree returned %d items", len(got))
+	}
+	for _, item := range held {
+		if item.Bounds.MaxX > 6 || item.Data == nil {
+			t.Fatalf("Clear modified item %+v", item)
+		}
+	}
+
+	refill := func() {
+		tree.Clear()
+		for _, item := range items {
+			tree.Insert(item)
+		}
+	}
+	refill()
+	if err := tree.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if got := tree.Search(NewRectangle(0, 0, 5, 5)); len(got) != len(held) {
+		t.Errorf("Refilled tree found %d items, want %d", len(got), len(held))
+	}
+
+	reused := testing.AllocsPerRun(5, refill)
+	fresh := testing.AllocsPerRun(5, func() {
+		tree = NewRTree(4, 16)
+		for _, item := range items {
+			tree.Insert(item)
+		}
+	})
+	if reused*4 > fresh {
+		t.Errorf("Refilling after Clear allocated %v times, building anew %v", reused, fresh)
+	}
+
+	// After a small tree replaces the large one, the next Clear keeps only
+	// the small tree's nodes
+	tree.Clear()
+	for _, item := range items[:20] {
+		tree.Insert(item)
+	}
+	nodes := 0
+	for d := range tree.Height() {
+		nodes += len(tree.NodesAtDepth(d))
+	}
+	tree.Clear()
+	if len(tree.free) >= nodes || cap(tree.free) > 2*nodes {
+		t.Errorf("Pool holds %d nodes (capacity %d) after clearing a tree of %d", len(tree.free), cap(tree.free), nodes)
+	}
+}
+
+func TestSearchWithCoverage(t *testing.T) {
+	tree := NewRTree(2, 4)
+	// Two overlapping squares and one outside the query
+	tree.Insert(&Item{Bounds: NewRectangle(0, 0, 2, 2)})
+	tree.Insert(&Item{Bounds: NewRectangle(1, 1, 3, 3)})
+	tree.Insert(&Item{Bounds: NewRectangle(20, 20, 21, 21)})
+
+	tests := []struct {
+		query Rectangle
+		items int
+		want  float64
+	}{
+		{NewRectangle(0, 0, 2, 2), 2, 1},
+		{NewRectangle(0, 0, 4, 4), 2, 7.0 / 16},
+		{NewRectangle(-2, 0, 2, 2), 2, 0.5},
+		{NewRectangle(5, 5, 6, 6), 0, 0},
+		{NewRectangle(1, 1, 1, 1), 2, 1},
+		{NewRectangle(10, 10, 10, 10), 0, 0},
+	}
+	for _, tt := range tests {
+		items, coverage := tree.SearchWithCoverage(tt.query)
+		if len(items) != tt.items || math.Abs(coverage-tt.want) > 1e-9 {
+			t.Errorf("SearchWithCoverage(%v) = %d items, %.4f; want %d, %.4f",
+				tt.query, len(items), coverage, tt.items, tt.want)
+		}
+	}
+}
+
+func TestRectangleIntersection(t *testing.T) {
+	a := NewRectangle(0, 0, 4, 4)
+	tests := []struct {
+		other Rectangle
+		want  Rectangle
+		ok    bool
+	}{
+		{NewRectangle(2, 2, 6, 6), NewRectangle(2, 2, 4, 4), true},
+		{NewRectangle(1, 1, 2, 2), NewRectangle(1, 1, 2, 2), true},
+		{NewRectangle(4, 0, 5, 4), NewRectangle(4, 0, 4, 4), true},
+		{NewRectangle(5, 5, 6, 6), Rectangle{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := a.Intersection(tt.other)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Intersection(%v) = %v, %v; want %v, %v", tt.other, got, ok, tt.want, tt.ok)
+		}
+		if back, _ := tt.other.Intersection(a); back != got {
+			t.Errorf("Intersection is not symmetric for %v", tt.other)
+		}
+	}
+}
+
+func TestSearchClipped(t *testing.T) {
+	tree := randomRectTree(ChooseLeastEnlargement, 300, 4)
+	viewport := NewRectangle(200, 200, 500, 450)
+
+	results := tree.SearchClipped(viewport)
+	items := tree.Search(viewport)
+	if len(results) != len(items) {
+		t.Fatalf("SearchClipped returned %d results, Search %d", len(results), len(items))
+	}
+	for i, r := range results {
+		if r.Item != items[i] {
+			t.Fatalf("Result %d differs from Search", i)
+		}
+		if !viewport.Contains(r.Clipped) || !r.Item.Bounds.Contains(r.Clipped) {
+			t.Errorf("Clipped bounds %v not inside both %v and the viewport", r.Clipped, r.Item.Bounds)
+		}
+		if want := r.Item.Bounds.IntersectionArea(viewport); math.Abs(r.Clipped.Area()-want) > 1e-9 {
+			t.Errorf("Clipped area %.4f, want %.4f", r.Clipped.Area(), want)
+		}
+	}
+}
+
+func TestSearchStream(t *testing.T) {
+	tree := randomRectTree(ChooseLeastEnlargement, 2000, 9)
+	query := NewRectangle(100, 100, 300, 250)
+
+	ch, stats := tree.SearchStream(context.Background(), query)
+	var got []*Item
+	for item := range ch {
+		got = append(got, item)
+	}
+	want := tree.Search(query)
+	if len(got) != len(want) {
+		t.Fatalf("SearchStream sent %d items, Search found %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Item %d differs from Search", i)
+		}
+	}
+
+	s := stats()
+	if s.NodesVisited != tree.searchVisits(query) {
+		t.Errorf("NodesVisited = %d, want %d", s.NodesVisited, tree.searchVisits(query))
+	}
+	if s.LeavesScanned == 0 || s.LeavesScanned >= s.NodesVisited || s.NodesPruned == 0 {
+		t.Errorf("Implausible stats %+v", s)
+	}
+
+	// A query missing everything prunes the root itself
+	ch, stats = tree.SearchStream(context.Background(), NewRectangle(5000, 5000, 5001, 5001))
+	for range ch {
+		t.Error("Expected no items")
+	}
+	if s := stats(); s != (SearchStats{NodesPruned: 1}) {
+		t.Errorf("Disjoint query stats = %+v", s)
+	}
+}
+
+func TestSearchStreamCancel(t *testing.T) {
+	tree := randomRectTree(ChooseLeastEnlargement, 2000, 9)
+	query, _ := tree.Bounds()
+	total := len(tree.Search(query))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, stats := tree.SearchStream(ctx, query)
+	for i := 0; i < 10; i++ {
+		<-ch
+	}
+	cancel()
+
+	// Returns only once the goroutine has given up on the blocked send
+	s := stats()
+	if s.LeavesScanned == 0 {
+		t.Errorf("Stats after cancellation = %+v", s)
+	}
+	received := 10
+	for range ch {
+		received++
+	}
+	if received >= total {
+		t.Errorf("Stream should stop after cancellation, received %d of %d items", received, total)
+	}
+
+	// Called before the stream is drained, stats waits for the search to end
+	ch, stats = tree.SearchStream(context.Background(), query)
+	result := make(chan SearchStats)
+	go func() { result <- stats() }()
+	select {
+	case s := <-result:
+		t.Fatalf("stats returned %+v before the stream was drained", s)
+	case <-time.After(10 * time.Millisecond):
+	}
+	received = 0
+	for range ch {
+		received++
+	}
+	if s := <-result; received != total || s.NodesVisited != tree.searchVisits(query) {
+		t.Errorf("Drained %d of %d items, stats %+v", received, total, s)
+	}
+}
+
 // BenchmarkInsert benchmarks insertion performance
 func BenchmarkInsert(b *testing.B) {
 	tree := NewRTree(4, 16)
@@ -504,3 +1910,54 @@ func BenchmarkNearestNeighbor(b *testing.B) {
 		tree.NearestNeighbor(Point{x, y}, 10)
 	}
 }
+
+// BenchmarkSearchChooseStrategy compares search cost for trees built with
+// each ChooseStrategy, reporting nodes visited per query
+func BenchmarkSearchChooseStrategy(b *testing.B) {
+	strategies := []struct {
+		name     string
+		strategy ChooseStrategy
+	}{
+		{"LeastEnlargement", ChooseLeastEnlargement},
+		{"LeastOverlap", ChooseLeastOverlap},
+	}
+	for _, s := range strategies {
+		b.Run(s.name, func(b *testing.B) {
+			tree := randomRectTree(s.strategy, 10000, 1)
+			rng := rand.New(rand.NewSource(2))
+			visits := 0
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				x, y := rng.Float64()*1000, rng.Float64()*1000
+				query := NewRectangle(x, y, x+20, y+20)
+				tree.Search(query)
+				b.StopTimer()
+				visits += tree.searchVisits(query)
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(visits)/float64(b.N), "nodes/op")
+		})
+	}
+}
+
+// BenchmarkNearestNeighborLarge compares the heap-based search with the
+// original linear-scan queue at k=100 over 100k items
+func BenchmarkNearestNeighborLarge(b *testing.B) {
+	tree := NewRTree(4, 16)
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100000; i++ {
+		tree.Insert(&Item{Bounds: NewPoint(rng.Float64()*1000, rng.Float64()*1000), Data: i})
+	}
+
+	b.Run("Heap", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			tree.NearestNeighbor(Point{float64(i % 1000), float64(i % 1000)}, 100)
+		}
+	})
+	b.Run("Linear", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			tree.linearNearestNeighbor(Point{float64(i % 1000), float64(i % 1000)}, 100)
+		}
+	})
+}